@@ -0,0 +1,1088 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Binary log event types, see the MySQL source libbinlogevents/include/binlog_event.h.
+const (
+	binlogQueryEvent             = 2
+	binlogStopEvent              = 3
+	binlogRotateEvent            = 4
+	binlogFormatDescriptionEvent = 15
+	binlogXidEvent               = 16
+	binlogTableMapEvent          = 19
+	binlogWriteRowsEventV1       = 23
+	binlogUpdateRowsEventV1      = 24
+	binlogDeleteRowsEventV1      = 25
+	binlogRowsQueryEvent         = 29
+	binlogWriteRowsEvent         = 30
+	binlogUpdateRowsEvent        = 31
+	binlogDeleteRowsEvent        = 32
+	binlogPartialUpdateRowsEvent = 39
+	binlogTransactionPayload     = 40
+)
+
+// Column types of a table map event.
+const (
+	mysqlTypeDecimal    = 0
+	mysqlTypeTiny       = 1
+	mysqlTypeShort      = 2
+	mysqlTypeLong       = 3
+	mysqlTypeFloat      = 4
+	mysqlTypeDouble     = 5
+	mysqlTypeNull       = 6
+	mysqlTypeTimestamp  = 7
+	mysqlTypeLongLong   = 8
+	mysqlTypeInt24      = 9
+	mysqlTypeDate       = 10
+	mysqlTypeTime       = 11
+	mysqlTypeDatetime   = 12
+	mysqlTypeYear       = 13
+	mysqlTypeNewDate    = 14
+	mysqlTypeVarchar    = 15
+	mysqlTypeBit        = 16
+	mysqlTypeTimestamp2 = 17
+	mysqlTypeDatetime2  = 18
+	mysqlTypeTime2      = 19
+	mysqlTypeJSON       = 245
+	mysqlTypeNewDecimal = 246
+	mysqlTypeEnum       = 247
+	mysqlTypeSet        = 248
+	mysqlTypeTinyBlob   = 249
+	mysqlTypeMediumBlob = 250
+	mysqlTypeLongBlob   = 251
+	mysqlTypeBlob       = 252
+	mysqlTypeVarString  = 253
+	mysqlTypeString     = 254
+	mysqlTypeGeometry   = 255
+)
+
+const (
+	binlogHeaderSize   = 19
+	binlogChecksumSize = 4
+)
+
+var binlogMagic = []byte{0xfe, 'b', 'i', 'n'}
+
+var errBinlogTruncated = errors.New("truncated binlog event")
+
+// binlogEvent is one event with the checksum removed from Body.
+type binlogEvent struct {
+	Timestamp uint32
+	Type      byte
+	ServerID  uint32
+	Body      []byte
+}
+
+// binlogReader reads the events of a v4 binary log file.
+type binlogReader struct {
+	r        *bufio.Reader
+	checksum bool
+}
+
+// newBinlogReader checks the magic number and returns a reader positioned at
+// the first event.
+func newBinlogReader(r io.Reader) (*binlogReader, error) {
+	br := bufio.NewReaderSize(r, 1024*1024)
+	magic := make([]byte, len(binlogMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != string(binlogMagic) {
+		return nil, errors.New("not a binary log file")
+	}
+	return &binlogReader{r: br}, nil
+}
+
+// Next returns the next event, io.EOF at the end of the file.
+func (b *binlogReader) Next() (*binlogEvent, error) {
+	header := make([]byte, binlogHeaderSize)
+	if _, err := io.ReadFull(b.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// the last event was still being written
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[9:])
+	if size < binlogHeaderSize {
+		return nil, fmt.Errorf("invalid binlog event size %d", size)
+	}
+	body := make([]byte, size-binlogHeaderSize)
+	if _, err := io.ReadFull(b.r, body); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	ev := &binlogEvent{
+		Timestamp: binary.LittleEndian.Uint32(header),
+		Type:      header[4],
+		ServerID:  binary.LittleEndian.Uint32(header[5:]),
+		Body:      body,
+	}
+	if ev.Type == binlogFormatDescriptionEvent {
+		b.checksum = formatDescriptionChecksum(body)
+		if hasChecksumAlgorithm(body) {
+			// checksum algorithm and checksum value
+			ev.Body = body[:len(body)-1-binlogChecksumSize]
+		}
+		return ev, nil
+	}
+	if b.checksum {
+		if len(body) < binlogChecksumSize {
+			return nil, errBinlogTruncated
+		}
+		ev.Body = body[:len(body)-binlogChecksumSize]
+	}
+	return ev, nil
+}
+
+// hasChecksumAlgorithm reports whether the format description event was
+// written by a server of 5.6.1 or later, which appends the checksum
+// algorithm to it.
+func hasChecksumAlgorithm(body []byte) bool {
+	if len(body) < 2+50 {
+		return false
+	}
+	version := string(body[2:52])
+	if i := strings.IndexByte(version, 0); i >= 0 {
+		version = version[:i]
+	}
+	var major, minor, patch int
+	fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch)
+	return major*10000+minor*100+patch >= 50601
+}
+
+func formatDescriptionChecksum(body []byte) bool {
+	if !hasChecksumAlgorithm(body) || len(body) < 1+binlogChecksumSize {
+		return false
+	}
+	return body[len(body)-1-binlogChecksumSize] == 1 // CRC32
+}
+
+// binlogQuery is the content of a query event.
+type binlogQuery struct {
+	ThreadID  uint32
+	ErrorCode uint16
+	DB        string
+	SQL       string
+}
+
+func parseBinlogQuery(body []byte) (*binlogQuery, error) {
+	// thread id, exec time, db length, error code, status vars length
+	if len(body) < 13 {
+		return nil, errBinlogTruncated
+	}
+	q := &binlogQuery{
+		ThreadID:  binary.LittleEndian.Uint32(body),
+		ErrorCode: binary.LittleEndian.Uint16(body[9:]),
+	}
+	dbLen := int(body[8])
+	pos := 13 + int(binary.LittleEndian.Uint16(body[11:]))
+	if pos+dbLen+1 > len(body) {
+		return nil, errBinlogTruncated
+	}
+	q.DB = string(body[pos : pos+dbLen])
+	q.SQL = string(body[pos+dbLen+1:])
+	return q, nil
+}
+
+// binlogTable is the table map of a rows event. Column names, signedness
+// and the primary key are only present with binlog_row_metadata=FULL
+// (MySQL 8.0.1 and later).
+type binlogTable struct {
+	ID         uint64
+	Schema     string
+	Name       string
+	Types      []byte
+	Meta       []uint16
+	Names      []string
+	Unsigned   []bool
+	PrimaryKey []int
+}
+
+// Table map optional metadata fields.
+const (
+	tableMetaSignedness   = 1
+	tableMetaColumnName   = 4
+	tableMetaSimplePK     = 8
+	tableMetaPKWithPrefix = 9
+)
+
+func parseBinlogTableMap(body []byte) (*binlogTable, error) {
+	if len(body) < 8 {
+		return nil, errBinlogTruncated
+	}
+	t := &binlogTable{ID: readUint48(body)}
+	pos := 8
+	readName := func() (string, bool) {
+		if pos >= len(body) {
+			return "", false
+		}
+		n := int(body[pos])
+		if pos+1+n+1 > len(body) {
+			return "", false
+		}
+		name := string(body[pos+1 : pos+1+n])
+		pos += 1 + n + 1
+		return name, true
+	}
+	var ok bool
+	if t.Schema, ok = readName(); !ok {
+		return nil, errBinlogTruncated
+	}
+	if t.Name, ok = readName(); !ok {
+		return nil, errBinlogTruncated
+	}
+	count, n, ok := readLenencInt(body[pos:])
+	if !ok || pos+n+int(count) > len(body) {
+		return nil, errBinlogTruncated
+	}
+	pos += n
+	t.Types = body[pos : pos+int(count)]
+	pos += int(count)
+
+	metaLen, n, ok := readLenencInt(body[pos:])
+	if !ok || pos+n+int(metaLen) > len(body) {
+		return nil, errBinlogTruncated
+	}
+	pos += n
+	meta := body[pos : pos+int(metaLen)]
+	pos += int(metaLen)
+	t.Meta = make([]uint16, len(t.Types))
+	mp := 0
+	for i, typ := range t.Types {
+		switch typ {
+		case mysqlTypeFloat, mysqlTypeDouble, mysqlTypeBlob, mysqlTypeGeometry, mysqlTypeJSON,
+			mysqlTypeTimestamp2, mysqlTypeDatetime2, mysqlTypeTime2:
+			if mp+1 > len(meta) {
+				return nil, errBinlogTruncated
+			}
+			t.Meta[i] = uint16(meta[mp])
+			mp++
+		case mysqlTypeVarchar, mysqlTypeVarString, mysqlTypeBit:
+			if mp+2 > len(meta) {
+				return nil, errBinlogTruncated
+			}
+			t.Meta[i] = binary.LittleEndian.Uint16(meta[mp:])
+			mp += 2
+		case mysqlTypeNewDecimal, mysqlTypeString, mysqlTypeEnum, mysqlTypeSet:
+			// precision and scale, or real type and length
+			if mp+2 > len(meta) {
+				return nil, errBinlogTruncated
+			}
+			t.Meta[i] = uint16(meta[mp])<<8 | uint16(meta[mp+1])
+			mp += 2
+		}
+	}
+
+	// null bitmap
+	pos += (len(t.Types) + 7) / 8
+	if pos < len(body) {
+		t.parseOptionalMetadata(body[pos:])
+	}
+	return t, nil
+}
+
+func (t *binlogTable) parseOptionalMetadata(data []byte) {
+	for len(data) > 0 {
+		typ := data[0]
+		length, n, ok := readLenencInt(data[1:])
+		if !ok || 1+n+int(length) > len(data) {
+			return
+		}
+		value := data[1+n : 1+n+int(length)]
+		data = data[1+n+int(length):]
+		switch typ {
+		case tableMetaSignedness:
+			t.Unsigned = make([]bool, len(t.Types))
+			bit := 0
+			for i, colType := range t.Types {
+				if !isNumericColumn(colType) {
+					continue
+				}
+				if bit/8 < len(value) {
+					t.Unsigned[i] = value[bit/8]&(0x80>>(bit%8)) != 0
+				}
+				bit++
+			}
+		case tableMetaColumnName:
+			var names []string
+			for len(value) > 0 {
+				l, n, ok := readLenencInt(value)
+				if !ok || n+int(l) > len(value) {
+					break
+				}
+				names = append(names, string(value[n:n+int(l)]))
+				value = value[n+int(l):]
+			}
+			if len(names) == len(t.Types) {
+				t.Names = names
+			}
+		case tableMetaSimplePK, tableMetaPKWithPrefix:
+			for len(value) > 0 {
+				col, n, ok := readLenencInt(value)
+				if !ok {
+					break
+				}
+				value = value[n:]
+				if typ == tableMetaPKWithPrefix {
+					_, n, ok = readLenencInt(value)
+					if !ok {
+						break
+					}
+					value = value[n:]
+				}
+				t.PrimaryKey = append(t.PrimaryKey, int(col))
+			}
+		}
+	}
+}
+
+func isNumericColumn(typ byte) bool {
+	switch typ {
+	case mysqlTypeTiny, mysqlTypeShort, mysqlTypeInt24, mysqlTypeLong, mysqlTypeLongLong,
+		mysqlTypeFloat, mysqlTypeDouble, mysqlTypeNewDecimal, mysqlTypeDecimal:
+		return true
+	}
+	return false
+}
+
+// binlogRows is a decoded rows event. Values are SQL literals, indexed by
+// column; columns missing from the row image are left empty. For an update
+// the rows alternate between the before and the after image.
+type binlogRows struct {
+	TableID  uint64
+	Present  []bool
+	Present2 []bool
+	Rows     [][]string
+}
+
+func isUpdateRowsEvent(typ byte) bool {
+	return typ == binlogUpdateRowsEvent || typ == binlogUpdateRowsEventV1
+}
+
+func parseBinlogRows(typ byte, body []byte, tables map[uint64]*binlogTable) (*binlogRows, *binlogTable, error) {
+	if len(body) < 8 {
+		return nil, nil, errBinlogTruncated
+	}
+	rows := &binlogRows{TableID: readUint48(body)}
+	pos := 8
+	if typ >= binlogWriteRowsEvent {
+		if pos+2 > len(body) {
+			return nil, nil, errBinlogTruncated
+		}
+		// the length of the extra data includes its own two bytes
+		pos += int(binary.LittleEndian.Uint16(body[pos:]))
+	}
+	table := tables[rows.TableID]
+	if table == nil {
+		return nil, nil, fmt.Errorf("no table map for table id %d", rows.TableID)
+	}
+	if pos > len(body) {
+		return nil, nil, errBinlogTruncated
+	}
+	count, n, ok := readLenencInt(body[pos:])
+	if !ok || int(count) != len(table.Types) {
+		return nil, nil, fmt.Errorf("column count of table %s.%s does not match its table map", table.Schema, table.Name)
+	}
+	pos += n
+	readBitmap := func() ([]bool, error) {
+		size := (int(count) + 7) / 8
+		if pos+size > len(body) {
+			return nil, errBinlogTruncated
+		}
+		bits := make([]bool, count)
+		for i := range bits {
+			bits[i] = body[pos+i/8]&(1<<(i%8)) != 0
+		}
+		pos += size
+		return bits, nil
+	}
+	var err error
+	if rows.Present, err = readBitmap(); err != nil {
+		return nil, nil, err
+	}
+	rows.Present2 = rows.Present
+	if isUpdateRowsEvent(typ) {
+		if rows.Present2, err = readBitmap(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	image := 0
+	for pos < len(body) {
+		present := rows.Present
+		if image%2 == 1 && isUpdateRowsEvent(typ) {
+			present = rows.Present2
+		}
+		image++
+		row, n, err := decodeBinlogRow(body[pos:], table, present)
+		if err != nil {
+			return nil, nil, fmt.Errorf("table %s.%s: %v", table.Schema, table.Name, err)
+		}
+		pos += n
+		rows.Rows = append(rows.Rows, row)
+	}
+	return rows, table, nil
+}
+
+// decodeBinlogRow decodes one row image and returns the number of bytes it
+// used.
+func decodeBinlogRow(data []byte, table *binlogTable, present []bool) ([]string, int, error) {
+	presentCount := 0
+	for _, p := range present {
+		if p {
+			presentCount++
+		}
+	}
+	pos := (presentCount + 7) / 8
+	if pos > len(data) {
+		return nil, 0, errBinlogTruncated
+	}
+	nulls := data[:pos]
+	row := make([]string, len(table.Types))
+	bit := 0
+	for i, p := range present {
+		if !p {
+			continue
+		}
+		isNull := nulls[bit/8]&(1<<(bit%8)) != 0
+		bit++
+		if isNull {
+			row[i] = "NULL"
+			continue
+		}
+		unsigned := table.Unsigned != nil && table.Unsigned[i]
+		value, n, err := decodeBinlogValue(data[pos:], table.Types[i], table.Meta[i], unsigned)
+		if err != nil {
+			return nil, 0, err
+		}
+		row[i] = value
+		pos += n
+	}
+	return row, pos, nil
+}
+
+// decodeBinlogValue renders one column value of a row image as a SQL literal.
+func decodeBinlogValue(data []byte, typ byte, meta uint16, unsigned bool) (string, int, error) {
+	need := func(n int) error {
+		if n > len(data) {
+			return errBinlogTruncated
+		}
+		return nil
+	}
+
+	if typ == mysqlTypeString {
+		// the real type and the length are packed into the metadata
+		b0, b1 := byte(meta>>8), byte(meta)
+		if b0&0x30 != 0x30 {
+			typ = b0 | 0x30
+			meta = uint16(b1) | uint16((b0&0x30)^0x30)<<4
+		} else {
+			typ = b0
+			meta = uint16(b1)
+		}
+	}
+
+	switch typ {
+	case mysqlTypeTiny:
+		if err := need(1); err != nil {
+			return "", 0, err
+		}
+		if unsigned {
+			return strconv.FormatUint(uint64(data[0]), 10), 1, nil
+		}
+		return strconv.FormatInt(int64(int8(data[0])), 10), 1, nil
+	case mysqlTypeShort:
+		if err := need(2); err != nil {
+			return "", 0, err
+		}
+		v := binary.LittleEndian.Uint16(data)
+		if unsigned {
+			return strconv.FormatUint(uint64(v), 10), 2, nil
+		}
+		return strconv.FormatInt(int64(int16(v)), 10), 2, nil
+	case mysqlTypeInt24:
+		if err := need(3); err != nil {
+			return "", 0, err
+		}
+		v := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+		if unsigned {
+			return strconv.FormatUint(uint64(v), 10), 3, nil
+		}
+		return strconv.FormatInt(int64(int32(v<<8)>>8), 10), 3, nil
+	case mysqlTypeLong:
+		if err := need(4); err != nil {
+			return "", 0, err
+		}
+		v := binary.LittleEndian.Uint32(data)
+		if unsigned {
+			return strconv.FormatUint(uint64(v), 10), 4, nil
+		}
+		return strconv.FormatInt(int64(int32(v)), 10), 4, nil
+	case mysqlTypeLongLong:
+		if err := need(8); err != nil {
+			return "", 0, err
+		}
+		v := binary.LittleEndian.Uint64(data)
+		if unsigned {
+			return strconv.FormatUint(v, 10), 8, nil
+		}
+		return strconv.FormatInt(int64(v), 10), 8, nil
+	case mysqlTypeFloat:
+		if err := need(4); err != nil {
+			return "", 0, err
+		}
+		v := math.Float32frombits(binary.LittleEndian.Uint32(data))
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), 4, nil
+	case mysqlTypeDouble:
+		if err := need(8); err != nil {
+			return "", 0, err
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(data))
+		return strconv.FormatFloat(v, 'g', -1, 64), 8, nil
+	case mysqlTypeNewDecimal:
+		return decodeBinlogDecimal(data, int(meta>>8), int(meta&0xff))
+	case mysqlTypeYear:
+		if err := need(1); err != nil {
+			return "", 0, err
+		}
+		if data[0] == 0 {
+			return "0", 1, nil
+		}
+		return strconv.Itoa(1900 + int(data[0])), 1, nil
+	case mysqlTypeDate, mysqlTypeNewDate:
+		if err := need(3); err != nil {
+			return "", 0, err
+		}
+		v := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+		return fmt.Sprintf("'%04d-%02d-%02d'", v>>9, (v>>5)&15, v&31), 3, nil
+	case mysqlTypeTime:
+		if err := need(3); err != nil {
+			return "", 0, err
+		}
+		v := int32(uint32(data[0])|uint32(data[1])<<8|uint32(data[2])<<16) << 8 >> 8
+		sign := ""
+		if v < 0 {
+			sign, v = "-", -v
+		}
+		return fmt.Sprintf("'%s%02d:%02d:%02d'", sign, v/10000, v/100%100, v%100), 3, nil
+	case mysqlTypeDatetime:
+		if err := need(8); err != nil {
+			return "", 0, err
+		}
+		v := binary.LittleEndian.Uint64(data)
+		d, t := v/1000000, v%1000000
+		return fmt.Sprintf("'%04d-%02d-%02d %02d:%02d:%02d'", d/10000, d/100%100, d%100, t/10000, t/100%100, t%100), 8, nil
+	case mysqlTypeTimestamp:
+		if err := need(4); err != nil {
+			return "", 0, err
+		}
+		return binlogTimestampLiteral(int64(binary.LittleEndian.Uint32(data)), 0, 0), 4, nil
+	case mysqlTypeTimestamp2:
+		fracLen := (int(meta) + 1) / 2
+		if err := need(4 + fracLen); err != nil {
+			return "", 0, err
+		}
+		frac := readFractionalSeconds(data[4:4+fracLen], int(meta))
+		return binlogTimestampLiteral(int64(binary.BigEndian.Uint32(data)), frac, int(meta)), 4 + fracLen, nil
+	case mysqlTypeDatetime2:
+		fracLen := (int(meta) + 1) / 2
+		if err := need(5 + fracLen); err != nil {
+			return "", 0, err
+		}
+		packed := readUintBE(data[:5]) - 0x8000000000
+		frac := readFractionalSeconds(data[5:5+fracLen], int(meta))
+		return "'" + formatPackedDatetime(int64(packed), frac, int(meta)) + "'", 5 + fracLen, nil
+	case mysqlTypeTime2:
+		fracLen := (int(meta) + 1) / 2
+		if err := need(3 + fracLen); err != nil {
+			return "", 0, err
+		}
+		return "'" + decodeTime2(data[:3+fracLen], int(meta)) + "'", 3 + fracLen, nil
+	case mysqlTypeVarchar, mysqlTypeVarString:
+		lenBytes := 1
+		if meta > 255 {
+			lenBytes = 2
+		}
+		return decodeBinlogString(data, lenBytes)
+	case mysqlTypeBlob, mysqlTypeTinyBlob, mysqlTypeMediumBlob, mysqlTypeLongBlob:
+		return decodeBinlogString(data, int(meta))
+	case mysqlTypeString:
+		lenBytes := 1
+		if meta > 255 {
+			lenBytes = 2
+		}
+		return decodeBinlogString(data, lenBytes)
+	case mysqlTypeGeometry:
+		if err := need(int(meta)); err != nil {
+			return "", 0, err
+		}
+		length := int(readUintLE(data[:meta]))
+		if err := need(int(meta) + length); err != nil {
+			return "", 0, err
+		}
+		return fmt.Sprintf("x'%x'", data[meta:int(meta)+length]), int(meta) + length, nil
+	case mysqlTypeJSON:
+		if err := need(int(meta)); err != nil {
+			return "", 0, err
+		}
+		length := int(readUintLE(data[:meta]))
+		if err := need(int(meta) + length); err != nil {
+			return "", 0, err
+		}
+		text, err := decodeBinlogJSON(data[meta : int(meta)+length])
+		if err != nil {
+			return "", 0, err
+		}
+		return quoteSQLString([]byte(text)), int(meta) + length, nil
+	case mysqlTypeEnum, mysqlTypeSet:
+		// the index of the enum value or the bitmap of the set, which
+		// MySQL accepts in place of the strings
+		size := int(meta & 0xff)
+		if err := need(size); err != nil {
+			return "", 0, err
+		}
+		return strconv.FormatUint(readUintLE(data[:size]), 10), size, nil
+	case mysqlTypeBit:
+		size := int(meta>>8) + (int(meta&0xff)+7)/8
+		if err := need(size); err != nil {
+			return "", 0, err
+		}
+		return fmt.Sprintf("b'%b'", readUintBE(data[:size])), size, nil
+	case mysqlTypeNull:
+		return "NULL", 0, nil
+	}
+	return "", 0, fmt.Errorf("unsupported column type %d", typ)
+}
+
+func decodeBinlogString(data []byte, lenBytes int) (string, int, error) {
+	if lenBytes < 1 || lenBytes > 4 || lenBytes > len(data) {
+		return "", 0, errBinlogTruncated
+	}
+	length := int(readUintLE(data[:lenBytes]))
+	if lenBytes+length > len(data) {
+		return "", 0, errBinlogTruncated
+	}
+	return quoteSQLString(data[lenBytes : lenBytes+length]), lenBytes + length, nil
+}
+
+func readUint48(data []byte) uint64 {
+	return readUintLE(data[:6])
+}
+
+func readUintLE(data []byte) uint64 {
+	var v uint64
+	for i := len(data) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(data[i])
+	}
+	return v
+}
+
+func readUintBE(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// readFractionalSeconds returns the microseconds of a temporal value with
+// fsp digits stored in big-endian bytes.
+func readFractionalSeconds(data []byte, fsp int) int64 {
+	if len(data) == 0 {
+		return 0
+	}
+	v := int64(readUintBE(data))
+	switch len(data) {
+	case 1:
+		return v * 10000
+	case 2:
+		return v * 100
+	}
+	return v
+}
+
+func formatFraction(frac int64, fsp int) string {
+	if fsp <= 0 {
+		return ""
+	}
+	return "." + fmt.Sprintf("%06d", frac)[:fsp]
+}
+
+// formatPackedDatetime formats the integer part of a DATETIME2 value.
+func formatPackedDatetime(packed int64, frac int64, fsp int) string {
+	ymd := packed >> 17
+	ym := ymd >> 5
+	hms := packed & 0x1ffff
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d%s", ym/13, ym%13, ymd&31,
+		hms>>12, (hms>>6)&63, hms&63, formatFraction(frac, fsp))
+}
+
+// binlogTimestampLiteral renders a TIMESTAMP. It is stored as a Unix time,
+// FROM_UNIXTIME keeps the value whatever the time zone of the session is.
+func binlogTimestampLiteral(seconds, frac int64, fsp int) string {
+	if seconds == 0 && frac == 0 {
+		return "'0000-00-00 00:00:00'"
+	}
+	return fmt.Sprintf("FROM_UNIXTIME(%d%s)", seconds, formatFraction(frac, fsp))
+}
+
+// decodeTime2 formats a TIME2 value, see my_time_packed_from_binary and
+// TIME_from_longlong_time_packed.
+func decodeTime2(data []byte, fsp int) string {
+	var packed int64
+	intPart := int64(readUintBE(data[:3])) - 0x800000
+	switch {
+	case fsp >= 5:
+		packed = int64(readUintBE(data[:6])) - 0x800000000000
+	case fsp >= 3:
+		frac := int64(binary.BigEndian.Uint16(data[3:]))
+		if intPart < 0 && frac != 0 {
+			intPart++
+			frac -= 0x10000
+		}
+		packed = intPart<<24 + frac*100
+	case fsp >= 1:
+		frac := int64(data[3])
+		if intPart < 0 && frac != 0 {
+			intPart++
+			frac -= 0x100
+		}
+		packed = intPart<<24 + frac*10000
+	default:
+		packed = intPart << 24
+	}
+	sign := ""
+	if packed < 0 {
+		sign, packed = "-", -packed
+	}
+	hms, frac := packed>>24, packed%(1<<24)
+	return fmt.Sprintf("%s%02d:%02d:%02d%s", sign, (hms>>12)&0x3ff, (hms>>6)&63, hms&63, formatFraction(frac, fsp))
+}
+
+var decimalCompressedBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// decodeBinlogDecimal decodes a DECIMAL(precision, scale) in the binary
+// format of decimal2bin: groups of 9 digits in 4 bytes, the sign in the
+// first bit and negative numbers stored inverted.
+func decodeBinlogDecimal(data []byte, precision, scale int) (string, int, error) {
+	intg := precision - scale
+	intg0, intg0x := intg/9, intg%9
+	frac0, frac0x := scale/9, scale%9
+	size := intg0*4 + decimalCompressedBytes[intg0x] + frac0*4 + decimalCompressedBytes[frac0x]
+	if size > len(data) || size == 0 {
+		return "", 0, errBinlogTruncated
+	}
+	buf := make([]byte, size)
+	copy(buf, data[:size])
+	negative := buf[0]&0x80 == 0
+	buf[0] ^= 0x80
+	if negative {
+		for i := range buf {
+			buf[i] = ^buf[i]
+		}
+	}
+
+	var b strings.Builder
+	pos := 0
+	group := func(n, digits int) {
+		v := readUintBE(buf[pos : pos+n])
+		pos += n
+		fmt.Fprintf(&b, "%0*d", digits, v)
+	}
+	if intg0x > 0 {
+		group(decimalCompressedBytes[intg0x], intg0x)
+	}
+	for i := 0; i < intg0; i++ {
+		group(4, 9)
+	}
+	intText := strings.TrimLeft(b.String(), "0")
+	if intText == "" {
+		intText = "0"
+	}
+	b.Reset()
+	for i := 0; i < frac0; i++ {
+		group(4, 9)
+	}
+	if frac0x > 0 {
+		group(decimalCompressedBytes[frac0x], frac0x)
+	}
+	text := intText
+	if scale > 0 {
+		text += "." + b.String()
+	}
+	if negative {
+		text = "-" + text
+	}
+	return text, size, nil
+}
+
+// JSON binary format value types, see sql-common/json_binary.h.
+const (
+	jsonSmallObject = 0x00
+	jsonLargeObject = 0x01
+	jsonSmallArray  = 0x02
+	jsonLargeArray  = 0x03
+	jsonLiteral     = 0x04
+	jsonInt16       = 0x05
+	jsonUint16      = 0x06
+	jsonInt32       = 0x07
+	jsonUint32      = 0x08
+	jsonInt64       = 0x09
+	jsonUint64      = 0x0a
+	jsonDouble      = 0x0b
+	jsonString      = 0x0c
+	jsonOpaque      = 0x0f
+)
+
+// decodeBinlogJSON converts a JSON column value from the binary format to
+// its text.
+func decodeBinlogJSON(data []byte) (string, error) {
+	if len(data) == 0 {
+		// a JSON null written by a partial update
+		return "null", nil
+	}
+	var b strings.Builder
+	if err := writeBinlogJSON(&b, data[0], data[1:]); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeBinlogJSON(b *strings.Builder, typ byte, data []byte) error {
+	switch typ {
+	case jsonSmallObject, jsonLargeObject, jsonSmallArray, jsonLargeArray:
+		return writeBinlogJSONContainer(b, typ, data)
+	case jsonLiteral:
+		if len(data) < 1 {
+			return errBinlogTruncated
+		}
+		switch data[0] {
+		case 0:
+			b.WriteString("null")
+		case 1:
+			b.WriteString("true")
+		default:
+			b.WriteString("false")
+		}
+	case jsonInt16, jsonUint16:
+		if len(data) < 2 {
+			return errBinlogTruncated
+		}
+		v := binary.LittleEndian.Uint16(data)
+		if typ == jsonInt16 {
+			b.WriteString(strconv.Itoa(int(int16(v))))
+		} else {
+			b.WriteString(strconv.Itoa(int(v)))
+		}
+	case jsonInt32, jsonUint32:
+		if len(data) < 4 {
+			return errBinlogTruncated
+		}
+		v := binary.LittleEndian.Uint32(data)
+		if typ == jsonInt32 {
+			b.WriteString(strconv.FormatInt(int64(int32(v)), 10))
+		} else {
+			b.WriteString(strconv.FormatUint(uint64(v), 10))
+		}
+	case jsonInt64, jsonUint64:
+		if len(data) < 8 {
+			return errBinlogTruncated
+		}
+		v := binary.LittleEndian.Uint64(data)
+		if typ == jsonInt64 {
+			b.WriteString(strconv.FormatInt(int64(v), 10))
+		} else {
+			b.WriteString(strconv.FormatUint(v, 10))
+		}
+	case jsonDouble:
+		if len(data) < 8 {
+			return errBinlogTruncated
+		}
+		b.WriteString(strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(data)), 'g', -1, 64))
+	case jsonString:
+		s, err := readJSONVarString(data)
+		if err != nil {
+			return err
+		}
+		writeJSONString(b, s)
+	case jsonOpaque:
+		if len(data) < 1 {
+			return errBinlogTruncated
+		}
+		value, err := readJSONVarString(data[1:])
+		if err != nil {
+			return err
+		}
+		return writeJSONOpaque(b, data[0], []byte(value))
+	default:
+		return fmt.Errorf("unsupported JSON value type %d", typ)
+	}
+	return nil
+}
+
+func writeBinlogJSONContainer(b *strings.Builder, typ byte, data []byte) error {
+	large := typ == jsonLargeObject || typ == jsonLargeArray
+	object := typ == jsonSmallObject || typ == jsonLargeObject
+	offsetSize := 2
+	if large {
+		offsetSize = 4
+	}
+	readOffset := func(pos int) (int, error) {
+		if pos+offsetSize > len(data) {
+			return 0, errBinlogTruncated
+		}
+		if large {
+			return int(binary.LittleEndian.Uint32(data[pos:])), nil
+		}
+		return int(binary.LittleEndian.Uint16(data[pos:])), nil
+	}
+	count, err := readOffset(0)
+	if err != nil {
+		return err
+	}
+	keyEntry := offsetSize + 2
+	valueEntry := 1 + offsetSize
+	pos := 2 * offsetSize
+	keysStart := pos
+	valuesStart := pos
+	if object {
+		valuesStart += count * keyEntry
+	}
+
+	if object {
+		b.WriteByte('{')
+	} else {
+		b.WriteByte('[')
+	}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if object {
+			entry := keysStart + i*keyEntry
+			keyOffset, err := readOffset(entry)
+			if err != nil {
+				return err
+			}
+			if entry+keyEntry > len(data) {
+				return errBinlogTruncated
+			}
+			keyLen := int(binary.LittleEndian.Uint16(data[entry+offsetSize:]))
+			if keyOffset+keyLen > len(data) {
+				return errBinlogTruncated
+			}
+			writeJSONString(b, string(data[keyOffset:keyOffset+keyLen]))
+			b.WriteString(": ")
+		}
+		entry := valuesStart + i*valueEntry
+		if entry+valueEntry > len(data) {
+			return errBinlogTruncated
+		}
+		valueType := data[entry]
+		inlined := valueType == jsonLiteral || valueType == jsonInt16 || valueType == jsonUint16 ||
+			(large && (valueType == jsonInt32 || valueType == jsonUint32))
+		if inlined {
+			if err := writeBinlogJSON(b, valueType, data[entry+1:entry+valueEntry]); err != nil {
+				return err
+			}
+			continue
+		}
+		offset, err := readOffset(entry + 1)
+		if err != nil {
+			return err
+		}
+		if offset > len(data) {
+			return errBinlogTruncated
+		}
+		if err := writeBinlogJSON(b, valueType, data[offset:]); err != nil {
+			return err
+		}
+	}
+	if object {
+		b.WriteByte('}')
+	} else {
+		b.WriteByte(']')
+	}
+	return nil
+}
+
+// readJSONVarString reads a string prefixed by its length, stored 7 bits
+// per byte.
+func readJSONVarString(data []byte) (string, error) {
+	var length, shift, pos int
+	for {
+		if pos >= len(data) || pos >= 5 {
+			return "", errBinlogTruncated
+		}
+		c := data[pos]
+		pos++
+		length |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	if pos+length > len(data) {
+		return "", errBinlogTruncated
+	}
+	return string(data[pos : pos+length]), nil
+}
+
+func writeJSONString(b *strings.Builder, s string) {
+	text, _ := json.Marshal(s)
+	b.Write(text)
+}
+
+// writeJSONOpaque writes the MySQL values that JSON has no type for:
+// decimals and temporal values.
+func writeJSONOpaque(b *strings.Builder, fieldType byte, value []byte) error {
+	switch fieldType {
+	case mysqlTypeNewDecimal:
+		if len(value) < 2 {
+			return errBinlogTruncated
+		}
+		text, _, err := decodeBinlogDecimal(value[2:], int(value[0]), int(value[1]))
+		if err != nil {
+			return err
+		}
+		b.WriteString(text)
+	case mysqlTypeDate, mysqlTypeDatetime, mysqlTypeTimestamp, mysqlTypeTime:
+		if len(value) < 8 {
+			return errBinlogTruncated
+		}
+		packed := int64(binary.LittleEndian.Uint64(value))
+		intPart, frac := packed>>24, packed%(1<<24)
+		var text string
+		switch fieldType {
+		case mysqlTypeTime:
+			sign := ""
+			if packed < 0 {
+				sign = "-"
+				intPart, frac = (-packed)>>24, (-packed)%(1<<24)
+			}
+			text = fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, (intPart>>12)&0x3ff, (intPart>>6)&63, intPart&63, frac)
+		case mysqlTypeDate:
+			text = formatPackedDatetime(intPart, 0, 0)[:10]
+		default:
+			text = formatPackedDatetime(intPart, frac, 6)
+		}
+		writeJSONString(b, text)
+	default:
+		writeJSONString(b, "base64:type"+strconv.Itoa(int(fieldType))+":"+base64.StdEncoding.EncodeToString(value))
+	}
+	return nil
+}
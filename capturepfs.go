@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Statements that ended within this window before the newest statement of
+// the previous poll are fetched again, rows can be added slightly out of
+// order by concurrent threads. Duplicates are dropped by EVENT_ID.
+const pfsOverlapPicoseconds = 1e12
+
+const pfsHistoryQuery = `SELECT h.THREAD_ID, h.EVENT_ID, h.SQL_TEXT, IFNULL(h.CURRENT_SCHEMA, ''),
+    h.TIMER_START, h.TIMER_END, h.TIMER_WAIT, h.ROWS_SENT, h.MYSQL_ERRNO,
+    t.PROCESSLIST_ID, IFNULL(t.PROCESSLIST_USER, '')
+FROM performance_schema.events_statements_history_long h
+LEFT JOIN performance_schema.threads t ON t.THREAD_ID = h.THREAD_ID
+WHERE h.SQL_TEXT IS NOT NULL AND h.TIMER_END > ?
+    AND (h.NESTING_EVENT_TYPE IS NULL OR h.NESTING_EVENT_TYPE <> 'STATEMENT')
+    AND (t.PROCESSLIST_ID IS NULL OR t.PROCESSLIST_ID <> CONNECTION_ID())`
+
+// pfsEvent is one row of events_statements_history_long. Timers are in
+// picoseconds since the server started.
+type pfsEvent struct {
+	ThreadID      uint64
+	EventID       uint64
+	SQL           string
+	Schema        string
+	TimerStart    uint64
+	TimerEnd      uint64
+	TimerWait     uint64
+	RowsSent      int
+	ErrorCode     int
+	ProcesslistID sql.NullInt64
+	Username      string
+}
+
+// pfsThread is what the capture remembers about a thread between polls.
+type pfsThread struct {
+	lastEventID  uint64
+	lastTimerEnd uint64
+	connID       string
+	username     string
+}
+
+// pfsCapture turns polled history rows into replay entries. It keeps the
+// last EVENT_ID of every thread, so rows seen by an earlier poll are
+// skipped, and the thread to processlist id mapping, because the threads
+// row is gone once a connection closes. Threads are forgotten once their
+// last statement is older than the next poll reads.
+type pfsCapture struct {
+	threads      map[uint64]*pfsThread
+	bootUnixTime float64 // wall clock time of timer 0, in seconds
+	lastTimerEnd uint64
+}
+
+func newPfsCapture(bootUnixTime float64) *pfsCapture {
+	return &pfsCapture{threads: make(map[uint64]*pfsThread), bootUnixTime: bootUnixTime}
+}
+
+// Since returns the TIMER_END lower bound for the next poll.
+func (c *pfsCapture) Since() uint64 {
+	if c.lastTimerEnd < pfsOverlapPicoseconds {
+		return 0
+	}
+	return c.lastTimerEnd - pfsOverlapPicoseconds
+}
+
+// Process returns the entries of the rows that were not seen before, in
+// start time order.
+func (c *pfsCapture) Process(events []pfsEvent) []*LogEntry {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].ThreadID != events[j].ThreadID {
+			return events[i].ThreadID < events[j].ThreadID
+		}
+		return events[i].EventID < events[j].EventID
+	})
+
+	// older rows may belong to threads that were forgotten, the poll does
+	// not return them
+	since := c.Since()
+	var entries []*LogEntry
+	for _, ev := range events {
+		if since > 0 && ev.TimerEnd <= since {
+			continue
+		}
+		if ev.TimerEnd > c.lastTimerEnd {
+			c.lastTimerEnd = ev.TimerEnd
+		}
+		thread := c.threads[ev.ThreadID]
+		if thread == nil {
+			thread = &pfsThread{connID: "thread-" + strconv.FormatUint(ev.ThreadID, 10)}
+			c.threads[ev.ThreadID] = thread
+		}
+		if ev.ProcesslistID.Valid {
+			thread.connID = strconv.FormatInt(ev.ProcesslistID.Int64, 10)
+		}
+		if ev.Username != "" {
+			thread.username = ev.Username
+		}
+		if ev.EventID <= thread.lastEventID {
+			continue
+		}
+		thread.lastEventID = ev.EventID
+		if ev.TimerEnd > thread.lastTimerEnd {
+			thread.lastTimerEnd = ev.TimerEnd
+		}
+
+		entry := &LogEntry{
+			ConnectionID: thread.connID,
+			QueryTime:    int64(ev.TimerWait / 1e6),
+			SQL:          ev.SQL,
+			RowsSent:     ev.RowsSent,
+			Username:     thread.username,
+			DBName:       ev.Schema,
+			Timestamp:    c.bootUnixTime + float64(ev.TimerStart)/1e12,
+			ErrorCode:    ev.ErrorCode,
+		}
+		setDigestAndType(entry)
+		entries = append(entries, entry)
+	}
+	// the next poll cannot return rows of these threads that were seen
+	// already, new ones carry their processlist id again
+	since = c.Since()
+	for id, thread := range c.threads {
+		if thread.lastTimerEnd <= since {
+			delete(c.threads, id)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+	return entries
+}
+
+// pfsBootTime returns the wall clock time at which the performance_schema
+// timers were 0, from the timer of the running statement, or from the
+// server uptime when that is not available.
+func pfsBootTime(db *sql.DB) (float64, error) {
+	var now float64
+	var timerStart uint64
+	err := db.QueryRow(`SELECT UNIX_TIMESTAMP(NOW(6)), s.TIMER_START
+FROM performance_schema.events_statements_current s
+JOIN performance_schema.threads t ON t.THREAD_ID = s.THREAD_ID
+WHERE t.PROCESSLIST_ID = CONNECTION_ID()`).Scan(&now, &timerStart)
+	if err == nil && timerStart > 0 {
+		return now - float64(timerStart)/1e12, nil
+	}
+	var name string
+	var uptime float64
+	if err := db.QueryRow("SELECT UNIX_TIMESTAMP(NOW(6))").Scan(&now); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow("SHOW GLOBAL STATUS LIKE 'Uptime'").Scan(&name, &uptime); err != nil {
+		return 0, err
+	}
+	return now - uptime, nil
+}
+
+func pollPfsHistory(db *sql.DB, since uint64) ([]pfsEvent, error) {
+	rows, err := db.Query(pfsHistoryQuery, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []pfsEvent
+	for rows.Next() {
+		var ev pfsEvent
+		var rowsSent, errno sql.NullInt64
+		if err := rows.Scan(&ev.ThreadID, &ev.EventID, &ev.SQL, &ev.Schema, &ev.TimerStart, &ev.TimerEnd,
+			&ev.TimerWait, &rowsSent, &errno, &ev.ProcesslistID, &ev.Username); err != nil {
+			return nil, err
+		}
+		ev.RowsSent = int(rowsSent.Int64)
+		ev.ErrorCode = int(errno.Int64)
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// StartPfsCapture polls performance_schema.events_statements_history_long
+// of the source server and appends the statements to outputPath.
+func StartPfsCapture(dbConnStr, outputPath string, interval time.Duration) {
+	if dbConnStr == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode capture-pfs -db <mysql_connection_string> -slow-out <path_to_slow_output_file> -poll-interval 1s")
+		return
+	}
+	if interval <= 0 {
+		fmt.Println("-poll-interval must be greater than 0")
+		return
+	}
+
+	db, err := sql.Open("mysql", dbConnStr)
+	if err != nil {
+		fmt.Println("Error opening database:", err)
+		return
+	}
+	defer db.Close()
+	// CONNECTION_ID() must be the connection that polls.
+	db.SetMaxOpenConns(1)
+
+	var enabled string
+	err = db.QueryRow("SELECT ENABLED FROM performance_schema.setup_consumers WHERE NAME = 'events_statements_history_long'").Scan(&enabled)
+	if err != nil {
+		fmt.Println("Error reading performance_schema consumers:", err)
+		return
+	}
+	if enabled != "YES" {
+		fmt.Println("The events_statements_history_long consumer is disabled, enable it with: UPDATE performance_schema.setup_consumers SET ENABLED = 'YES' WHERE NAME = 'events_statements_history_long'")
+		return
+	}
+	bootTime, err := pfsBootTime(db)
+	if err != nil {
+		fmt.Println("Error reading server time:", err)
+		return
+	}
+
+	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	capture := newPfsCapture(bootTime)
+	// Statements already in the history when the capture starts are skipped.
+	if events, err := pollPfsHistory(db, 0); err == nil {
+		capture.Process(events)
+	}
+	fmt.Printf("[%s] Polling performance_schema every %s\n", time.Now().Format("2006-01-02 15:04:05.000"), interval)
+
+	statements := 0
+	for {
+		select {
+		case <-signals:
+			fmt.Printf("[%s] Capture stopped, %d statements written to %s\n", time.Now().Format("2006-01-02 15:04:05.000"), statements, outputPath)
+			return
+		case <-ticker.C:
+		}
+		events, err := pollPfsHistory(db, capture.Since())
+		if err != nil {
+			fmt.Println("Error polling performance_schema:", err)
+			continue
+		}
+		for _, entry := range capture.Process(events) {
+			if err := writeLogEntry(writer, entry); err != nil {
+				fmt.Println("Error writing output:", err)
+				return
+			}
+			statements++
+		}
+		writer.Flush()
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestPfsCaptureProcess(t *testing.T) {
+	capture := newPfsCapture(1705681700)
+
+	// 第一次轮询：线程 40 与 41，时间戳由 TIMER_START（皮秒）换算
+	first := []pfsEvent{
+		{ThreadID: 41, EventID: 7, SQL: "update t set c = 1 where id = 2", Schema: "shop", TimerStart: 2e12, TimerEnd: 2.5e12, TimerWait: 5e11, ErrorCode: 0,
+			ProcesslistID: sql.NullInt64{Int64: 12, Valid: true}, Username: "app"},
+		{ThreadID: 40, EventID: 3, SQL: "select 1", Schema: "", TimerStart: 1e12, TimerEnd: 1.000002e12, TimerWait: 2e6, RowsSent: 1,
+			ProcesslistID: sql.NullInt64{Int64: 11, Valid: true}, Username: "app"},
+	}
+	entries := capture.Process(first)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	if entries[0].ConnectionID != "11" || entries[0].SQL != "select 1" || entries[0].QueryTime != 2 || entries[0].RowsSent != 1 ||
+		!floatEquals(entries[0].Timestamp, 1705681701) || entries[0].SQLType != "select" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].ConnectionID != "12" || entries[1].DBName != "shop" || entries[1].QueryTime != 500000 || entries[1].Username != "app" {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+	if capture.Since() != 1.5e12 {
+		t.Errorf("Unexpected poll bound %d", capture.Since())
+	}
+
+	// 第二次轮询：重复的 EVENT_ID 需要去重；线程 41 已断开，threads 中没有记录时沿用之前的 processlist id
+	second := []pfsEvent{
+		first[0],
+		{ThreadID: 41, EventID: 9, SQL: "select * from missing", Schema: "shop", TimerStart: 3e12, TimerEnd: 3.1e12, TimerWait: 1e11, ErrorCode: 1146},
+		{ThreadID: 50, EventID: 1, SQL: "select 2", TimerStart: 3.2e12, TimerEnd: 3.3e12},
+	}
+	entries = capture.Process(second)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	if entries[0].ConnectionID != "12" || entries[0].Username != "app" || entries[0].ErrorCode != 1146 {
+		t.Errorf("Unexpected entry: %+v", entries[0])
+	}
+	if entries[1].ConnectionID != "thread-50" {
+		t.Errorf("Unexpected entry: %+v", entries[1])
+	}
+}
+
+func TestPfsCaptureForgetsIdleThreads(t *testing.T) {
+	capture := newPfsCapture(0)
+	capture.Process([]pfsEvent{
+		{ThreadID: 40, EventID: 3, SQL: "select 1", TimerStart: 1e12, TimerEnd: 1.1e12},
+		{ThreadID: 41, EventID: 7, SQL: "select 2", TimerStart: 5e12, TimerEnd: 5.1e12},
+	})
+	// 线程 40 的最后一条语句早于下次轮询的下界，不会再被读到
+	if _, ok := capture.threads[40]; ok {
+		t.Errorf("Expected thread 40 to be forgotten")
+	}
+	if _, ok := capture.threads[41]; !ok {
+		t.Errorf("Expected thread 41 to be kept")
+	}
+
+	// 重新出现的线程从新的语句开始记录
+	entries := capture.Process([]pfsEvent{
+		{ThreadID: 40, EventID: 5, SQL: "select 3", TimerStart: 6e12, TimerEnd: 6.1e12},
+	})
+	if len(entries) != 1 || entries[0].ConnectionID != "thread-40" {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+}
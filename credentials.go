@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Credential maps a source user, optionally only on one database, to the
+// target account its sessions are replayed with: a full DSN, or a user and
+// password that replace the ones of the -db DSN.
+type Credential struct {
+	Username string `json:"username"`
+	DBName   string `json:"dbname,omitempty"`
+	DSN      string `json:"dsn,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// credentialMap picks the account of every replayed session from the source
+// user and database of the entry that opens it.
+type credentialMap struct {
+	entries map[[2]string]Credential // username, dbname ("" for any database)
+}
+
+// loadCredentials reads a JSON array of Credential. The DSNs are checked
+// when the file is read, so a typo does not show up in the middle of the
+// replay.
+func loadCredentials(path, baseDSN string) (*credentialMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var credentials []Credential
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m := &credentialMap{entries: make(map[[2]string]Credential)}
+	for i, c := range credentials {
+		if c.Username == "" {
+			return nil, fmt.Errorf("%s: entry %d has no username", path, i+1)
+		}
+		if c.DSN == "" {
+			if c.User == "" {
+				return nil, fmt.Errorf("%s: entry %d (%s) needs a dsn or a user", path, i+1, c.Username)
+			}
+			if _, err := mysql.ParseDSN(baseDSN); err != nil {
+				return nil, err
+			}
+		} else if _, err := mysql.ParseDSN(c.DSN); err != nil {
+			return nil, fmt.Errorf("%s: entry %d (%s): %w", path, i+1, c.Username, err)
+		}
+		key := [2]string{c.Username, c.DBName}
+		if _, ok := m.entries[key]; ok {
+			return nil, fmt.Errorf("%s: entry %d duplicates %s %s", path, i+1, c.Username, c.DBName)
+		}
+		m.entries[key] = c
+	}
+	return m, nil
+}
+
+// DSN returns the DSN for a session of username on dbName: the entry for the
+// user and database, else the entry for the user, else base. A user and
+// password replace the account of base, which is the -db DSN or the DSN of
+// the target the session is routed to.
+func (m *credentialMap) DSN(base, username, dbName string) string {
+	if m == nil {
+		return base
+	}
+	c, ok := m.entries[[2]string{username, dbName}]
+	if !ok || dbName == "" {
+		if c, ok = m.entries[[2]string{username, ""}]; !ok {
+			return base
+		}
+	}
+	if c.DSN != "" {
+		return c.DSN
+	}
+	cfg, err := mysql.ParseDSN(base)
+	if err != nil {
+		return base
+	}
+	cfg.User, cfg.Passwd = c.User, c.Password
+	return cfg.FormatDSN()
+}
+
+// checkRoutes rejects entries with a dsn when statements are routed: the
+// route picks the target, an entry may only replace the account on it.
+func (m *credentialMap) checkRoutes() error {
+	if m == nil {
+		return nil
+	}
+	for _, c := range m.entries {
+		if c.DSN != "" {
+			return fmt.Errorf("the entry of %s has a dsn, only user and password can be used with -routes", c.Username)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries.
+func (m *credentialMap) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
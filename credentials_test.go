@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	base := "root:secret@tcp(10.0.0.1:4000)/test?timeout=5s"
+
+	input := `[
+  {"username": "app", "user": "app_rw", "password": "p@ss"},
+  {"username": "app", "dbname": "report", "dsn": "app_ro:ro@tcp(10.0.0.2:4000)/report"},
+  {"username": "etl", "dsn": "etl:etl@tcp(10.0.0.3:3306)/"}
+]`
+	if err := os.WriteFile(path, []byte(input), 0600); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	credentials, err := loadCredentials(path, base)
+	if err != nil {
+		t.Fatalf("loadCredentials failed: %v", err)
+	}
+	if credentials.Len() != 3 {
+		t.Errorf("Expected 3 entries, got %d", credentials.Len())
+	}
+
+	// 先匹配用户与库，再匹配用户，都没有时使用 -db
+	tests := []struct {
+		username, dbName, expected string
+	}{
+		{"app", "shop", "app_rw:p@ss@tcp(10.0.0.1:4000)/test?timeout=5s"},
+		{"app", "", "app_rw:p@ss@tcp(10.0.0.1:4000)/test?timeout=5s"},
+		{"app", "report", "app_ro:ro@tcp(10.0.0.2:4000)/report"},
+		{"etl", "report", "etl:etl@tcp(10.0.0.3:3306)/"},
+		{"other", "shop", base},
+	}
+	for _, tt := range tests {
+		if dsn := credentials.DSN(base, tt.username, tt.dbName); dsn != tt.expected {
+			t.Errorf("DSN(%s, %s) = %s, expected %s", tt.username, tt.dbName, dsn, tt.expected)
+		}
+	}
+	// user 与 password 替换路由到的目标库 DSN 中的账号
+	if dsn := credentials.DSN("root:@tcp(10.0.1.1:3306)/", "app", "shop"); dsn != "app_rw:p@ss@tcp(10.0.1.1:3306)/" {
+		t.Errorf("Expected the account replaced on the routed DSN, got %s", dsn)
+	}
+	var none *credentialMap
+	if dsn := none.DSN(base, "app", "shop"); dsn != base {
+		t.Errorf("Expected the -db DSN without credentials, got %s", dsn)
+	}
+	// 使用 -routes 时 dsn 会覆盖路由，不允许
+	if err := credentials.checkRoutes(); err == nil || !strings.Contains(err.Error(), "-routes") {
+		t.Errorf("Expected dsn entries to be rejected with -routes, got %v", err)
+	}
+	if err := none.checkRoutes(); err != nil {
+		t.Errorf("Expected no error without credentials, got %v", err)
+	}
+
+	for input, message := range map[string]string{
+		`[{"dsn": "a:b@tcp(h:1)/"}]`:                                           "no username",
+		`[{"username": "app"}]`:                                                "needs a dsn or a user",
+		`[{"username": "app", "dsn": "not a dsn"}]`:                            "entry 1 (app)",
+		`[{"username": "app", "user": "a"}, {"username": "app", "user": "b"}]`: "duplicates",
+		`{"username": "app"}`:                                                  "cannot unmarshal",
+	} {
+		if err := os.WriteFile(path, []byte(input), 0600); err != nil {
+			t.Fatalf("Failed to write test input file: %v", err)
+		}
+		if _, err := loadCredentials(path, base); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error with %q for %s, got %v", message, input, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+)
+
+// fakeDriver 是不连接数据库的 database/sql 驱动，按 DSN 记录收到的语句和
+// 打开的连接数，fail 返回非 nil 时该语句失败
+type fakeDriver struct {
+	mu     sync.Mutex
+	stmts  map[string][]string
+	opened map[string]int
+	fail   func(dsn, query string) error
+}
+
+var fakeDB = &fakeDriver{}
+
+func init() {
+	sql.Register("fake", fakeDB)
+}
+
+// reset 清空已记录的语句并设置失败规则
+func (d *fakeDriver) reset(fail func(dsn, query string) error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stmts = make(map[string][]string)
+	d.opened = make(map[string]int)
+	d.fail = fail
+}
+
+// executed 返回 dsn 上收到的语句
+func (d *fakeDriver) executed(dsn string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.stmts[dsn]...)
+}
+
+// connections 返回 dsn 上打开过的连接数
+func (d *fakeDriver) connections(dsn string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.opened[dsn]
+}
+
+func (d *fakeDriver) exec(dsn, query string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stmts[dsn] = append(d.stmts[dsn], query)
+	if d.fail != nil {
+		return d.fail(dsn, query)
+	}
+	return nil
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.opened[dsn]++
+	return &fakeConn{d: d, dsn: dsn}, nil
+}
+
+type fakeConn struct {
+	d   *fakeDriver
+	dsn string
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver: prepare is not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake driver: begin is not supported")
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.d.exec(c.dsn, query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.d.exec(c.dsn, query); err != nil {
+		return nil, err
+	}
+	return fakeRows{}, nil
+}
+
+// fakeRows 是没有列和行的结果集
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return nil }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// readerConfig applies to the line readers of ParseLogs and
+// ParseLogEntries, it is set from the command line.
+var readerConfig = struct {
+	MaxLineSize    int    // bytes
+	QuarantinePath string // skipped entries, created when there is one
+}{
+	MaxLineSize:    1024 * 1024 * 1024, // max_allowed_packet can not be larger
+	QuarantinePath: "skipped_entries.log",
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// quarantineSnippet is how much of a skipped line the quarantine file keeps.
+const quarantineSnippet = 1024
+
+// lineReader reads lines of any length up to a maximum, without holding
+// more than the current line in memory. A longer line is returned as
+// errLineTooLong with its beginning, and reading goes on with the next one.
+type lineReader struct {
+	r      *bufio.Reader
+	max    int
+	lineNo int
+	// keepCR keeps the carriage return of CRLF terminated lines, for text
+	// that must be returned byte for byte.
+	keepCR bool
+}
+
+func newLineReader(r io.Reader, maxLineSize int) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, 1024*1024), max: maxLineSize}
+}
+
+// Next returns the next line without its line terminator, and io.EOF after
+// the last line.
+func (l *lineReader) Next() (string, error) {
+	var line []byte
+	size := 0
+	for {
+		chunk, err := l.r.ReadSlice('\n')
+		if size+len(chunk) <= l.max+2 { // the line terminator does not count
+			line = append(line, chunk...)
+		} else if rest := quarantineSnippet - len(line); rest > 0 {
+			if rest > len(chunk) {
+				rest = len(chunk)
+			}
+			line = append(line, chunk[:rest]...)
+		}
+		size += len(chunk)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if err == io.EOF && size == 0 {
+			return "", io.EOF
+		}
+		l.lineNo++
+		line = trimLineEnd(line, l.keepCR)
+		if size > l.max+2 || len(line) > l.max {
+			if len(line) > quarantineSnippet {
+				line = line[:quarantineSnippet]
+			}
+			return string(line), errLineTooLong
+		}
+		return string(line), nil
+	}
+}
+
+// LineNo returns the number of the line returned by the last Next.
+func (l *lineReader) LineNo() int {
+	return l.lineNo
+}
+
+func trimLineEnd(line []byte, keepCR bool) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' && !keepCR {
+			line = line[:n-1]
+		}
+	}
+	return line
+}
+
+// parseStats counts what happened to the entries of an input. Entries that
+// are skipped because they are malformed are written to the quarantine
+// file with their line number, so they can be looked at and fixed.
+type parseStats struct {
+	Read       int
+	Emitted    int
+	Skipped    map[string]int
+	quarantine *os.File
+	path       string
+}
+
+func newParseStats(quarantinePath string) *parseStats {
+	return &parseStats{Skipped: make(map[string]int), path: quarantinePath}
+}
+
+// Skip counts an entry that is not written, for example because of a
+// filter.
+func (s *parseStats) Skip(reason string) {
+	s.Skipped[reason]++
+}
+
+// Quarantine counts a malformed entry and lists it in the quarantine file.
+func (s *parseStats) Quarantine(source string, line int, reason, text string) {
+	s.Skip(reason)
+	if s.path == "" {
+		return
+	}
+	if s.quarantine == nil {
+		file, err := os.Create(s.path)
+		if err != nil {
+			fmt.Println("Error creating quarantine file:", err)
+			s.path = ""
+			return
+		}
+		s.quarantine = file
+	}
+	if len(text) > quarantineSnippet {
+		text = text[:quarantineSnippet]
+	}
+	data, _ := json.Marshal(struct {
+		Source string `json:"source"`
+		Line   int    `json:"line"`
+		Reason string `json:"reason"`
+		Text   string `json:"text"`
+	}{source, line, reason, text})
+	fmt.Fprintln(s.quarantine, string(data))
+}
+
+func (s *parseStats) Close() error {
+	if s.quarantine == nil {
+		return nil
+	}
+	return s.quarantine.Close()
+}
+
+// String summarises the counts, e.g. "10 entries read, 8 emitted, 2 skipped
+// (line too long: 1, no statement: 1)".
+func (s *parseStats) String() string {
+	total := 0
+	reasons := make([]string, 0, len(s.Skipped))
+	for reason, n := range s.Skipped {
+		total += n
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	summary := fmt.Sprintf("%d entries read, %d emitted, %d skipped", s.Read, s.Emitted, total)
+	if total == 0 {
+		return summary
+	}
+	details := make([]string, len(reasons))
+	for i, reason := range reasons {
+		details[i] = fmt.Sprintf("%s: %d", reason, s.Skipped[reason])
+	}
+	summary += " (" + strings.Join(details, ", ") + ")"
+	if s.quarantine != nil {
+		summary += ", malformed entries listed in " + s.path
+	}
+	return summary
+}
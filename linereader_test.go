@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	input := "short\r\n" + strings.Repeat("x", 20) + "\n\nexactly10!\nlast"
+	reader := newLineReader(strings.NewReader(input), 10)
+	expected := []struct {
+		line    string
+		tooLong bool
+	}{{"short", false}, {strings.Repeat("x", 20), true}, {"", false}, {"exactly10!", false}, {"last", false}}
+	for i, e := range expected {
+		line, err := reader.Next()
+		if line != e.line || (err == errLineTooLong) != e.tooLong || (err != nil && err != errLineTooLong) {
+			t.Errorf("line %d: got %q, %v", i+1, line, err)
+		}
+		if reader.LineNo() != i+1 {
+			t.Errorf("line %d: LineNo() = %d", i+1, reader.LineNo())
+		}
+	}
+	if _, err := reader.Next(); err.Error() != "EOF" {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+
+	// 超过读缓冲区大小的长行
+	long := strings.Repeat("y", 3*1024*1024)
+	reader = newLineReader(strings.NewReader(long+"\nnext\n"), 4*1024*1024)
+	if line, err := reader.Next(); err != nil || line != long {
+		t.Errorf("Long line not read: %d bytes, %v", len(line), err)
+	}
+	if line, err := reader.Next(); err != nil || line != "next" {
+		t.Errorf("Unexpected line after the long line: %q, %v", line, err)
+	}
+}
+
+func TestParseLogsMalformedEntries(t *testing.T) {
+	dir := t.TempDir()
+	saved := readerConfig
+	defer func() { readerConfig = saved }()
+	readerConfig.MaxLineSize = 256 * 1024
+	readerConfig.QuarantinePath = filepath.Join(dir, "skipped.log")
+
+	header := func(ts string) string {
+		return "# Time: " + ts + "\n# User@Host: t1[t1] @  [10.2.103.21]  Id:   797\n" +
+			"# Query_time: 0.000038  Lock_time: 0.000000 Rows_sent: 1  Rows_examined: 1\nSET timestamp=1705681788;\n"
+	}
+	// 超过 64KB 的批量 INSERT 可以正常解析，超过上限的行和时间错误的记录被隔离
+	bulk := "INSERT INTO t VALUES " + strings.Repeat("(1, 'abcdefghij'),", 10000) + "(2, 'x');"
+	tooLong := "INSERT INTO t VALUES " + strings.Repeat("(1, 'abcdefghij'),", 20000) + "(2, 'x');"
+	input := header("2024-01-19T16:29:48.141142Z") + bulk + "\n" +
+		header("2024-01-19T16:29:49.000000Z") + tooLong + "\n" +
+		header("2024-01-19T16:29:50.000000+08:00") + "select 1;\n" +
+		header("not-a-time") + "select 2;\n" +
+		header("2024-01-19T16:29:51.000000Z") + "select 3;\n"
+	slowLogPath := filepath.Join(dir, "slow.log")
+	outputPath := filepath.Join(dir, "slow.json")
+	if err := os.WriteFile(slowLogPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	ParseLogs(slowLogPath, outputPath)
+
+	entries := readLogEntriesForTest(t, outputPath)
+	if len(entries) != 3 || entries[0].SQL != bulk || entries[1].SQL != "select 1;" || entries[2].SQL != "select 3;" {
+		t.Fatalf("Unexpected entries: %d", len(entries))
+	}
+	if !floatEquals(entries[1].Timestamp, 1705652990) {
+		t.Errorf("Unexpected timestamp with offset: %f", entries[1].Timestamp)
+	}
+
+	data, err := os.ReadFile(readerConfig.QuarantinePath)
+	if err != nil {
+		t.Fatalf("Failed to read quarantine file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected quarantine file: %s", data)
+	}
+	var skipped struct {
+		Line   int    `json:"line"`
+		Reason string `json:"reason"`
+		Text   string `json:"text"`
+	}
+	json.Unmarshal([]byte(lines[0]), &skipped)
+	if skipped.Line != 10 || skipped.Reason != "line too long" || len(skipped.Text) != quarantineSnippet {
+		t.Errorf("Unexpected quarantined entry: %+v", skipped)
+	}
+	json.Unmarshal([]byte(lines[1]), &skipped)
+	if skipped.Line != 16 || skipped.Reason != "invalid time" {
+		t.Errorf("Unexpected quarantined entry: %+v", skipped)
+	}
+}
+
+func TestParseLogEntriesMalformedLines(t *testing.T) {
+	dir := t.TempDir()
+	defer os.Remove("ignored_digests.log")
+	saved := readerConfig
+	defer func() { readerConfig = saved }()
+	readerConfig.QuarantinePath = filepath.Join(dir, "skipped.log")
+
+	input := `{"connection_id":"1","sql":"select 1","sql_type":"select","ts":1}` + "\n" +
+		`{"connection_id":"1","sql":"select` + "\n" +
+		`{"connection_id":"2","sql":"update t set c = 1","sql_type":"update","ts":2}` + "\n"
+	path := filepath.Join(dir, "slow.json")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	tasksMap, minTimestamp, err := ParseLogEntries(path, "all", "select", "all", nil)
+	if err != nil {
+		t.Fatalf("ParseLogEntries failed: %v", err)
+	}
+	if len(tasksMap) != 1 || len(tasksMap["1"]) != 1 || minTimestamp != 1 {
+		t.Errorf("Unexpected entries: %+v", tasksMap)
+	}
+	data, _ := os.ReadFile(readerConfig.QuarantinePath)
+	if !strings.Contains(string(data), `"line":2,"reason":"invalid json"`) {
+		t.Errorf("Unexpected quarantine file: %s", data)
+	}
+}
@@ -57,11 +57,61 @@ func createTableIfNotExists(db *sql.DB, tableName string) error {
 		rows_returned bigint(20) DEFAULT NULL,
 		error_info text DEFAULT NULL,
 		file_name varchar(64) NOT NULL,
-		db_name varchar(64) DEFAULT NULL
+		db_name varchar(64) DEFAULT NULL,
+		txn_id varchar(64) DEFAULT NULL,
+		rewritten_sql longtext DEFAULT NULL,
+		rewrite_rules varchar(256) DEFAULT NULL
 	)`, tableName)
 
-	_, err := db.Exec(createTableSQL)
-	return err
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return err
+	}
+	return addMissingColumns(db, tableName)
+}
+
+// addedColumns are the columns added after the first release, tables created
+// by earlier versions get them when data is loaded.
+var addedColumns = []struct {
+	name, definition string
+}{
+	{"txn_id", "varchar(64) DEFAULT NULL"},
+	{"rewritten_sql", "longtext DEFAULT NULL"},
+	{"rewrite_rules", "varchar(256) DEFAULT NULL"},
+}
+
+func addMissingColumns(db *sql.DB, tableName string) error {
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?"
+	args := []interface{}{tableName}
+	if i := strings.Index(tableName, "."); i >= 0 {
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ?"
+		args = []interface{}{tableName[:i], tableName[i+1:]}
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, column := range addedColumns {
+		if existing[column.name] {
+			continue
+		}
+		fmt.Printf("Adding column %s to %s\n", column.name, tableName)
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, column.name, column.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func processFilesParallel(outDir, replayName, tableName string, db *sql.DB) error {
@@ -174,18 +224,20 @@ func parseRecords(lines []string) []SQLExecutionRecord {
 
 func buildInsertQuery(records []SQLExecutionRecord, fileName, tableName string) (string, []interface{}) {
 	valueStrings := make([]string, 0, len(records))
-	valueArgs := make([]interface{}, 0, len(records)*9)
+	valueArgs := make([]interface{}, 0, len(records)*13)
 
 	for _, record := range records {
 		normalizedSQL := parser.Normalize(record.SQL)
 		digest := parser.DigestNormalized(normalizedSQL).String()
 		sqlType := getSQLType(normalizedSQL)
 
-		valueStrings = append(valueStrings, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
-		valueArgs = append(valueArgs, record.SQL, sqlType, digest, record.QueryTime, record.RowsSent, record.ExecutionTime, record.RowsReturned, record.ErrorInfo, fileName, record.DBName)
+		valueStrings = append(valueStrings, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		// invalid UTF-8 is rejected by the text column, the exact bytes stay in
+		// the replay output
+		valueArgs = append(valueArgs, strings.ToValidUTF8(record.SQL, "\uFFFD"), sqlType, digest, record.QueryTime, record.RowsSent, record.ExecutionTime, record.RowsReturned, record.ErrorInfo, fileName, record.DBName, record.TxnID, strings.ToValidUTF8(record.RewrittenSQL, "\uFFFD"), record.RewriteRules)
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (sql_text, sql_type, sql_digest, query_time, rows_sent, execution_time, rows_returned, error_info, file_name, db_name) VALUES %s",
+	query := fmt.Sprintf("INSERT INTO %s (sql_text, sql_type, sql_digest, query_time, rows_sent, execution_time, rows_returned, error_info, file_name, db_name, txn_id, rewritten_sql, rewrite_rules) VALUES %s",
 		tableName, strings.Join(valueStrings, ","))
 	return query, valueArgs
 }
@@ -4,6 +4,7 @@ import (
     "flag"
     "fmt"
     "os"
+    "time"
 )
 
 // Version information for the SQL Replay Tool
@@ -13,12 +14,18 @@ var showVersion bool
 
 func main() {
     var mode string
-    flag.StringVar(&mode, "mode", "", "Mode of operation: parsemysqlslow ,parsetidbslow , replay, load, report")
+    flag.StringVar(&mode, "mode", "", "Mode of operation: parsemysqlslow ,parsemysqlslowtable ,parsetidbslow , parsetidbgeneral, parsetiproxy, parsepcap, parsemysqlgeneral, parsebinlog, parsehwaudit, parsealiyunaudit, parseserveraudit, parseauditlog, capture-proxy, capture-pfs, replay, load, report")
 
     // Define flags for various operation parameters
     var slowLogPath, slowOutputPath, dbConnStr, replayOutputFilePath, filterUsername, filterSQLType, filterDBName, ignoreDigests, outDir, replayOut, tableName, Port string
     var Speed float64
     var lang string
+    var mysqlPort int
+    var listenAddr, upstreamAddr string
+    var pollInterval time.Duration
+    var startTime, endTime, resumeFile string
+    var replayOpts ReplayOptions
+    var maxLineMB int
 
     flag.BoolVar(&showVersion, "version", false, "Show version info")
     flag.StringVar(&slowLogPath, "slow-in", "", "Path to slow query log file")
@@ -33,10 +40,30 @@ func main() {
     flag.StringVar(&filterDBName, "dbname", "all", "Database name to filter (default 'all', or specific dbname)")
     flag.StringVar(&ignoreDigests, "ignoredigests", "", "Ignore the Specific digests")
     flag.Float64Var(&Speed, "speed", 1.0, "Replay speed multiplier")
+    flag.StringVar(&replayOpts.Charset, "charset", "", "Character set of the captured SQL text, used by the replay sessions (e.g. latin1, gbk)")
+    flag.StringVar(&replayOpts.CredentialsFile, "credentials", "", "JSON file mapping source users (and databases) to target accounts for replay")
+    flag.StringVar(&replayOpts.SchemaMapFile, "schema-map", "", "JSON file renaming source databases on the target for replay")
+    flag.StringVar(&replayOpts.RewriteFile, "rewrite-rules", "", "JSON file of rules rewriting statements (by digest or AST) before they are replayed")
+    flag.StringVar(&replayOpts.RoutesFile, "routes", "", "JSON file routing statements to several targets by user, database or table for replay")
+    flag.BoolVar(&replayOpts.Prepared, "prepared", false, "Replay prepared statement executions with server-side prepare (COM_STMT_PREPARE/COM_STMT_EXECUTE)")
     flag.StringVar(&Port, "port", ":8081", "Report web server port")
+    flag.IntVar(&mysqlPort, "mysql-port", 3306, "MySQL server port in the packet capture")
+    flag.StringVar(&listenAddr, "listen", ":3307", "Listen address of the capture proxy")
+    flag.StringVar(&upstreamAddr, "upstream", "", "MySQL server address (host:port) behind the capture proxy")
+    flag.StringVar(&startTime, "start-time", "", "Only read slow_log rows with start_time >= this time (e.g. '2024-01-19 00:00:00')")
+    flag.StringVar(&endTime, "end-time", "", "Only read slow_log rows with start_time < this time")
+    flag.StringVar(&resumeFile, "resume-file", "", "File keeping the last slow_log start_time read, for incremental reads")
+    flag.DurationVar(&pollInterval, "poll-interval", time.Second, "Polling interval of capture-pfs")
+    flag.IntVar(&maxLineMB, "max-line-mb", readerConfig.MaxLineSize>>20, "Longest line (MB) read from a slow log or replay file, longer entries are skipped")
+    flag.StringVar(&readerConfig.QuarantinePath, "quarantine", readerConfig.QuarantinePath, "File listing the skipped malformed entries of a slow log or replay file")
     flag.StringVar(&lang, "lang", "en", "Language for output (e.g., 'en' for English, 'zh' for Chinese)")
 
     flag.Parse()
+    if maxLineMB <= 0 {
+        fmt.Println("-max-line-mb must be greater than 0")
+        os.Exit(1)
+    }
+    readerConfig.MaxLineSize = maxLineMB << 20
 
     if showVersion {
         fmt.Println("SQL Replay Tool Version:", version)
@@ -52,10 +79,34 @@ func main() {
     switch mode {
     case "parsemysqlslow":
         ParseLogs(slowLogPath, slowOutputPath)
+    case "parsemysqlslowtable":
+        ParseSlowLogTable(dbConnStr, slowLogPath, slowOutputPath, startTime, endTime, resumeFile)
     case "parsetidbslow":
         ParseTiDBLogs(slowLogPath, slowOutputPath)
+    case "parsetidbgeneral":
+        ParseTiDBGeneralLogs(slowLogPath, slowOutputPath)
+    case "parsetiproxy":
+        ParseTiProxyCapture(slowLogPath, slowOutputPath)
+    case "parsepcap":
+        ParsePcap(slowLogPath, slowOutputPath, mysqlPort)
+    case "parsemysqlgeneral":
+        ParseMySQLGeneralLogs(slowLogPath, slowOutputPath)
+    case "parsebinlog":
+        ParseBinlog(slowLogPath, slowOutputPath)
+    case "parsehwaudit":
+        ParseHWAuditLogs(slowLogPath, slowOutputPath)
+    case "parsealiyunaudit":
+        ParseAliyunAudit(slowLogPath, slowOutputPath)
+    case "parseserveraudit":
+        ParseServerAuditLogs(slowLogPath, slowOutputPath)
+    case "parseauditlog":
+        ParseAuditLogs(slowLogPath, slowOutputPath)
+    case "capture-proxy":
+        StartCaptureProxy(listenAddr, upstreamAddr, slowOutputPath)
+    case "capture-pfs":
+        StartPfsCapture(dbConnStr, slowOutputPath, pollInterval)
     case "replay":
-        StartSQLReplay(dbConnStr, Speed, slowOutputPath, replayOutputFilePath, filterUsername, filterSQLType, filterDBName, ignoreDigests, lang)
+        StartSQLReplay(dbConnStr, Speed, slowOutputPath, replayOutputFilePath, filterUsername, filterSQLType, filterDBName, ignoreDigests, lang, replayOpts)
     case "load":
         LoadData(dbConnStr, outDir, replayOut, tableName)
     case "report":
@@ -69,8 +120,20 @@ func main() {
 func printUsage() {
     fmt.Println("Usage: ./sql-replay -mode [parse|replay|load|report]")
     fmt.Println("    1. parse mysql slow log: ./sql-replay -mode parsemysqlslow -slow-in <path_to_slow_query_log> -slow-out <path_to_slow_output_file>")
-    fmt.Println("    2. parse tidb slow log: ./sql-replay -mode parsetidbslow -slow-in <path_to_slow_query_log> -slow-out <path_to_slow_output_file>")
-    fmt.Println("    3. replay mode: ./sql-replay -mode replay -db <mysql_connection_string> -speed 1.0 -slow-out <slow_output_file> -replay-out <replay_output_file> -username <all|username> -sqltype <all|select> -dbname <all|dbname> -ignoredigests <digest1,digest2...> -lang <en|zh>")
-    fmt.Println("    4. load mode: ./sql-replay -mode load -db <DB_CONN_STRING> -out-dir <DIRECTORY> -replay-name <REPORT_OUT_FILE_NAME> -table <replay_info>")
-    fmt.Println("    5. report mode: ./sql-replay -mode report -db <mysql_connection_string> -replay-name <replay name> -port ':8081'")
+    fmt.Println("    2. parse mysql.slow_log table: ./sql-replay -mode parsemysqlslowtable [-db <mysql_connection_string> | -slow-in <path_to_slow_log_csv>] -slow-out <path_to_slow_output_file> -start-time '2024-01-19 00:00:00' -end-time '2024-01-20 00:00:00' -resume-file <path>")
+    fmt.Println("    3. parse tidb slow log: ./sql-replay -mode parsetidbslow -slow-in <path_to_slow_query_log> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    4. parse tidb general log: ./sql-replay -mode parsetidbgeneral -slow-in <path_to_tidb_log> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    5. parse tiproxy traffic capture: ./sql-replay -mode parsetiproxy -slow-in <capture_directory_or_file> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    6. parse mysql packet capture: ./sql-replay -mode parsepcap -slow-in <path_to_pcap_file> -slow-out <path_to_slow_output_file> -mysql-port 3306")
+    fmt.Println("    7. parse mysql general log: ./sql-replay -mode parsemysqlgeneral -slow-in <path_to_general_log> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    8. parse mysql binlog: ./sql-replay -mode parsebinlog -slow-in <binlog_file_or_directory> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    9. parse huawei cloud rds audit log: ./sql-replay -mode parsehwaudit -slow-in <audit_log_file_or_directory> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    10. parse aliyun rds sql insight export: ./sql-replay -mode parsealiyunaudit -slow-in <path_to_sql_insight_csv> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    11. parse mariadb/aurora server_audit log: ./sql-replay -mode parseserveraudit -slow-in <path_to_audit_log> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    12. parse percona/mysql enterprise audit log: ./sql-replay -mode parseauditlog -slow-in <path_to_audit_log> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    13. capture through proxy: ./sql-replay -mode capture-proxy -listen ':3307' -upstream <mysql_host:port> -slow-out <path_to_slow_output_file>")
+    fmt.Println("    14. capture from performance_schema: ./sql-replay -mode capture-pfs -db <mysql_connection_string> -slow-out <path_to_slow_output_file> -poll-interval 1s")
+    fmt.Println("    15. replay mode: ./sql-replay -mode replay -db <mysql_connection_string> -speed 1.0 -slow-out <slow_output_file> -replay-out <replay_output_file> -username <all|username> -sqltype <all|select> -dbname <all|dbname> -ignoredigests <digest1,digest2...> -credentials <credentials_file> -schema-map <schema_map_file> -rewrite-rules <rewrite_rules_file> -routes <routes_file> -prepared -charset <charset> -lang <en|zh>")
+    fmt.Println("    16. load mode: ./sql-replay -mode load -db <DB_CONN_STRING> -out-dir <DIRECTORY> -replay-name <REPORT_OUT_FILE_NAME> -table <replay_info>")
+    fmt.Println("    17. report mode: ./sql-replay -mode report -db <mysql_connection_string> -replay-name <replay name> -port ':8081'")
 }
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MySQL client/server protocol constants used by the capture decoders.
+const (
+	comQuit             = 0x01
+	comInitDB           = 0x02
+	comQuery            = 0x03
+	comChangeUser       = 0x11
+	comStmtPrepare      = 0x16
+	comStmtExecute      = 0x17
+	comStmtSendLongData = 0x18
+	comStmtClose        = 0x19
+	comStmtReset        = 0x1a
+	comResetConnection  = 0x1f
+
+	clientConnectWithDB    = 0x00000008
+	clientProtocol41       = 0x00000200
+	clientSSL              = 0x00000800
+	clientSecureConnection = 0x00008000
+	clientPluginAuth       = 0x00080000
+	clientPluginAuthLenenc = 0x00200000
+	clientDeprecateEOF     = 0x01000000
+	clientQueryAttributes  = 0x08000000
+
+	serverMoreResultsExists = 0x0008
+
+	maxPacketPayload = 0xffffff
+)
+
+// mysqlPacket is one protocol packet with its payload. Payloads of split
+// packets (16MB and above) are already joined.
+type mysqlPacket struct {
+	Seq     byte
+	Payload []byte
+}
+
+// mysqlPacketReader splits a byte stream into MySQL packets.
+type mysqlPacketReader struct {
+	buf     []byte
+	pending []byte
+	split   bool
+}
+
+func (r *mysqlPacketReader) Write(data []byte) {
+	r.buf = append(r.buf, data...)
+}
+
+// Next returns the next complete packet, or false when more data is needed.
+func (r *mysqlPacketReader) Next() (mysqlPacket, bool) {
+	for {
+		if len(r.buf) < 4 {
+			return mysqlPacket{}, false
+		}
+		length := int(r.buf[0]) | int(r.buf[1])<<8 | int(r.buf[2])<<16
+		if len(r.buf) < 4+length {
+			return mysqlPacket{}, false
+		}
+		seq := r.buf[3]
+		payload := r.buf[4 : 4+length]
+		r.buf = r.buf[4+length:]
+		if length == maxPacketPayload {
+			r.pending = append(r.pending, payload...)
+			r.split = true
+			continue
+		}
+		if r.split {
+			payload = append(r.pending, payload...)
+			r.pending = nil
+			r.split = false
+		} else {
+			payload = append([]byte(nil), payload...)
+		}
+		if len(r.buf) == 0 {
+			r.buf = nil
+		}
+		return mysqlPacket{Seq: seq, Payload: payload}, true
+	}
+}
+
+// Reset drops buffered data, used when a stream has to be resynchronized.
+func (r *mysqlPacketReader) Reset() {
+	r.buf = nil
+	r.pending = nil
+	r.split = false
+}
+
+// readLenencInt decodes a length-encoded integer and returns it with the
+// number of bytes consumed.
+func readLenencInt(data []byte) (uint64, int, bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	switch data[0] {
+	case 0xfb:
+		return 0, 1, true
+	case 0xfc:
+		if len(data) < 3 {
+			return 0, 0, false
+		}
+		return uint64(binary.LittleEndian.Uint16(data[1:])), 3, true
+	case 0xfd:
+		if len(data) < 4 {
+			return 0, 0, false
+		}
+		return uint64(data[1]) | uint64(data[2])<<8 | uint64(data[3])<<16, 4, true
+	case 0xfe:
+		if len(data) < 9 {
+			return 0, 0, false
+		}
+		return binary.LittleEndian.Uint64(data[1:]), 9, true
+	default:
+		return uint64(data[0]), 1, true
+	}
+}
+
+func readLenencString(data []byte) ([]byte, int, bool) {
+	length, n, ok := readLenencInt(data)
+	if !ok || uint64(len(data)-n) < length {
+		return nil, 0, false
+	}
+	return data[n : n+int(length)], n + int(length), true
+}
+
+func readNullString(data []byte) (string, int) {
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i]), i + 1
+		}
+	}
+	return string(data), len(data)
+}
+
+// serverHandshake holds the fields of the initial handshake packet (protocol
+// version 10) that the decoders need.
+type serverHandshake struct {
+	ConnectionID uint32
+	Capabilities uint32
+}
+
+func parseServerHandshake(payload []byte) (serverHandshake, bool) {
+	var hs serverHandshake
+	if len(payload) < 1 || payload[0] != 10 {
+		return hs, false
+	}
+	_, n := readNullString(payload[1:])
+	pos := 1 + n
+	if len(payload) < pos+4+8+1+2 {
+		return hs, false
+	}
+	hs.ConnectionID = binary.LittleEndian.Uint32(payload[pos:])
+	pos += 4 + 8 + 1
+	hs.Capabilities = uint32(binary.LittleEndian.Uint16(payload[pos:]))
+	pos += 2
+	if len(payload) >= pos+1+2+2 {
+		hs.Capabilities |= uint32(binary.LittleEndian.Uint16(payload[pos+3:])) << 16
+	}
+	return hs, true
+}
+
+// handshakeResponse holds the fields of the client handshake response.
+type handshakeResponse struct {
+	Capabilities uint32
+	Username     string
+	DBName       string
+	SSLRequest   bool
+}
+
+func parseHandshakeResponse(payload []byte) (handshakeResponse, bool) {
+	var resp handshakeResponse
+	if len(payload) < 4 {
+		return resp, false
+	}
+	caps := uint32(binary.LittleEndian.Uint16(payload))
+	if caps&clientProtocol41 == 0 {
+		// HandshakeResponse320: caps(2), max packet(3), username, auth
+		if len(payload) < 5 {
+			return resp, false
+		}
+		resp.Capabilities = caps
+		resp.Username, _ = readNullString(payload[5:])
+		return resp, true
+	}
+	if len(payload) < 32 {
+		return resp, false
+	}
+	caps = binary.LittleEndian.Uint32(payload)
+	resp.Capabilities = caps
+	if len(payload) == 32 && caps&clientSSL != 0 {
+		resp.SSLRequest = true
+		return resp, true
+	}
+	pos := 32
+	var n int
+	resp.Username, n = readNullString(payload[pos:])
+	pos += n
+	switch {
+	case caps&clientPluginAuthLenenc != 0:
+		_, n, ok := readLenencString(payload[pos:])
+		if !ok {
+			return resp, true
+		}
+		pos += n
+	case caps&clientSecureConnection != 0:
+		if pos >= len(payload) {
+			return resp, true
+		}
+		pos += 1 + int(payload[pos])
+	default:
+		_, n = readNullString(payload[pos:])
+		pos += n
+	}
+	if caps&clientConnectWithDB != 0 && pos < len(payload) {
+		resp.DBName, _ = readNullString(payload[pos:])
+	}
+	return resp, true
+}
+
+// parseChangeUser returns the username and schema of a COM_CHANGE_USER
+// payload (without the command byte).
+func parseChangeUser(data []byte, caps uint32) (string, string) {
+	username, n := readNullString(data)
+	pos := n
+	if caps&clientSecureConnection != 0 {
+		if pos >= len(data) {
+			return username, ""
+		}
+		pos += 1 + int(data[pos])
+	} else {
+		_, n = readNullString(data[pos:])
+		pos += n
+	}
+	if pos > len(data) {
+		return username, ""
+	}
+	dbName, _ := readNullString(data[pos:])
+	return username, dbName
+}
+
+// parseErrPacket returns the error code and message of an ERR packet.
+func parseErrPacket(payload []byte) (int, string) {
+	if len(payload) < 3 || payload[0] != 0xff {
+		return 0, ""
+	}
+	code := int(binary.LittleEndian.Uint16(payload[1:]))
+	msg := payload[3:]
+	if len(msg) > 0 && msg[0] == '#' && len(msg) >= 6 {
+		msg = msg[6:]
+	}
+	return code, string(msg)
+}
+
+// okStatusFlags returns the server status flags of an OK or EOF packet.
+func okStatusFlags(payload []byte) uint16 {
+	if len(payload) == 0 {
+		return 0
+	}
+	if payload[0] == 0xfe && len(payload) == 5 {
+		// classic EOF packet: header, warnings, status
+		return binary.LittleEndian.Uint16(payload[3:])
+	}
+	pos := 1
+	for i := 0; i < 2; i++ {
+		_, n, ok := readLenencInt(payload[pos:])
+		if !ok {
+			return 0
+		}
+		pos += n
+	}
+	if len(payload) < pos+2 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(payload[pos:])
+}
+
+func isEOFPacket(payload []byte) bool {
+	return len(payload) > 0 && payload[0] == 0xfe && len(payload) < 9
+}
+
+// commandResult is the outcome of one command as seen in the server response.
+type commandResult struct {
+	RowsSent  int
+	ErrorCode int
+	ErrorMsg  string
+	// Set for the first response packet of COM_STMT_PREPARE.
+	StmtID    uint32
+	NumParams int
+}
+
+// summarizeResponse decodes the server packets answering one command. It
+// handles OK, ERR and (multi) result sets in text and binary protocol, with
+// or without intermediate EOF packets.
+func summarizeResponse(command byte, packets [][]byte) commandResult {
+	var result commandResult
+	if len(packets) == 0 {
+		return result
+	}
+	if command == comStmtPrepare {
+		first := packets[0]
+		if len(first) >= 12 && first[0] == 0x00 {
+			result.StmtID = binary.LittleEndian.Uint32(first[1:])
+			result.NumParams = int(binary.LittleEndian.Uint16(first[7:]))
+		} else {
+			result.ErrorCode, result.ErrorMsg = parseErrPacket(first)
+		}
+		return result
+	}
+
+	i := 0
+	for i < len(packets) {
+		p := packets[i]
+		if len(p) == 0 {
+			i++
+			continue
+		}
+		switch {
+		case p[0] == 0xff:
+			result.ErrorCode, result.ErrorMsg = parseErrPacket(p)
+			return result
+		case p[0] == 0x00 || isEOFPacket(p):
+			i++
+			if okStatusFlags(p)&serverMoreResultsExists == 0 {
+				return result
+			}
+			continue
+		case p[0] == 0xfb:
+			// LOCAL INFILE request, the rest is file content
+			return result
+		}
+
+		columns, _, ok := readLenencInt(p)
+		// the column definitions follow the count, a larger count is a
+		// malformed or mis-synced response
+		if !ok || columns > uint64(len(packets)-i-1) {
+			return result
+		}
+		i += 1 + int(columns)
+		// An EOF right after the column definitions is the separator of
+		// the classic protocol unless it is the last packet.
+		if i < len(packets)-1 && isEOFPacket(packets[i]) {
+			i++
+		}
+		for i < len(packets) {
+			row := packets[i]
+			i++
+			if isEOFPacket(row) || (len(row) > 0 && row[0] == 0xfe && len(row) < maxPacketPayload && i == len(packets)) {
+				if okStatusFlags(row)&serverMoreResultsExists == 0 {
+					return result
+				}
+				break
+			}
+			if len(row) > 0 && row[0] == 0xff {
+				result.ErrorCode, result.ErrorMsg = parseErrPacket(row)
+				return result
+			}
+			result.RowsSent++
+		}
+	}
+	return result
+}
+
+// preparedStmt keeps what is needed to render COM_STMT_EXECUTE back to SQL.
+type preparedStmt struct {
+	SQL       string
+	NumParams int
+	Types     []byte
+	LongData  map[int][]byte
+}
+
+// stmtParam is one decoded COM_STMT_EXECUTE parameter.
+type stmtParam struct {
+	Type     byte
+	Unsigned bool
+	Null     bool
+	Value    []byte // raw value for string-like types
+	Literal  string // SQL literal
+}
+
+// parseStmtExecute decodes the parameters of a COM_STMT_EXECUTE payload
+// (without the command byte). The parameter types are remembered on stmt
+// because clients only send them when they change.
+func parseStmtExecute(data []byte, stmt *preparedStmt) ([]stmtParam, error) {
+	if len(data) < 9 {
+		return nil, fmt.Errorf("short COM_STMT_EXECUTE packet")
+	}
+	n := stmt.NumParams
+	if n == 0 {
+		return nil, nil
+	}
+	pos := 9
+	nullBitmapLen := (n + 7) / 8
+	if len(data) < pos+nullBitmapLen+1 {
+		return nil, fmt.Errorf("short COM_STMT_EXECUTE packet")
+	}
+	nullBitmap := data[pos : pos+nullBitmapLen]
+	pos += nullBitmapLen
+	if data[pos] == 1 {
+		pos++
+		if len(data) < pos+2*n {
+			return nil, fmt.Errorf("short COM_STMT_EXECUTE parameter types")
+		}
+		stmt.Types = append([]byte(nil), data[pos:pos+2*n]...)
+		pos += 2 * n
+	} else {
+		pos++
+	}
+	if len(stmt.Types) < 2*n {
+		return nil, fmt.Errorf("missing COM_STMT_EXECUTE parameter types")
+	}
+
+	params := make([]stmtParam, n)
+	for i := 0; i < n; i++ {
+		param := stmtParam{Type: stmt.Types[2*i], Unsigned: stmt.Types[2*i+1]&0x80 != 0}
+		if nullBitmap[i/8]&(1<<(uint(i)%8)) != 0 {
+			param.Null = true
+			param.Literal = "NULL"
+			params[i] = param
+			continue
+		}
+		if long, ok := stmt.LongData[i]; ok {
+			param.Value = long
+			param.Literal = quoteSQLString(long)
+			params[i] = param
+			continue
+		}
+		n, err := decodeBinaryValue(data[pos:], &param)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		params[i] = param
+	}
+	return params, nil
+}
+
+// decodeBinaryValue decodes one binary protocol value into param and returns
+// the number of bytes consumed.
+func decodeBinaryValue(data []byte, param *stmtParam) (int, error) {
+	need := func(n int) error {
+		if len(data) < n {
+			return fmt.Errorf("short value for parameter type 0x%02x", param.Type)
+		}
+		return nil
+	}
+	switch param.Type {
+	case 0x06: // NULL
+		param.Null = true
+		param.Literal = "NULL"
+		return 0, nil
+	case 0x01: // TINY
+		if err := need(1); err != nil {
+			return 0, err
+		}
+		if param.Unsigned {
+			param.Literal = strconv.FormatUint(uint64(data[0]), 10)
+		} else {
+			param.Literal = strconv.FormatInt(int64(int8(data[0])), 10)
+		}
+		return 1, nil
+	case 0x02, 0x0d: // SHORT, YEAR
+		if err := need(2); err != nil {
+			return 0, err
+		}
+		v := binary.LittleEndian.Uint16(data)
+		if param.Unsigned || param.Type == 0x0d {
+			param.Literal = strconv.FormatUint(uint64(v), 10)
+		} else {
+			param.Literal = strconv.FormatInt(int64(int16(v)), 10)
+		}
+		return 2, nil
+	case 0x03, 0x09: // LONG, INT24
+		if err := need(4); err != nil {
+			return 0, err
+		}
+		v := binary.LittleEndian.Uint32(data)
+		if param.Unsigned {
+			param.Literal = strconv.FormatUint(uint64(v), 10)
+		} else {
+			param.Literal = strconv.FormatInt(int64(int32(v)), 10)
+		}
+		return 4, nil
+	case 0x08: // LONGLONG
+		if err := need(8); err != nil {
+			return 0, err
+		}
+		v := binary.LittleEndian.Uint64(data)
+		if param.Unsigned {
+			param.Literal = strconv.FormatUint(v, 10)
+		} else {
+			param.Literal = strconv.FormatInt(int64(v), 10)
+		}
+		return 8, nil
+	case 0x04: // FLOAT
+		if err := need(4); err != nil {
+			return 0, err
+		}
+		param.Literal = strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(data))), 'g', -1, 32)
+		return 4, nil
+	case 0x05: // DOUBLE
+		if err := need(8); err != nil {
+			return 0, err
+		}
+		param.Literal = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(data)), 'g', -1, 64)
+		return 8, nil
+	case 0x07, 0x0a, 0x0c: // TIMESTAMP, DATE, DATETIME
+		if err := need(1); err != nil {
+			return 0, err
+		}
+		length := int(data[0])
+		if err := need(1 + length); err != nil {
+			return 0, err
+		}
+		param.Literal = "'" + formatBinaryDatetime(data[1:1+length], param.Type == 0x0a) + "'"
+		return 1 + length, nil
+	case 0x0b: // TIME
+		if err := need(1); err != nil {
+			return 0, err
+		}
+		length := int(data[0])
+		if err := need(1 + length); err != nil {
+			return 0, err
+		}
+		param.Literal = "'" + formatBinaryTime(data[1:1+length]) + "'"
+		return 1 + length, nil
+	case 0x00, 0xf6: // DECIMAL, NEWDECIMAL
+		value, n, ok := readLenencString(data)
+		if !ok {
+			return 0, fmt.Errorf("short value for parameter type 0x%02x", param.Type)
+		}
+		param.Value = value
+		param.Literal = string(value)
+		return n, nil
+	default: // string, blob, enum, set, bit, json, geometry
+		value, n, ok := readLenencString(data)
+		if !ok {
+			return 0, fmt.Errorf("short value for parameter type 0x%02x", param.Type)
+		}
+		param.Value = value
+		param.Literal = quoteSQLString(value)
+		return n, nil
+	}
+}
+
+func formatBinaryDatetime(data []byte, dateOnly bool) string {
+	if len(data) < 4 {
+		if dateOnly {
+			return "0000-00-00"
+		}
+		return "0000-00-00 00:00:00"
+	}
+	year := binary.LittleEndian.Uint16(data)
+	s := fmt.Sprintf("%04d-%02d-%02d", year, data[2], data[3])
+	if dateOnly {
+		return s
+	}
+	var hour, minute, second byte
+	if len(data) >= 7 {
+		hour, minute, second = data[4], data[5], data[6]
+	}
+	s += fmt.Sprintf(" %02d:%02d:%02d", hour, minute, second)
+	if len(data) >= 11 {
+		s += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(data[7:]))
+	}
+	return s
+}
+
+func formatBinaryTime(data []byte) string {
+	if len(data) < 8 {
+		return "00:00:00"
+	}
+	sign := ""
+	if data[0] == 1 {
+		sign = "-"
+	}
+	days := binary.LittleEndian.Uint32(data[1:])
+	s := fmt.Sprintf("%s%02d:%02d:%02d", sign, uint32(data[5])+days*24, data[6], data[7])
+	if len(data) >= 12 {
+		s += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(data[8:]))
+	}
+	return s
+}
+
+// quoteSQLString renders raw bytes as a single-quoted SQL string literal. The
+// bytes are kept as they are, only the characters MySQL requires are escaped.
+func quoteSQLString(value []byte) string {
+	var b strings.Builder
+	b.Grow(len(value) + 2)
+	b.WriteByte('\'')
+	for _, c := range value {
+		switch c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// renderStmtSQL replaces the ? placeholders of a prepared statement with the
+// parameter literals, leaving placeholders inside quotes and comments alone.
+func renderStmtSQL(query string, params []stmtParam) string {
+	if len(params) == 0 {
+		return query
+	}
+	var b strings.Builder
+	argIndex := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(query) {
+				b.WriteByte(c)
+				i++
+				c = query[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && argIndex < len(params):
+			b.WriteString(params[argIndex].Literal)
+			argIndex++
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// usecBetween returns the duration between two capture timestamps in
+// microseconds.
+func usecBetween(start, end time.Time) int64 {
+	if end.Before(start) {
+		return 0
+	}
+	return end.Sub(start).Microseconds()
+}
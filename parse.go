@@ -1,9 +1,9 @@
 package main
 
 import (
-    "bufio"
     "encoding/json"
     "fmt"
+    "io"
     "os"
     "regexp"
     "strconv"
@@ -33,13 +33,22 @@ func ParseLogs(slowLogPath, slowOutputPath string) {
     }
     defer outputFile.Close()
 
-    scanner := bufio.NewScanner(file)
-    buf := make([]byte, 0, 512*1024*1024) // 512MB buffer
-    scanner.Buffer(buf, bufio.MaxScanTokenSize)
+    reader := newLineReader(file, readerConfig.MaxLineSize)
+    reader.keepCR = true
+    stats := newParseStats(readerConfig.QuarantinePath)
+    defer stats.Close()
 
     var currentEntry LogEntry
     var sqlBuffer strings.Builder
     var entryStarted bool = false
+    // The server writes "use db;" and "SET timestamp=N;" between the
+    // comment header and the statement. Every line after them is statement
+    // text and is kept as it is, with its line breaks.
+    var headerDone, inStatement bool
+    // A malformed entry is skipped as a whole and quarantined with the line
+    // that made it malformed.
+    var badReason, badText string
+    var badLine int
 
     // Add support for MySQL 5.6 time format
     reTime56 := regexp.MustCompile(`Time: (\d{6})  ?(\d{1,2}:\d{2}:\d{2})`)
@@ -47,13 +56,60 @@ func ParseLogs(slowLogPath, slowOutputPath string) {
     reTime := regexp.MustCompile(`Time: ([\d-T:.Z]+)`)
     reUser := regexp.MustCompile(`User@Host: (\w+)\[`)
     reConnectionID := regexp.MustCompile(`Id:\s*(\d+)`)
+    reSchema := regexp.MustCompile(`Schema: (\S*)`)
+    reUse := regexp.MustCompile("(?i)^use\\s+`?([^`;\\s]+)`?\\s*;?\\s*$")
+    // Header written when the server (re)starts and opens the log
+    reServerStart := regexp.MustCompile(`^\S+, Version: .* started with:\s*$`)
 
-    for scanner.Scan() {
-        line := scanner.Text()
+    // Database of the last "use db;" line. The server writes one only when
+    // the database differs from the last one written to the file, whatever
+    // the connection, so entries without one are in this database. The
+    // "Schema:" header of an entry overrides it.
+    lastUse := ""
+    markBad := func(reason, text string) {
+        if badReason == "" {
+            badReason, badText, badLine = reason, text, reader.LineNo()
+        }
+    }
+    finishEntry := func() {
+        headerDone, inStatement = false, false
+        stats.Read++
+        if currentEntry.DBName == "" {
+            currentEntry.DBName = lastUse
+        }
+        if badReason != "" {
+            stats.Quarantine(slowLogPath, badLine, badReason, badText)
+            currentEntry = LogEntry{}
+            sqlBuffer.Reset()
+            badReason = ""
+        } else if finalizeEntry(&currentEntry, &sqlBuffer, outputFile) {
+            stats.Emitted++
+        } else {
+            stats.Skip("no statement")
+        }
+    }
+
+    for {
+        line, err := reader.Next()
+        if err == io.EOF {
+            break
+        }
+        if err == errLineTooLong {
+            if entryStarted {
+                markBad("line too long", line)
+            } else {
+                stats.Quarantine(slowLogPath, reader.LineNo(), "line too long", line)
+            }
+            continue
+        }
+        if err != nil {
+            fmt.Println("Error reading file:", err)
+            break
+        }
 
         if strings.HasPrefix(line, "# Time:") {
             if entryStarted {
-                finalizeEntry(&currentEntry, &sqlBuffer, outputFile)
+                finishEntry()
             }
             entryStarted = true
 
@@ -62,7 +118,7 @@ func ParseLogs(slowLogPath, slowOutputPath string) {
                 timeStr := fmt.Sprintf("%s %s", match[1], match[2])
                 parsedTime, err := time.Parse("060102 15:04:05", timeStr)
                 if err != nil {
-                    fmt.Println("Error parsing time:", err)
+                    markBad("invalid time", line)
                     continue
                 }
                 currentEntry.Timestamp = float64(parsedTime.UnixNano()) / 1e9
@@ -71,13 +127,36 @@ func ParseLogs(slowLogPath, slowOutputPath string) {
 
             // MySQL 5.7/8.0 Time Format
             if match := reTime.FindStringSubmatch(line); len(match) > 1 {
-                parsedTime, _ := time.Parse(time.RFC3339Nano, match[1])
+                // the whole field, with the offset of log_timestamps=SYSTEM
+                parsedTime, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(strings.TrimPrefix(line, "# Time:")))
+                if err != nil {
+                    markBad("invalid time", line)
+                    continue
+                }
                 currentEntry.Timestamp = float64(parsedTime.UnixNano()) / 1e9
                 continue
             }
+            markBad("invalid time", line)
             continue
         }
 
+        if entryStarted && inStatement {
+            if reServerStart.MatchString(line) {
+                // the lines up to the next entry are the rest of the header
+                finishEntry()
+                entryStarted = false
+                continue
+            }
+            if !strings.HasPrefix(line, "# User@Host:") {
+                sqlBuffer.WriteString("\n" + line)
+                continue
+            }
+            // MySQL 5.6 only writes "# Time:" when the second changes
+            timestamp := currentEntry.Timestamp
+            finishEntry()
+            currentEntry.Timestamp = timestamp
+        }
+
         if entryStarted {
             if strings.HasPrefix(line, "# User@Host:") {
                 match := reUser.FindStringSubmatch(line)
@@ -90,9 +169,24 @@ func ParseLogs(slowLogPath, slowOutputPath string) {
                 }
             } else if strings.HasPrefix(line, "# Query_time:") {
                 processQueryTimeAndRowsSent(line, &currentEntry)
+            } else if strings.HasPrefix(line, "# administrator command: Quit") {
+                // logged with log_slow_admin_statements, the next header
+                // starts a new entry
+                currentEntry.Event = EventQuit
+                inStatement = true
             } else if !strings.HasPrefix(line, "#") {
-                if !(strings.HasPrefix(line, "SET timestamp=") || strings.HasPrefix(line, "-- ") || strings.HasPrefix(line, "use ")) {
-                    sqlBuffer.WriteString(line + " ")
+                if match := reUse.FindStringSubmatch(line); len(match) > 1 && !headerDone {
+                    lastUse = match[1]
+                } else if strings.HasPrefix(line, "SET timestamp=") && !headerDone {
+                    headerDone = true
+                } else if strings.TrimSpace(line) != "" {
+                    sqlBuffer.WriteString(line)
+                    inStatement = true
+                }
+            }
+            if strings.HasPrefix(line, "#") {
+                if match := reSchema.FindStringSubmatch(line); len(match) > 1 && match[1] != "" {
+                    currentEntry.DBName = match[1]
                 }
             }
         }
@@ -100,12 +194,10 @@ func ParseLogs(slowLogPath, slowOutputPath string) {
 
     // Process the last entry if there is one
     if entryStarted {
-        finalizeEntry(&currentEntry, &sqlBuffer, outputFile)
+        finishEntry()
     }
 
-    if err := scanner.Err(); err != nil {
-        fmt.Println("Error reading file:", err)
-    }
+    fmt.Printf("Slow log processed: %s\n", stats)
 }
 
 func processQueryTimeAndRowsSent(line string, entry *LogEntry) {
@@ -121,14 +213,54 @@ func processQueryTimeAndRowsSent(line string, entry *LogEntry) {
     if len(matchRows) > 1 {
         entry.RowsSent, _ = strconv.Atoi(matchRows[1])
     }
+
+    // log_slow_extra (8.0.14+) and some forks log the start time, in the
+    // format of "# Time:"
+    reStart := regexp.MustCompile(`Start: (\S+)`)
+    matchStart := reStart.FindStringSubmatch(line)
+    if len(matchStart) > 1 {
+        startTime, err := time.Parse(time.RFC3339Nano, matchStart[1])
+        if err != nil {
+            startTime, err = time.Parse("2006-01-02T15:04:05.999999999", matchStart[1])
+        }
+        if err == nil {
+            entry.StartTime = float64(startTime.UnixNano()) / 1e9
+        }
+    }
 }
 
-func finalizeEntry(entry *LogEntry, sqlBuffer *strings.Builder, outputFile *os.File) {
+// setStartTime fills the start time of an entry logged when the statement
+// finished, from its end time and query time when the log has no start time.
+func setStartTime(entry *LogEntry) {
+    if entry.StartTime == 0 && entry.Timestamp != 0 {
+        entry.StartTime = entry.Timestamp - float64(entry.QueryTime)/1e6
+    }
+}
+
+// finalizeEntry writes the entry and reports whether it had a statement or
+// a connection event.
+func finalizeEntry(entry *LogEntry, sqlBuffer *strings.Builder, outputFile *os.File) bool {
     entry.SQL = strings.TrimSpace(sqlBuffer.String())
+    written := false
     // 检查 SQL 是否为空，如果为空，则不处理这条记录
-    if entry.SQL == "" {
-        return
+    if entry.SQL != "" {
+        entry.Event = ""
+        setDigestAndType(entry)
+        setStartTime(entry)
+        writeLogEntry(outputFile, entry)
+        written = true
+    } else if entry.Event != "" {
+        writeLogEntry(outputFile, entry)
+        written = true
     }
+    // Reset for next entry
+    *entry = LogEntry{}
+    sqlBuffer.Reset()
+    return written
+}
+
+// setDigestAndType fills the digest and SQL type of an entry from its SQL.
+func setDigestAndType(entry *LogEntry) {
     normalizedSQL := parser.Normalize(entry.SQL)
     entry.Digest = parser.DigestNormalized(normalizedSQL).String()
     words := strings.Fields(normalizedSQL)
@@ -136,9 +268,14 @@ func finalizeEntry(entry *LogEntry, sqlBuffer *strings.Builder, outputFile *os.F
     if len(words) > 0 {
         entry.SQLType = words[0]
     }
-    jsonEntry, _ := json.Marshal(entry)
-    fmt.Fprintln(outputFile, string(jsonEntry))
-    // Reset for next entry
-    *entry = LogEntry{}
-    sqlBuffer.Reset()
+}
+
+// writeLogEntry writes one entry as a line of the replay file.
+func writeLogEntry(w io.Writer, entry *LogEntry) error {
+    jsonEntry, err := json.Marshal(entry)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Fprintln(w, string(jsonEntry))
+    return err
 }
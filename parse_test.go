@@ -42,7 +42,12 @@ SELECT c FROM sbtest1 WHERE id=250438;
 # QC_Hit: No  Full_scan: No  Full_join: No  Tmp_table: No  Tmp_table_on_disk: No  Filesort: No  Filesort_on_disk: No
 use db;
 SET timestamp=1699200395;
-SELECT c FROM sbtest1 WHERE id=250438;`
+SELECT c FROM sbtest1 WHERE id=250438;
+# Time: 231106  0:06:37
+# User@Host: coplo2o[coplo2o] @  [10.0.2.34]  Id: 45827727
+# Query_time: 0.000100  Lock_time: 0.000042 Rows_sent: 1  Rows_examined: 1
+SET timestamp=1699200397;
+SELECT c FROM sbtest2 WHERE id=1;`
 
     expectedOutput := []LogEntry{
         {
@@ -54,6 +59,7 @@ SELECT c FROM sbtest1 WHERE id=250438;`
             SQLType:      "UPDATE",
             DBName:       "",
             Timestamp:    1724998168.060156,
+            StartTime:    1724998168.060091,
         },
         {
             ConnectionID: "6",
@@ -64,6 +70,7 @@ SELECT c FROM sbtest1 WHERE id=250438;`
             SQLType:      "UPDATE",
             DBName:       "",
             Timestamp:    1724998168.060206,
+            StartTime:    1724998168.060097,
         },
         {
             ConnectionID: "797",
@@ -74,6 +81,7 @@ SELECT c FROM sbtest1 WHERE id=250438;`
             SQLType:      "SELECT",
             DBName:       "",
             Timestamp:    1705681788.141142,
+            StartTime:    1705681788.141104,
         },
         {
             ConnectionID: "797",
@@ -84,6 +92,7 @@ SELECT c FROM sbtest1 WHERE id=250438;`
             SQLType:      "SELECT",
             DBName:       "",
             Timestamp:    1705681788,
+            StartTime:    1705681787.999962,
         },
         {
             ConnectionID: "45827727",
@@ -92,8 +101,20 @@ SELECT c FROM sbtest1 WHERE id=250438;`
             RowsSent:     1,
             Username:     "coplo2o",
             SQLType:      "SELECT",
-            DBName:       "",
+            DBName:       "db",
             Timestamp:    1699229196,
+            StartTime:    1699229195.589701,
+        },
+        {
+            ConnectionID: "45827727",
+            QueryTime:    100,
+            SQL:          "SELECT c FROM sbtest2 WHERE id=1;",
+            RowsSent:     1,
+            Username:     "coplo2o",
+            SQLType:      "SELECT",
+            DBName:       "db",
+            Timestamp:    1699229197,
+            StartTime:    1699229196.9999,
         },
     }
 
@@ -141,11 +162,19 @@ SELECT c FROM sbtest1 WHERE id=250438;`
             actualOutput[i].Username != expectedOutput[i].Username ||
             !strings.EqualFold(actualOutput[i].SQLType, expectedOutput[i].SQLType) ||
             actualOutput[i].DBName != expectedOutput[i].DBName ||
-            !floatEquals(actualOutput[i].Timestamp, expectedOutput[i].Timestamp) {
+            !floatEquals(actualOutput[i].Timestamp, expectedOutput[i].Timestamp) ||
+            !floatEquals(actualOutput[i].StartTime, expectedOutput[i].StartTime) {
             t.Errorf("Output does not match expected output at index %d.\nActual: %v\nExpected: %v", i, actualOutput[i], expectedOutput[i])
         }
     }
 
+    // 回放按开始时间调度，最早的开始时间来自 Start: 字段
+    _, minTimestamp, err := ParseLogEntries(slowOutputPath, "all", "all", "all", nil)
+    os.Remove("ignored_digests.log")
+    if err != nil || !floatEquals(minTimestamp, 1699229195.589701) {
+        t.Errorf("Unexpected replay start: %f, %v", minTimestamp, err)
+    }
+
     // 清理测试文件
     os.Remove(slowLogPath)
     os.Remove(slowOutputPath)
@@ -155,3 +184,196 @@ func floatEquals(a, b float64) bool {
     const epsilon = 1e-6
     return (a-b) < epsilon && (b-a) < epsilon
 }
+
+func TestParseLogsMultiLineStatements(t *testing.T) {
+    slowLogPath := "test_slow_log_multiline.txt"
+    slowOutputPath := "test_output_multiline.json"
+    defer os.Remove(slowLogPath)
+    defer os.Remove(slowOutputPath)
+
+    header := "# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     9\n" +
+        "# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1\n"
+    // 注释、字符串中的换行（包括 CRLF）、以 use/-- 开头的行以及存储过程体都要原样保留
+    commented := "-- pick the order\nSELECT * FROM orders\n-- use the index\nWHERE note = 'line one\r\nuse db2;\n\n-- not a comment'\nAND id = 1;"
+    procedure := "CREATE PROCEDURE p()\nBEGIN\n  # count rows\n  SELECT COUNT(*) FROM t;\n\n  SET timestamp=1;\nEND;"
+    input := "# Time: 2024-08-30T06:09:28.060156Z\n" + header + "use db1;\nSET timestamp=1724998168;\n" + commented + "\n" +
+        "# Time: 2024-08-30T06:09:29.000000Z\n" + header + "SET timestamp=1724998169;\n" + procedure + "\n" +
+        // 语句本身是 use，schema 由下一条记录的 use 行更新
+        "# Time: 2024-08-30T06:09:30.000000Z\n" + header + "SET timestamp=1724998170;\nuse db2;\n" +
+        // MySQL 5.6 同一秒内的记录没有 # Time 行
+        "# Time: 240830  6:09:31\n" + header + "use db2;\nSET timestamp=1724998171;\nselect 1;\n" + header + "SET timestamp=1724998171;\nselect\n2;\n" +
+        // 服务重启时写入的文件头
+        "/usr/sbin/mysqld, Version: 5.7.44 (MySQL Community Server (GPL)). started with:\n" +
+        "Tcp port: 3306  Unix socket: /var/lib/mysql/mysql.sock\n" +
+        "Time                 Id Command    Argument\n" +
+        "# Time: 2024-08-30T06:09:32.000000Z\n" + header + "SET timestamp=1724998172;\nselect 3;\n"
+    err := os.WriteFile(slowLogPath, []byte(input), 0644)
+    if err != nil {
+        t.Fatalf("Failed to write test input file: %v", err)
+    }
+
+    ParseLogs(slowLogPath, slowOutputPath)
+
+    expected := []struct {
+        sql       string
+        dbName    string
+        timestamp float64
+    }{
+        {commented, "db1", 1724998168.060156},
+        {procedure, "db1", 1724998169},
+        {"use db2;", "db1", 1724998170},
+        {"select 1;", "db2", 1724998171},
+        {"select\n2;", "db2", 1724998171},
+        {"select 3;", "db2", 1724998172},
+    }
+    entries := readLogEntriesForTest(t, slowOutputPath)
+    if len(entries) != len(expected) {
+        t.Fatalf("Output length does not match expected length.\nActual: %v\nExpected: %v", len(entries), len(expected))
+    }
+    for i, e := range expected {
+        if entries[i].SQL != e.sql || entries[i].DBName != e.dbName || !floatEquals(entries[i].Timestamp, e.timestamp) {
+            t.Errorf("Output does not match expected output at index %d.\nActual: %q %s %f\nExpected: %q %s %f",
+                i, entries[i].SQL, entries[i].DBName, entries[i].Timestamp, e.sql, e.dbName, e.timestamp)
+        }
+    }
+    if !strings.EqualFold(entries[0].SQLType, "select") || !strings.EqualFold(entries[1].SQLType, "create") {
+        t.Errorf("Unexpected sql types: %s, %s", entries[0].SQLType, entries[1].SQLType)
+    }
+}
+
+func TestParseLogsInterleavedSchemas(t *testing.T) {
+    slowLogPath := "test_slow_log_schemas.txt"
+    slowOutputPath := "test_output_schemas.json"
+    defer os.Remove(slowLogPath)
+    defer os.Remove(slowOutputPath)
+
+    // use 行只在库与文件中上一次写入的库不同时出现，与连接无关：
+    // 连接 10 与连接 9 在同一个库中，它的记录没有 use 行
+    input := `# Time: 2024-08-30T06:09:28.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     9
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1
+use db1;
+SET timestamp=1724998168;
+select 1;
+# Time: 2024-08-30T06:09:29.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     10
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1
+SET timestamp=1724998169;
+select 2;
+# Time: 2024-08-30T06:09:30.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     10
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1
+use db2;
+SET timestamp=1724998170;
+select 3;
+# Time: 2024-08-30T06:09:31.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     11
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1 Thread_id: 11 Schema: db3
+SET timestamp=1724998171;
+select 4;
+# Time: 2024-08-30T06:09:32.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     9
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1
+use db1;
+SET timestamp=1724998172;
+select 5;
+# Time: 2024-08-30T06:09:33.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     10
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1
+use db2;
+SET timestamp=1724998173;
+select 6;
+`
+    if err := os.WriteFile(slowLogPath, []byte(input), 0644); err != nil {
+        t.Fatalf("Failed to write test input file: %v", err)
+    }
+
+    ParseLogs(slowLogPath, slowOutputPath)
+
+    // Schema 字段只覆盖所在的记录
+    expected := []struct {
+        connID, dbName string
+    }{{"9", "db1"}, {"10", "db1"}, {"10", "db2"}, {"11", "db3"}, {"9", "db1"}, {"10", "db2"}}
+    entries := readLogEntriesForTest(t, slowOutputPath)
+    if len(entries) != len(expected) {
+        t.Fatalf("Expected %d entries, got %+v", len(expected), entries)
+    }
+    for i, e := range expected {
+        if entries[i].ConnectionID != e.connID || entries[i].DBName != e.dbName {
+            t.Errorf("Unexpected entry %d: %s %s, expected %s %s", i, entries[i].ConnectionID, entries[i].DBName, e.connID, e.dbName)
+        }
+    }
+}
+
+func TestParseLogsQuitEvent(t *testing.T) {
+    slowLogPath := "test_slow_log_quit.txt"
+    slowOutputPath := "test_output_quit.json"
+    defer os.Remove(slowLogPath)
+    defer os.Remove(slowOutputPath)
+    defer os.Remove("ignored_digests.log")
+
+    // log_slow_admin_statements 打开时记录的 Quit 转换为断开连接事件
+    input := `# Time: 2024-08-30T06:09:28.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     9
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1
+use db1;
+SET timestamp=1724998168;
+UPDATE t SET c = 1;
+# Time: 2024-08-30T06:09:29.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     9
+# Query_time: 0.000005  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1724998169;
+# administrator command: Quit;
+# Time: 2024-08-30T06:09:30.000000Z
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     10
+# Query_time: 0.000005  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1724998170;
+# administrator command: Prepare;
+`
+    err := os.WriteFile(slowLogPath, []byte(input), 0644)
+    if err != nil {
+        t.Fatalf("Failed to write test input file: %v", err)
+    }
+
+    ParseLogs(slowLogPath, slowOutputPath)
+
+    entries := readLogEntriesForTest(t, slowOutputPath)
+    if len(entries) != 2 || entries[0].Event != "" || entries[1].Event != EventQuit || entries[1].SQL != "" ||
+        entries[1].ConnectionID != "9" || entries[1].DBName != "db1" || !floatEquals(entries[1].Timestamp, 1724998169) {
+        t.Fatalf("Unexpected entries: %+v", entries)
+    }
+
+    // 连接事件不受 SQL 类型与库名过滤的影响
+    tasksMap, _, err := ParseLogEntries(slowOutputPath, "t1", "select", "db2", nil)
+    if err != nil {
+        t.Fatalf("ParseLogEntries failed: %v", err)
+    }
+    if len(tasksMap["9"]) != 1 || tasksMap["9"][0].Event != EventQuit {
+        t.Errorf("Unexpected tasks: %+v", tasksMap)
+    }
+    tasksMap, _, _ = ParseLogEntries(slowOutputPath, "t2", "all", "all", nil)
+    if len(tasksMap) != 0 {
+        t.Errorf("Unexpected tasks for another user: %+v", tasksMap)
+    }
+
+    // MySQL 5.6 同一秒内的记录没有 # Time 行，Quit 之后的 User@Host 开始新的记录
+    input = `# Time: 240830  6:09:28
+# User@Host: t1[t1] @ localhost [127.0.0.1]  Id:     9
+# Query_time: 0.000005  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1724998168;
+# administrator command: Quit;
+# User@Host: t2[t2] @ localhost [127.0.0.1]  Id:    10
+# Query_time: 0.000065  Lock_time: 0.000022 Rows_sent: 0  Rows_examined: 1
+SET timestamp=1724998168;
+UPDATE t SET c = 2;
+`
+    if err := os.WriteFile(slowLogPath, []byte(input), 0644); err != nil {
+        t.Fatalf("Failed to write test input file: %v", err)
+    }
+    ParseLogs(slowLogPath, slowOutputPath)
+    entries = readLogEntriesForTest(t, slowOutputPath)
+    if len(entries) != 2 || entries[0].Event != EventQuit || entries[0].ConnectionID != "9" || entries[0].Username != "t1" ||
+        entries[1].Event != "" || entries[1].ConnectionID != "10" || entries[1].Username != "t2" || entries[1].SQL != "UPDATE t SET c = 2;" {
+        t.Fatalf("Unexpected entries: %+v", entries)
+    }
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column names of the Alibaba Cloud RDS SQL Insight / SQL audit exports,
+// after normalizeAliyunHeader. Console exports (English and Chinese) and the
+// DescribeSQLLogRecords API field names are accepted.
+var aliyunColumns = map[string][]string{
+	"thread":  {"threadid", "线程id", "会话id"},
+	"user":    {"user", "username", "accountname", "account", "用户", "用户名", "账号"},
+	"db":      {"db", "dbname", "database", "databasename", "数据库", "数据库名"},
+	"time":    {"origintime", "executetime", "starttime", "发起时间", "执行时间", "开始时间"},
+	"sql":     {"sql", "sqltext", "sql文本", "sql语句"},
+	"rows":    {"returnrows", "returnrowcounts", "rows", "返回行数"},
+	"latency": {"latency", "totalexecutiontimes", "executionduration", "duration", "执行耗时", "耗时", "响应时间"},
+}
+
+var reHeaderUnit = regexp.MustCompile(`[（(]([^)）]*)[)）]\s*$`)
+
+type aliyunColumnIndex struct {
+	index         map[string]int
+	latencyFactor float64 // latency column unit, in microseconds
+}
+
+// normalizeAliyunHeader lowercases a header and strips spaces, underscores
+// and the unit suffix, so "Latency(ms)" and "latency" map to the same column.
+func normalizeAliyunHeader(name string) (string, string) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")
+	unit := ""
+	if match := reHeaderUnit.FindStringSubmatch(name); match != nil {
+		unit = strings.ToLower(strings.TrimSpace(match[1]))
+		name = name[:len(name)-len(match[0])]
+	}
+	name = strings.ToLower(name)
+	name = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name)
+	return name, unit
+}
+
+func latencyFactor(unit string) float64 {
+	switch unit {
+	case "s", "sec", "秒":
+		return 1e6
+	case "ms", "毫秒":
+		return 1e3
+	}
+	// The API reports microseconds, as does the console without a unit.
+	return 1
+}
+
+func newAliyunColumnIndex(header []string) (*aliyunColumnIndex, error) {
+	idx := &aliyunColumnIndex{index: make(map[string]int), latencyFactor: 1}
+	for i, h := range header {
+		name, unit := normalizeAliyunHeader(h)
+		for column, aliases := range aliyunColumns {
+			if _, ok := idx.index[column]; ok || !contains(aliases, name) {
+				continue
+			}
+			idx.index[column] = i
+			if column == "latency" {
+				idx.latencyFactor = latencyFactor(unit)
+			}
+		}
+	}
+	for _, column := range []string{"thread", "sql", "time"} {
+		if _, ok := idx.index[column]; !ok {
+			return nil, fmt.Errorf("missing %s column in header %v", column, header)
+		}
+	}
+	return idx, nil
+}
+
+func (idx *aliyunColumnIndex) field(record []string, column string) string {
+	i, ok := idx.index[column]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// parseAliyunTime accepts RFC 3339 times, local "2006-01-02 15:04:05" times
+// as shown by the console, and epoch milliseconds.
+func parseAliyunTime(value string) (float64, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return float64(ms) / 1e3, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return float64(t.UnixNano()) / 1e9, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", value, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.UnixNano()) / 1e9, nil
+}
+
+func (idx *aliyunColumnIndex) entry(record []string) (*LogEntry, error) {
+	ts, err := parseAliyunTime(idx.field(record, "time"))
+	if err != nil {
+		return nil, err
+	}
+	entry := &LogEntry{
+		ConnectionID: idx.field(record, "thread"),
+		Username:     idx.field(record, "user"),
+		DBName:       idx.field(record, "db"),
+		SQL:          idx.field(record, "sql"),
+		Timestamp:    ts,
+	}
+	if v := idx.field(record, "latency"); v != "" {
+		latency, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		entry.QueryTime = int64(latency * idx.latencyFactor)
+	}
+	if v := idx.field(record, "rows"); v != "" {
+		rows, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entry.RowsSent = int(rows)
+	}
+	return entry, nil
+}
+
+// ParseAliyunAudit converts an Alibaba Cloud RDS SQL Insight / SQL audit CSV
+// export into replay entries.
+func ParseAliyunAudit(exportPath, outputPath string) {
+	if exportPath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parsealiyunaudit -slow-in <path_to_sql_insight_csv> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	file, err := os.Open(exportPath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		fmt.Println("Error reading header:", err)
+		return
+	}
+	idx, err := newAliyunColumnIndex(header)
+	if err != nil {
+		fmt.Println("Error reading header:", err)
+		return
+	}
+
+	var entries []*LogEntry
+	skipped := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("Error reading file:", err)
+			return
+		}
+		entry, err := idx.entry(record)
+		if err != nil || entry.ConnectionID == "" {
+			skipped++
+			continue
+		}
+		if entry.SQL == "" {
+			continue
+		}
+		setDigestAndType(entry)
+		entries = append(entries, entry)
+	}
+
+	// Exports are usually sorted newest first, replay needs them in time order.
+	// Reversing first keeps statements of the same second in log order.
+	if len(entries) > 1 && entries[0].Timestamp > entries[len(entries)-1].Timestamp {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+	for _, entry := range entries {
+		if err := writeLogEntry(writer, entry); err != nil {
+			fmt.Println("Error writing output:", err)
+			return
+		}
+	}
+	fmt.Printf("SQL insight export processed: %d statements written to output json, %d malformed records skipped\n", len(entries), skipped)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestParseAliyunAudit(t *testing.T) {
+	exportPath := "test_aliyun_export.csv"
+	outputPath := "test_aliyun_output.json"
+	defer os.Remove(exportPath)
+	defer os.Remove(outputPath)
+
+	// 控制台导出按时间倒序，SQL 中可能包含换行和引号
+	input := "\ufeffOrigin Time,Thread ID,User,Database Name,Latency(ms),Return Rows,Host Address,SQL Text\n" +
+		"2024-01-19T08:29:50Z,12,app,shop,1.5,0,10.0.0.1,\"UPDATE t\nSET c = \"\"x\"\"\nWHERE id = 1\"\n" +
+		"2024-01-19T08:29:49Z,11,app,shop,0.2,1,10.0.0.1,select 1\n" +
+		"2024-01-19T08:29:49Z,11,app,shop,0.3,3,10.0.0.1,SELECT c FROM sbtest1 WHERE id=5\n" +
+		"bad time,11,app,shop,0.3,3,10.0.0.1,select 2\n"
+	if err := os.WriteFile(exportPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	ParseAliyunAudit(exportPath, outputPath)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+
+	expected := []LogEntry{
+		{ConnectionID: "11", Username: "app", DBName: "shop", SQL: "SELECT c FROM sbtest1 WHERE id=5", SQLType: "select", QueryTime: 300, RowsSent: 3, Timestamp: 1705652989},
+		{ConnectionID: "11", Username: "app", DBName: "shop", SQL: "select 1", SQLType: "select", QueryTime: 200, RowsSent: 1, Timestamp: 1705652989},
+		{ConnectionID: "12", Username: "app", DBName: "shop", SQL: "UPDATE t\nSET c = \"x\"\nWHERE id = 1", SQLType: "update", QueryTime: 1500, Timestamp: 1705652990},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.Username != e.Username || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || a.QueryTime != e.QueryTime || a.RowsSent != e.RowsSent || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+}
+
+func TestNewAliyunColumnIndex(t *testing.T) {
+	idx, err := newAliyunColumnIndex([]string{"线程ID", "用户名", "数据库名", "执行耗时（微秒）", "返回行数", "执行时间", "SQL语句"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if idx.index["thread"] != 0 || idx.index["latency"] != 3 || idx.index["sql"] != 6 || idx.latencyFactor != 1 {
+		t.Errorf("Unexpected column index: %+v", idx)
+	}
+	if _, err := newAliyunColumnIndex([]string{"User", "SQL Text"}); err == nil {
+		t.Errorf("Expected an error for a header without thread id and time")
+	}
+}
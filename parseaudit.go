@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditRecord is the part of an audit plugin record that replay needs.
+type auditRecord struct {
+	Name         string // Connect, Quit, Query, Execute, Init DB, Change user ...
+	Timestamp    float64
+	ConnectionID string
+	Status       int
+	SQL          string
+	Username     string
+	DBName       string
+}
+
+// ParseAuditLogs converts Percona audit_log (old/new XML and JSON) and MySQL
+// Enterprise Audit JSON logs into replay entries. The format is detected
+// from the content of the file.
+func ParseAuditLogs(logPath, outputPath string) {
+	if logPath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parseauditlog -slow-in <path_to_audit_log> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	tracker := newAuditSessionTracker(func(entry *LogEntry) {
+		if err := writeLogEntry(writer, entry); err != nil {
+			fmt.Println("Error writing output:", err)
+		}
+	})
+
+	reader := bufio.NewReaderSize(file, 1024*1024)
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		fmt.Println("Error reading file:", err)
+		return
+	}
+	if first == '<' {
+		err = readXMLAuditLog(reader, tracker.Record)
+	} else {
+		err = readJSONAuditLog(reader, tracker.Record)
+	}
+	if err != nil {
+		fmt.Println("Error reading file:", err)
+	}
+	fmt.Printf("Audit log processed: %d statements, %d connection events written to output json\n", tracker.statements, tracker.events)
+}
+
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' && c != 0xef && c != 0xbb && c != 0xbf {
+			return c, r.UnreadByte()
+		}
+	}
+}
+
+// auditSessionTracker keeps the user and database of each connection, so
+// records that do not carry them still get the session values.
+type auditSessionTracker struct {
+	emit       func(entry *LogEntry)
+	sessions   map[string]*generalSession
+	statements int
+	events     int
+}
+
+func newAuditSessionTracker(emit func(entry *LogEntry)) *auditSessionTracker {
+	return &auditSessionTracker{emit: emit, sessions: make(map[string]*generalSession)}
+}
+
+func (t *auditSessionTracker) Record(rec auditRecord) {
+	session := t.sessions[rec.ConnectionID]
+	if session == nil {
+		session = &generalSession{}
+		t.sessions[rec.ConnectionID] = session
+	}
+	name := strings.ToLower(rec.Name)
+	failedLogin := rec.Status != 0 && (name == "connect" || name == "change user")
+	if rec.Username != "" && !failedLogin {
+		session.username = rec.Username
+	}
+	if rec.DBName != "" && !failedLogin {
+		session.dbName = rec.DBName
+	}
+	entry := &LogEntry{
+		ConnectionID: rec.ConnectionID,
+		Username:     session.username,
+		DBName:       session.dbName,
+		Timestamp:    rec.Timestamp,
+		ErrorCode:    rec.Status,
+	}
+
+	switch name {
+	case "connect":
+		if failedLogin {
+			delete(t.sessions, rec.ConnectionID)
+			return
+		}
+		entry.Event = EventConnect
+	case "quit", "disconnect":
+		delete(t.sessions, rec.ConnectionID)
+		entry.Event = EventQuit
+	case "init db":
+		if rec.Status == 0 && rec.DBName == "" && rec.SQL != "" {
+			session.dbName = rec.SQL
+		}
+		return
+	case "query", "execute":
+		entry.SQL = strings.TrimSpace(rec.SQL)
+		if entry.SQL == "" {
+			return
+		}
+		if match := reUseStatement.FindStringSubmatch(entry.SQL); match != nil && rec.Status == 0 {
+			session.dbName = match[1]
+		}
+		setDigestAndType(entry)
+		t.emit(entry)
+		t.statements++
+		return
+	default:
+		return
+	}
+	t.emit(entry)
+	t.events++
+}
+
+// parseAuditTime reads the timestamps of both plugins: "2024-01-19T16:29:48 UTC",
+// RFC 3339, and the Enterprise "2024-01-19 16:29:48" in UTC.
+func parseAuditTime(value string) (float64, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05 MST", time.RFC3339Nano, "2006-01-02 15:04:05.999999"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return float64(t.UnixNano()) / 1e9, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// perconaAuditRecord maps the fields of a Percona record, which are the same
+// in all of its formats (lowercased here).
+func perconaAuditRecord(fields map[string]string) (auditRecord, error) {
+	ts, err := parseAuditTime(fields["timestamp"])
+	if err != nil {
+		return auditRecord{}, err
+	}
+	status, _ := strconv.Atoi(fields["status"])
+	return auditRecord{
+		Name:         fields["name"],
+		Timestamp:    ts,
+		ConnectionID: fields["connection_id"],
+		Status:       status,
+		SQL:          fields["sqltext"],
+		Username:     auditUsername(fields["user"]),
+		DBName:       fields["db"],
+	}, nil
+}
+
+// readXMLAuditLog reads the Percona old (attributes) and new (elements) XML
+// formats. A log that is still being written has no closing root element.
+func readXMLAuditLog(r io.Reader, record func(auditRecord)) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "unexpected EOF") {
+				return nil
+			}
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "AUDIT_RECORD" {
+			continue
+		}
+		fields := make(map[string]string)
+		for _, attr := range start.Attr {
+			fields[strings.ToLower(attr.Name.Local)] = attr.Value
+		}
+		if len(start.Attr) == 0 {
+			var children struct {
+				Fields []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			}
+			if err := decoder.DecodeElement(&children, &start); err != nil {
+				return err
+			}
+			for _, f := range children.Fields {
+				fields[strings.ToLower(f.XMLName.Local)] = f.Value
+			}
+		}
+		rec, err := perconaAuditRecord(fields)
+		if err != nil {
+			continue
+		}
+		record(rec)
+	}
+}
+
+// auditValue accepts a JSON string or number, the plugins do not agree on
+// the type of connection ids and status codes.
+type auditValue string
+
+func (v *auditValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = auditValue(s)
+		return nil
+	}
+	*v = auditValue(strings.TrimSpace(string(data)))
+	return nil
+}
+
+// jsonAuditRecord covers the Percona JSON record (under audit_record) and the
+// MySQL Enterprise Audit JSON record.
+type jsonAuditRecord struct {
+	Percona map[string]auditValue `json:"audit_record"`
+
+	Timestamp    string     `json:"timestamp"`
+	Class        string     `json:"class"`
+	Event        string     `json:"event"`
+	ConnectionID auditValue `json:"connection_id"`
+	Account      struct {
+		User string `json:"user"`
+	} `json:"account"`
+	Login struct {
+		User string `json:"user"`
+	} `json:"login"`
+	ConnectionData struct {
+		Status int    `json:"status"`
+		DB     string `json:"db"`
+	} `json:"connection_data"`
+	GeneralData struct {
+		Command string `json:"command"`
+		Query   string `json:"query"`
+		Status  int    `json:"status"`
+	} `json:"general_data"`
+}
+
+// readJSONAuditLog reads one JSON record per line (Percona) or a JSON array
+// of records (Enterprise), which may be unterminated.
+func readJSONAuditLog(r *bufio.Reader, record func(auditRecord)) error {
+	decoder := json.NewDecoder(r)
+	if first, _ := peekNonSpace(r); first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+	for decoder.More() {
+		var raw jsonAuditRecord
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.ErrUnexpectedEOF || strings.Contains(err.Error(), "unexpected end of JSON input") {
+				return nil
+			}
+			return err
+		}
+		rec, ok := raw.auditRecord()
+		if ok {
+			record(rec)
+		}
+	}
+	return nil
+}
+
+func (raw *jsonAuditRecord) auditRecord() (auditRecord, bool) {
+	if raw.Percona != nil {
+		fields := make(map[string]string, len(raw.Percona))
+		for k, v := range raw.Percona {
+			fields[k] = string(v)
+		}
+		rec, err := perconaAuditRecord(fields)
+		return rec, err == nil
+	}
+
+	ts, err := parseAuditTime(raw.Timestamp)
+	if err != nil {
+		return auditRecord{}, false
+	}
+	rec := auditRecord{
+		Timestamp:    ts,
+		ConnectionID: string(raw.ConnectionID),
+		Username:     raw.Account.User,
+	}
+	switch raw.Class {
+	case "connection":
+		switch raw.Event {
+		case "connect", "change_user":
+			rec.Name = "Connect"
+			if raw.Event == "change_user" {
+				rec.Name = "Change user"
+			}
+			if rec.Username == "" {
+				rec.Username = raw.Login.User
+			}
+			rec.Status = raw.ConnectionData.Status
+			rec.DBName = raw.ConnectionData.DB
+		case "disconnect":
+			rec.Name = "Quit"
+		}
+	case "general":
+		rec.Name = raw.GeneralData.Command
+		rec.SQL = raw.GeneralData.Query
+		rec.Status = raw.GeneralData.Status
+	}
+	return rec, rec.Name != ""
+}
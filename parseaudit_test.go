@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func parseAuditLogForTest(t *testing.T, input string) []LogEntry {
+	logPath := "test_audit.log"
+	outputPath := "test_audit_output.json"
+	defer os.Remove(logPath)
+	defer os.Remove(outputPath)
+	if err := os.WriteFile(logPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	ParseAuditLogs(logPath, outputPath)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+	return actual
+}
+
+func compareAuditEntries(t *testing.T, actual, expected []LogEntry) {
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.Username != e.Username || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || a.Event != e.Event || a.ErrorCode != e.ErrorCode || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+}
+
+// Percona 三种格式内容相同，输出也应相同
+var perconaExpected = []LogEntry{
+	{ConnectionID: "5", Username: "app", DBName: "shop", Event: EventConnect, Timestamp: 1705681788},
+	{ConnectionID: "5", Username: "app", DBName: "shop", SQL: "select * from t where c = \"a\"\nand id < 3", SQLType: "select", Timestamp: 1705681789},
+	{ConnectionID: "5", Username: "app", DBName: "shop", SQL: "select * from missing", SQLType: "select", Timestamp: 1705681789, ErrorCode: 1146},
+	{ConnectionID: "5", Username: "app", DBName: "shop", Event: EventQuit, Timestamp: 1705681790},
+}
+
+func TestParseAuditLogsPerconaOldXML(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<AUDIT>
+<AUDIT_RECORD
+  NAME="Connect"
+  RECORD="1_2024-01-19T16:29:48"
+  TIMESTAMP="2024-01-19T16:29:48 UTC"
+  CONNECTION_ID="5"
+  STATUS="0"
+  USER="app"
+  PRIV_USER="app"
+  HOST="localhost"
+  IP="10.0.0.1"
+  DB="shop"
+/>
+<AUDIT_RECORD
+  NAME="Connect"
+  RECORD="2_2024-01-19T16:29:48"
+  TIMESTAMP="2024-01-19T16:29:48 UTC"
+  CONNECTION_ID="6"
+  STATUS="1045"
+  USER="bad"
+  DB=""
+/>
+<AUDIT_RECORD
+  NAME="Query"
+  RECORD="3_2024-01-19T16:29:49"
+  TIMESTAMP="2024-01-19T16:29:49 UTC"
+  COMMAND_CLASS="select"
+  CONNECTION_ID="5"
+  STATUS="0"
+  SQLTEXT="select * from t where c = &quot;a&quot;&#10;and id &lt; 3"
+  USER="app[app] @  [10.0.0.1]"
+  DB="shop"
+/>
+<AUDIT_RECORD
+  NAME="Query"
+  RECORD="4_2024-01-19T16:29:49"
+  TIMESTAMP="2024-01-19T16:29:49 UTC"
+  COMMAND_CLASS="select"
+  CONNECTION_ID="5"
+  STATUS="1146"
+  SQLTEXT="select * from missing"
+  USER="app[app] @  [10.0.0.1]"
+  DB="shop"
+/>
+<AUDIT_RECORD
+  NAME="Quit"
+  RECORD="5_2024-01-19T16:29:50"
+  TIMESTAMP="2024-01-19T16:29:50 UTC"
+  CONNECTION_ID="5"
+  STATUS="0"
+  USER="app"
+  DB=""
+/>
+`
+	compareAuditEntries(t, parseAuditLogForTest(t, input), perconaExpected)
+}
+
+func TestParseAuditLogsPerconaNewXML(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<AUDIT>
+ <AUDIT_RECORD>
+  <NAME>Connect</NAME>
+  <TIMESTAMP>2024-01-19T16:29:48 UTC</TIMESTAMP>
+  <CONNECTION_ID>5</CONNECTION_ID>
+  <STATUS>0</STATUS>
+  <USER>app</USER>
+  <DB>shop</DB>
+ </AUDIT_RECORD>
+ <AUDIT_RECORD>
+  <NAME>Query</NAME>
+  <TIMESTAMP>2024-01-19T16:29:49 UTC</TIMESTAMP>
+  <COMMAND_CLASS>select</COMMAND_CLASS>
+  <CONNECTION_ID>5</CONNECTION_ID>
+  <STATUS>0</STATUS>
+  <SQLTEXT>select * from t where c = "a"
+and id &lt; 3</SQLTEXT>
+  <USER>app[app] @  [10.0.0.1]</USER>
+  <DB>shop</DB>
+ </AUDIT_RECORD>
+ <AUDIT_RECORD>
+  <NAME>Query</NAME>
+  <TIMESTAMP>2024-01-19T16:29:49 UTC</TIMESTAMP>
+  <CONNECTION_ID>5</CONNECTION_ID>
+  <STATUS>1146</STATUS>
+  <SQLTEXT>select * from missing</SQLTEXT>
+  <USER>app[app] @  [10.0.0.1]</USER>
+  <DB>shop</DB>
+ </AUDIT_RECORD>
+ <AUDIT_RECORD>
+  <NAME>Quit</NAME>
+  <TIMESTAMP>2024-01-19T16:29:50 UTC</TIMESTAMP>
+  <CONNECTION_ID>5</CONNECTION_ID>
+  <STATUS>0</STATUS>
+  <USER>app</USER>
+  <DB></DB>
+ </AUDIT_RECORD>
+`
+	compareAuditEntries(t, parseAuditLogForTest(t, input), perconaExpected)
+}
+
+func TestParseAuditLogsPerconaJSON(t *testing.T) {
+	input := `{"audit_record":{"name":"Connect","record":"1_2024-01-19T16:29:48","timestamp":"2024-01-19T16:29:48Z","connection_id":"5","status":0,"user":"app","priv_user":"app","os_login":"","proxy_user":"","host":"localhost","ip":"10.0.0.1","db":"shop"}}
+{"audit_record":{"name":"Query","record":"2_2024-01-19T16:29:49","timestamp":"2024-01-19T16:29:49Z","command_class":"select","connection_id":"5","status":0,"sqltext":"select * from t where c = \"a\"\nand id < 3","user":"app[app] @  [10.0.0.1]","host":"","os_user":"","ip":"10.0.0.1","db":"shop"}}
+{"audit_record":{"name":"Query","record":"3_2024-01-19T16:29:49","timestamp":"2024-01-19T16:29:49Z","command_class":"select","connection_id":"5","status":1146,"sqltext":"select * from missing","user":"app[app] @  [10.0.0.1]","host":"","os_user":"","ip":"10.0.0.1","db":"shop"}}
+{"audit_record":{"name":"Quit","record":"4_2024-01-19T16:29:50","timestamp":"2024-01-19T16:29:50Z","connection_id":"5","status":0,"user":"app","priv_user":"app","os_login":"","proxy_user":"","host":"localhost","ip":"10.0.0.1","db":""}}
+`
+	compareAuditEntries(t, parseAuditLogForTest(t, input), perconaExpected)
+}
+
+func TestParseAuditLogsEnterpriseJSON(t *testing.T) {
+	// 企业版审计日志为 JSON 数组，写入过程中数组可能没有结束
+	input := `[
+{
+  "timestamp": "2024-01-19 16:29:48",
+  "id": 0,
+  "class": "connection",
+  "event": "connect",
+  "connection_id": 5,
+  "account": { "user": "app", "host": "%" },
+  "login": { "user": "app", "os": "", "ip": "10.0.0.1", "proxy": "" },
+  "connection_data": { "connection_type": "tcp/ip", "status": 0, "db": "shop" }
+},
+{
+  "timestamp": "2024-01-19 16:29:49",
+  "id": 1,
+  "class": "general",
+  "event": "status",
+  "connection_id": 5,
+  "account": { "user": "app", "host": "%" },
+  "login": { "user": "app", "os": "", "ip": "10.0.0.1", "proxy": "" },
+  "general_data": { "command": "Query", "sql_command": "select", "query": "select * from t where c = \"a\"\nand id < 3", "status": 0 }
+},
+{
+  "timestamp": "2024-01-19 16:29:49",
+  "id": 2,
+  "class": "general",
+  "event": "status",
+  "connection_id": 5,
+  "account": { "user": "app", "host": "%" },
+  "login": { "user": "app", "os": "", "ip": "10.0.0.1", "proxy": "" },
+  "general_data": { "command": "Query", "sql_command": "select", "query": "select * from missing", "status": 1146 }
+},
+{
+  "timestamp": "2024-01-19 16:29:50",
+  "id": 3,
+  "class": "connection",
+  "event": "disconnect",
+  "connection_id": 5,
+  "account": { "user": "app", "host": "%" },
+  "login": { "user": "app", "os": "", "ip": "10.0.0.1", "proxy": "" },
+  "connection_data": { "connection_type": "tcp/ip" }
+},
+`
+	compareAuditEntries(t, parseAuditLogForTest(t, input), perconaExpected)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// binlogRowsStmtEnd is the flag of the last rows event of a statement.
+const binlogRowsStmtEnd = 0x01
+
+// binlogDMLTypes are the statements of statement format events that are
+// written to the output, DDL and administrative statements are skipped.
+var binlogDMLTypes = []string{"insert", "update", "delete", "replace"}
+
+// binlogTxn is the transaction being read. Its entries are written when it
+// commits, and only if it contains DML.
+type binlogTxn struct {
+	connID  string
+	db      string
+	entries []*LogEntry
+	dml     int
+}
+
+// binlogConverter turns the events of one or more binary logs into replay
+// entries. Row events are rendered into INSERT, UPDATE and DELETE
+// statements on the fully qualified table.
+type binlogConverter struct {
+	tables map[uint64]*binlogTable
+	txn    *binlogTxn
+	// skipRows is set after a Rows_query event, whose original statement
+	// is used instead of the row events that follow it.
+	skipRows bool
+	emit     func(entry *LogEntry) error
+
+	statements  int
+	txns        int
+	noNameRows  int
+	unsupported int
+}
+
+func newBinlogConverter(emit func(entry *LogEntry) error) *binlogConverter {
+	return &binlogConverter{tables: make(map[uint64]*binlogTable), emit: emit}
+}
+
+func (c *binlogConverter) newEntry(ev *binlogEvent, sql string) *LogEntry {
+	entry := &LogEntry{SQL: sql, Timestamp: float64(ev.Timestamp)}
+	if c.txn != nil {
+		entry.ConnectionID = c.txn.connID
+		entry.DBName = c.txn.db
+	}
+	setDigestAndType(entry)
+	return entry
+}
+
+// add adds a DML entry to the current transaction, or writes it when there
+// is no transaction (non-transactional tables).
+func (c *binlogConverter) add(entry *LogEntry) error {
+	if c.txn == nil {
+		c.statements++
+		return c.emit(entry)
+	}
+	c.txn.entries = append(c.txn.entries, entry)
+	c.txn.dml++
+	return nil
+}
+
+func (c *binlogConverter) commit(ev *binlogEvent, sql string) error {
+	txn := c.txn
+	if txn == nil {
+		return nil
+	}
+	txn.entries = append(txn.entries, c.newEntry(ev, sql))
+	c.txn = nil
+	c.skipRows = false
+	if txn.dml == 0 {
+		return nil
+	}
+	c.txns++
+	c.statements += txn.dml
+	for _, entry := range txn.entries {
+		if err := c.emit(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Process handles one event.
+func (c *binlogConverter) Process(ev *binlogEvent) error {
+	switch ev.Type {
+	case binlogQueryEvent:
+		q, err := parseBinlogQuery(ev.Body)
+		if err != nil {
+			return err
+		}
+		sql := strings.TrimSpace(q.SQL)
+		switch classifyTxnStatement(sql) {
+		case txnBegin:
+			c.txn = &binlogTxn{connID: strconv.FormatUint(uint64(q.ThreadID), 10), db: q.DB}
+			c.txn.entries = append(c.txn.entries, c.newEntry(ev, sql))
+			return nil
+		case txnCommit, txnRollback:
+			return c.commit(ev, sql)
+		}
+		entry := c.newEntry(ev, sql)
+		entry.ConnectionID = strconv.FormatUint(uint64(q.ThreadID), 10)
+		entry.DBName = q.DB
+		entry.ErrorCode = int(q.ErrorCode)
+		if !contains(binlogDMLTypes, entry.SQLType) {
+			return nil
+		}
+		return c.add(entry)
+	case binlogXidEvent:
+		return c.commit(ev, "COMMIT")
+	case binlogTableMapEvent:
+		table, err := parseBinlogTableMap(ev.Body)
+		if err != nil {
+			return err
+		}
+		c.tables[table.ID] = table
+	case binlogRowsQueryEvent:
+		// one byte length, ignored because it overflows for long statements
+		if len(ev.Body) < 1 {
+			return errBinlogTruncated
+		}
+		c.skipRows = true
+		return c.add(c.newEntry(ev, strings.TrimSpace(string(ev.Body[1:]))))
+	case binlogWriteRowsEventV1, binlogUpdateRowsEventV1, binlogDeleteRowsEventV1,
+		binlogWriteRowsEvent, binlogUpdateRowsEvent, binlogDeleteRowsEvent:
+		if c.skipRows {
+			// until the end of the statement
+			if len(ev.Body) >= 8 && ev.Body[6]&binlogRowsStmtEnd != 0 {
+				c.skipRows = false
+			}
+			return nil
+		}
+		rows, table, err := parseBinlogRows(ev.Type, ev.Body, c.tables)
+		if err != nil {
+			return err
+		}
+		for _, sql := range c.renderRows(ev.Type, rows, table) {
+			entry := c.newEntry(ev, sql)
+			entry.DBName = table.Schema
+			if err := c.add(entry); err != nil {
+				return err
+			}
+		}
+	case binlogPartialUpdateRowsEvent, binlogTransactionPayload:
+		// binlog_row_value_options=PARTIAL_JSON and
+		// binlog_transaction_compression are not supported
+		c.unsupported++
+	}
+	return nil
+}
+
+// renderRows renders the rows of one event. Without column names an INSERT
+// lists the values in column order, which needs the full row image, and
+// UPDATE and DELETE rows are skipped.
+func (c *binlogConverter) renderRows(typ byte, rows *binlogRows, table *binlogTable) []string {
+	name := quoteIdentifier(table.Schema) + "." + quoteIdentifier(table.Name)
+	switch typ {
+	case binlogWriteRowsEvent, binlogWriteRowsEventV1:
+		var columns []string
+		for i, present := range rows.Present {
+			if !present {
+				continue
+			}
+			if table.Names != nil {
+				columns = append(columns, quoteIdentifier(table.Names[i]))
+			}
+		}
+		if table.Names == nil && !allTrue(rows.Present) {
+			c.noNameRows += len(rows.Rows)
+			return nil
+		}
+		values := make([]string, 0, len(rows.Rows))
+		for _, row := range rows.Rows {
+			values = append(values, "("+strings.Join(presentValues(row, rows.Present), ", ")+")")
+		}
+		sql := "INSERT INTO " + name
+		if columns != nil {
+			sql += " (" + strings.Join(columns, ", ") + ")"
+		}
+		return []string{sql + " VALUES " + strings.Join(values, ", ")}
+	}
+
+	if table.Names == nil {
+		if isUpdateRowsEvent(typ) {
+			c.noNameRows += len(rows.Rows) / 2
+		} else {
+			c.noNameRows += len(rows.Rows)
+		}
+		return nil
+	}
+	var statements []string
+	if isUpdateRowsEvent(typ) {
+		for i := 0; i+1 < len(rows.Rows); i += 2 {
+			before, after := rows.Rows[i], rows.Rows[i+1]
+			var set []string
+			for col, present := range rows.Present2 {
+				if present && (!rows.Present[col] || before[col] != after[col]) {
+					set = append(set, quoteIdentifier(table.Names[col])+" = "+after[col])
+				}
+			}
+			if set == nil {
+				continue
+			}
+			statements = append(statements, "UPDATE "+name+" SET "+strings.Join(set, ", ")+
+				binlogRowWhere(table, before, rows.Present))
+		}
+		return statements
+	}
+	for _, row := range rows.Rows {
+		statements = append(statements, "DELETE FROM "+name+binlogRowWhere(table, row, rows.Present))
+	}
+	return statements
+}
+
+// binlogRowWhere identifies a row by its primary key when the table map
+// has it, otherwise by the values of the before image and LIMIT 1.
+// Approximate types cannot be compared for equality and are left out.
+func binlogRowWhere(table *binlogTable, row []string, present []bool) string {
+	columns := table.PrimaryKey
+	limit := ""
+	for _, col := range columns {
+		if col >= len(present) || !present[col] {
+			columns = nil
+			break
+		}
+	}
+	if len(columns) == 0 {
+		limit = " LIMIT 1"
+		columns = nil
+		for col, p := range present {
+			switch table.Types[col] {
+			case mysqlTypeFloat, mysqlTypeDouble, mysqlTypeJSON, mysqlTypeGeometry:
+				continue
+			}
+			if p {
+				columns = append(columns, col)
+			}
+		}
+	}
+	conditions := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if row[col] == "NULL" {
+			conditions = append(conditions, quoteIdentifier(table.Names[col])+" IS NULL")
+		} else {
+			conditions = append(conditions, quoteIdentifier(table.Names[col])+" = "+row[col])
+		}
+	}
+	return " WHERE " + strings.Join(conditions, " AND ") + limit
+}
+
+func allTrue(values []bool) bool {
+	for _, v := range values {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
+
+func presentValues(row []string, present []bool) []string {
+	var values []string
+	for i, p := range present {
+		if p {
+			values = append(values, row[i])
+		}
+	}
+	return values
+}
+
+// listBinlogFiles returns the binary logs of a directory in name order, the
+// index file and other files are recognised by their missing magic number.
+func listBinlogFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		name := filepath.Join(path, dirEntry.Name())
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		magic := make([]byte, len(binlogMagic))
+		_, err = io.ReadFull(file, magic)
+		file.Close()
+		if err == nil && string(magic) == string(binlogMagic) {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ParseBinlog converts the DML of MySQL binary logs into replay entries. The
+// input is a binlog file or a directory of binlog files. Transactions are
+// written in commit order with their BEGIN and COMMIT, on the connection of
+// the thread that ran them.
+func ParseBinlog(binlogPath, outputPath string) {
+	if binlogPath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parsebinlog -slow-in <binlog_file_or_directory> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	files, err := listBinlogFiles(binlogPath)
+	if err != nil {
+		fmt.Println("Error reading binlog path:", err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Println("No binlog files found in", binlogPath)
+		return
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	var writeErr error
+	converter := newBinlogConverter(func(entry *LogEntry) error {
+		writeErr = writeLogEntry(writer, entry)
+		return writeErr
+	})
+	skipped := 0
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Println("Error opening binlog file:", err)
+			return
+		}
+		reader, err := newBinlogReader(file)
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", name, err)
+			file.Close()
+			continue
+		}
+		for {
+			ev, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fmt.Printf("Error reading %s: %v\n", name, err)
+				break
+			}
+			if err := converter.Process(ev); err != nil {
+				if writeErr == nil {
+					skipped++
+					continue
+				}
+				fmt.Println("Error writing output:", writeErr)
+				file.Close()
+				return
+			}
+		}
+		file.Close()
+	}
+
+	if converter.noNameRows > 0 {
+		fmt.Printf("Warning: %d rows skipped because the binlog has no column names, set binlog_row_metadata=FULL on the source\n", converter.noNameRows)
+	}
+	if converter.unsupported > 0 {
+		fmt.Printf("Warning: %d partial JSON update or compressed transaction events skipped\n", converter.unsupported)
+	}
+	fmt.Printf("binlog processed: %d statements in %d transactions written to output json, %d malformed events skipped\n", converter.statements, converter.txns, skipped)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// binlogTestEvent 按 v4 格式编码一个事件，末尾附加 CRC32 校验位（不校验内容）
+func binlogTestEvent(ts uint32, typ byte, body []byte) []byte {
+	header := make([]byte, binlogHeaderSize)
+	binary.LittleEndian.PutUint32(header, ts)
+	header[4] = typ
+	binary.LittleEndian.PutUint32(header[5:], 1)
+	binary.LittleEndian.PutUint32(header[9:], uint32(binlogHeaderSize+len(body)+binlogChecksumSize))
+	return append(append(header, body...), 0, 0, 0, 0)
+}
+
+func binlogTestFormatDescription() []byte {
+	body := make([]byte, 2+50+4+1)
+	binary.LittleEndian.PutUint16(body, 4)
+	copy(body[2:], "8.0.36-log")
+	body[56] = binlogHeaderSize
+	body = append(body, make([]byte, 40)...) // post-header lengths
+	body = append(body, 1)                   // CRC32
+	header := make([]byte, binlogHeaderSize)
+	header[4] = binlogFormatDescriptionEvent
+	binary.LittleEndian.PutUint32(header[9:], uint32(binlogHeaderSize+len(body)+binlogChecksumSize))
+	return append(append(header, body...), 0, 0, 0, 0)
+}
+
+func binlogTestQuery(ts, threadID uint32, db, sql string) []byte {
+	body := make([]byte, 13)
+	binary.LittleEndian.PutUint32(body, threadID)
+	body[8] = byte(len(db))
+	body = append(body, db...)
+	body = append(body, 0)
+	return binlogTestEvent(ts, binlogQueryEvent, append(body, sql...))
+}
+
+func binlogTestXid(ts uint32) []byte {
+	return binlogTestEvent(ts, binlogXidEvent, make([]byte, 8))
+}
+
+func binlogTestTableMap(ts uint32, id uint64, db, table string, types, meta, optional []byte) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint32(body, uint32(id))
+	body = append(body, byte(len(db)))
+	body = append(append(body, db...), 0)
+	body = append(body, byte(len(table)))
+	body = append(append(body, table...), 0)
+	body = append(body, byte(len(types)))
+	body = append(body, types...)
+	body = append(body, byte(len(meta)))
+	body = append(body, meta...)
+	body = append(body, make([]byte, (len(types)+7)/8)...)
+	return binlogTestEvent(ts, binlogTableMapEvent, append(body, optional...))
+}
+
+// binlogTestRows 编码 v2 行事件，所有列都在行镜像中，rows 为已编码的行（含 NULL 位图）
+func binlogTestRows(ts uint32, typ byte, id uint64, columns int, rows ...[]byte) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint32(body, uint32(id))
+	body[6] = binlogRowsStmtEnd
+	body = append(body, 2, 0, byte(columns))
+	bitmap := make([]byte, (columns+7)/8)
+	for i := 0; i < columns; i++ {
+		bitmap[i/8] |= 1 << (i % 8)
+	}
+	body = append(body, bitmap...)
+	if typ == binlogUpdateRowsEvent {
+		body = append(body, bitmap...)
+	}
+	for _, row := range rows {
+		body = append(body, row...)
+	}
+	return binlogTestEvent(ts, typ, body)
+}
+
+func binlogTestLong(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func binlogTestDatetime2(year, month, day, hour, minute, second int64) []byte {
+	ymd := (year*13+month)<<5 | day
+	hms := hour<<12 | minute<<6 | second
+	packed := uint64(ymd<<17|hms) + 0x8000000000
+	return []byte{byte(packed >> 32), byte(packed >> 24), byte(packed >> 16), byte(packed >> 8), byte(packed)}
+}
+
+func TestParseBinlog(t *testing.T) {
+	dir := t.TempDir()
+
+	// shop.t (id INT UNSIGNED PRIMARY KEY, name VARCHAR(10), price DECIMAL(10,2), created DATETIME, doc JSON)
+	// binlog_row_metadata=FULL：有符号位、列名和主键
+	types := []byte{mysqlTypeLong, mysqlTypeVarchar, mysqlTypeNewDecimal, mysqlTypeDatetime2, mysqlTypeJSON}
+	meta := []byte{40, 0, 10, 2, 0, 4}
+	optional := []byte{tableMetaSignedness, 1, 0x80, tableMetaColumnName, 26, 2, 'i', 'd', 4, 'n', 'a', 'm', 'e', 5, 'p', 'r', 'i', 'c', 'e',
+		7, 'c', 'r', 'e', 'a', 't', 'e', 'd', 3, 'd', 'o', 'c', tableMetaSimplePK, 1, 0}
+	tMap := binlogTestTableMap(1705652988, 1, "shop", "t", types, meta, optional)
+	// {"a": 1}
+	doc := []byte{13, 0, 0, 0, jsonSmallObject, 1, 0, 12, 0, 11, 0, 1, 0, jsonInt16, 1, 0, 'a'}
+	created := binlogTestDatetime2(2024, 1, 19, 16, 29, 48)
+	row := func(id uint32, name string, price []byte, nulls byte) []byte {
+		r := append([]byte{nulls}, binlogTestLong(id)...)
+		r = append(r, byte(len(name)))
+		r = append(r, name...)
+		r = append(r, price...)
+		r = append(r, created...)
+		if nulls&0x10 == 0 {
+			r = append(r, doc...)
+		}
+		return r
+	}
+	price := []byte{0x80, 0, 0, 12, 34}                   // 12.34
+	negative := []byte{0x7f, 0xff, 0xff, 0xfe, 0xff - 50} // -1.50
+
+	// shop.u (id INT, c VARCHAR(10))，没有列名
+	uMap := binlogTestTableMap(1705652990, 2, "shop", "u", []byte{mysqlTypeLong, mysqlTypeVarchar}, []byte{40, 0}, nil)
+	uRow := func(id uint32, c string) []byte {
+		return append(append([]byte{0}, binlogTestLong(id)...), append([]byte{byte(len(c))}, c...)...)
+	}
+
+	var first bytes.Buffer
+	first.Write(binlogMagic)
+	first.Write(binlogTestFormatDescription())
+	first.Write(binlogTestQuery(1705652988, 11, "shop", "BEGIN"))
+	first.Write(tMap)
+	first.Write(binlogTestRows(1705652988, binlogWriteRowsEvent, 1, 5, row(1, "a'b", price, 0), row(4000000000, "x", negative, 0x10)))
+	first.Write(tMap)
+	first.Write(binlogTestRows(1705652988, binlogUpdateRowsEvent, 1, 5, row(1, "a'b", price, 0), row(1, "c", price, 0)))
+	first.Write(tMap)
+	first.Write(binlogTestRows(1705652989, binlogDeleteRowsEvent, 1, 5, row(1, "c", price, 0x10)))
+	first.Write(binlogTestXid(1705652989))
+	// DDL 被跳过
+	first.Write(binlogTestQuery(1705652990, 12, "shop", "create table u (id int, c varchar(10))"))
+
+	var second bytes.Buffer
+	second.Write(binlogMagic)
+	second.Write(binlogTestFormatDescription())
+	second.Write(binlogTestQuery(1705652990, 12, "shop", "BEGIN"))
+	second.Write(uMap)
+	second.Write(binlogTestRows(1705652990, binlogWriteRowsEvent, 2, 2, uRow(1, "a")))
+	second.Write(uMap)
+	second.Write(binlogTestRows(1705652990, binlogUpdateRowsEvent, 2, 2, uRow(1, "a"), uRow(1, "b")))
+	second.Write(binlogTestXid(1705652990))
+	// 语句格式
+	second.Write(binlogTestQuery(1705652991, 13, "shop", "BEGIN"))
+	second.Write(binlogTestQuery(1705652991, 13, "shop", "update t set price = price * 2 where id = 1"))
+	second.Write(binlogTestQuery(1705652991, 13, "shop", "COMMIT"))
+	// Rows_query 事件中的原始语句代替行事件
+	second.Write(binlogTestQuery(1705652992, 14, "shop", "BEGIN"))
+	second.Write(binlogTestEvent(1705652992, binlogRowsQueryEvent, append([]byte{28}, "delete from t where id > 100"...)))
+	second.Write(tMap)
+	second.Write(binlogTestRows(1705652992, binlogDeleteRowsEvent, 1, 5, row(101, "z", price, 0x10)))
+	second.Write(binlogTestXid(1705652992))
+	// 只有 DDL 或被跳过的行的事务不输出
+	second.Write(binlogTestQuery(1705652993, 15, "shop", "BEGIN"))
+	second.Write(uMap)
+	second.Write(binlogTestRows(1705652993, binlogDeleteRowsEvent, 2, 2, uRow(1, "b")))
+	second.Write(binlogTestXid(1705652993))
+
+	if err := os.WriteFile(filepath.Join(dir, "binlog.000001"), first.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "binlog.000002"), second.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "binlog.index"), []byte("./binlog.000001\n./binlog.000002\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	outputPath := filepath.Join(dir, "output.json")
+	ParseBinlog(dir, outputPath)
+	actual := readLogEntriesForTest(t, outputPath)
+
+	expected := []LogEntry{
+		{ConnectionID: "11", DBName: "shop", SQL: "BEGIN", SQLType: "begin", Timestamp: 1705652988},
+		{ConnectionID: "11", DBName: "shop", SQL: "INSERT INTO `shop`.`t` (`id`, `name`, `price`, `created`, `doc`) VALUES " +
+			"(1, 'a\\'b', 12.34, '2024-01-19 16:29:48', '{\"a\": 1}'), (4000000000, 'x', -1.50, '2024-01-19 16:29:48', NULL)", SQLType: "insert", Timestamp: 1705652988},
+		{ConnectionID: "11", DBName: "shop", SQL: "UPDATE `shop`.`t` SET `name` = 'c' WHERE `id` = 1", SQLType: "update", Timestamp: 1705652988},
+		{ConnectionID: "11", DBName: "shop", SQL: "DELETE FROM `shop`.`t` WHERE `id` = 1", SQLType: "delete", Timestamp: 1705652989},
+		{ConnectionID: "11", DBName: "shop", SQL: "COMMIT", SQLType: "commit", Timestamp: 1705652989},
+		{ConnectionID: "12", DBName: "shop", SQL: "BEGIN", SQLType: "begin", Timestamp: 1705652990},
+		{ConnectionID: "12", DBName: "shop", SQL: "INSERT INTO `shop`.`u` VALUES (1, 'a')", SQLType: "insert", Timestamp: 1705652990},
+		{ConnectionID: "12", DBName: "shop", SQL: "COMMIT", SQLType: "commit", Timestamp: 1705652990},
+		{ConnectionID: "13", DBName: "shop", SQL: "BEGIN", SQLType: "begin", Timestamp: 1705652991},
+		{ConnectionID: "13", DBName: "shop", SQL: "update t set price = price * 2 where id = 1", SQLType: "update", Timestamp: 1705652991},
+		{ConnectionID: "13", DBName: "shop", SQL: "COMMIT", SQLType: "commit", Timestamp: 1705652991},
+		{ConnectionID: "14", DBName: "shop", SQL: "BEGIN", SQLType: "begin", Timestamp: 1705652992},
+		{ConnectionID: "14", DBName: "shop", SQL: "delete from t where id > 100", SQLType: "delete", Timestamp: 1705652992},
+		{ConnectionID: "14", DBName: "shop", SQL: "COMMIT", SQLType: "commit", Timestamp: 1705652992},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+}
+
+func TestDecodeBinlogTemporal(t *testing.T) {
+	// TIME(3) -00:00:01.500
+	packed := int64(1)<<24 + 500000
+	packed = -packed
+	intPart := packed >> 24
+	frac := (packed % (1 << 24)) / 100
+	b := uint64(intPart + 0x800000)
+	data := []byte{byte(b >> 16), byte(b >> 8), byte(b), byte(frac >> 8), byte(frac)}
+	if got := decodeTime2(data, 3); got != "-00:00:01.500" {
+		t.Errorf("decodeTime2 = %q", got)
+	}
+	if got, _, _ := decodeBinlogValue([]byte{0x12, 0x34, 0x56, 0x78}, mysqlTypeTimestamp2, 0, false); got != "FROM_UNIXTIME(305419896)" {
+		t.Errorf("TIMESTAMP2 = %q", got)
+	}
+	// CHAR(3) 的实际类型和长度保存在元数据中
+	if got, n, _ := decodeBinlogValue([]byte{2, 'a', 'b'}, mysqlTypeString, uint16(mysqlTypeString)<<8|3, false); got != "'ab'" || n != 3 {
+		t.Errorf("CHAR = %q, %d", got, n)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Connection lifecycle events carried in LogEntry.Event. Entries without an
+// event are statements.
+const (
+	EventConnect = "connect"
+	EventQuit    = "quit"
+)
+
+// ParseMySQLGeneralLogs converts a MySQL general query log (5.6, 5.7 and 8.0
+// formats) into replay entries, including connection open/close events.
+func ParseMySQLGeneralLogs(logPath, outputPath string) {
+	if logPath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parsemysqlgeneral -slow-in <path_to_general_log> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 512*1024*1024)
+
+	parser := newGeneralLogParser(func(entry *LogEntry) {
+		if err := writeLogEntry(writer, entry); err != nil {
+			fmt.Println("Error writing output:", err)
+		}
+	})
+	for scanner.Scan() {
+		parser.Line(scanner.Text())
+	}
+	parser.Flush()
+
+	if err := scanner.Err(); err != nil {
+		fmt.Println("Error reading file:", err)
+	}
+	fmt.Printf("General log processed: %d statements, %d connection events written to output json\n", parser.statements, parser.events)
+}
+
+var (
+	reGeneralTime57 = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2}))\s(.*)$`)
+	reGeneralTime56 = regexp.MustCompile(`^(\d{6}\s+\d{1,2}:\d{2}:\d{2})\t(.*)$`)
+	reGeneralCmd    = regexp.MustCompile(`^\s*(\d+) (Connect|Init DB|Query|Prepare|Execute|Quit|Close stmt|Reset stmt|Change user|Field List|Long Data|Ping|Statistics|Shutdown|Processlist|Kill|Refresh|Debug|Binlog Dump GTID|Binlog Dump|Table Dump|Register Slave|Fetch|Daemon|Error|Reset connection|Set option|Connect Out|Time|Delayed insert|Sleep|Clone)(?:\t(.*))?$`)
+	reGeneralHeader = regexp.MustCompile(`^(\S+, Version: .* started with:|Tcp port: \d+ .*|Time\s+Id Command\s+Argument)$`)
+	reGeneralLogin  = regexp.MustCompile(`^([^@\s]*)@(\S*) on (\S*)`)
+	reUseStatement  = regexp.MustCompile("(?i)^\\s*use\\s+`?([^`;\\s]+)`?\\s*;?\\s*$")
+)
+
+// generalSession is what the parser knows about one connection.
+type generalSession struct {
+	username string
+	dbName   string
+}
+
+// generalLogParser assembles general log lines into entries. Statements can
+// span several lines, so an entry is only emitted when the next command
+// line is seen.
+type generalLogParser struct {
+	emit       func(entry *LogEntry)
+	sessions   map[string]*generalSession
+	lastTime   float64
+	pending    *LogEntry
+	sqlBuffer  strings.Builder
+	statements int
+	events     int
+}
+
+func newGeneralLogParser(emit func(entry *LogEntry)) *generalLogParser {
+	return &generalLogParser{emit: emit, sessions: make(map[string]*generalSession)}
+}
+
+func (p *generalLogParser) session(connID string) *generalSession {
+	s := p.sessions[connID]
+	if s == nil {
+		s = &generalSession{}
+		p.sessions[connID] = s
+	}
+	return s
+}
+
+func (p *generalLogParser) Line(line string) {
+	rest, ts, ok := p.splitTime(line)
+	if !ok {
+		p.continuation(line)
+		return
+	}
+	match := reGeneralCmd.FindStringSubmatch(rest)
+	if match == nil {
+		p.continuation(line)
+		return
+	}
+	p.Flush()
+	p.lastTime = ts
+
+	connID, command, argument := match[1], match[2], match[3]
+	session := p.session(connID)
+	switch command {
+	case "Connect":
+		login := reGeneralLogin.FindStringSubmatch(argument)
+		if login == nil {
+			// "Access denied ..." and other failed logins
+			return
+		}
+		session.username = login[1]
+		session.dbName = login[3]
+		p.emitEvent(connID, EventConnect, session)
+	case "Change user":
+		if login := reGeneralLogin.FindStringSubmatch(argument); login != nil {
+			session.username = login[1]
+			session.dbName = login[3]
+		}
+	case "Init DB":
+		session.dbName = strings.TrimSpace(argument)
+	case "Quit":
+		p.emitEvent(connID, EventQuit, session)
+		delete(p.sessions, connID)
+	case "Query", "Execute":
+		p.pending = &LogEntry{
+			ConnectionID: connID,
+			Username:     session.username,
+			DBName:       session.dbName,
+			Timestamp:    ts,
+		}
+		p.sqlBuffer.WriteString(argument)
+	}
+}
+
+// splitTime strips the timestamp of a command line. 5.6 logs only print the
+// time when it changes, those lines start with two tabs instead.
+func (p *generalLogParser) splitTime(line string) (string, float64, bool) {
+	if match := reGeneralTime57.FindStringSubmatch(line); match != nil {
+		parsedTime, err := time.Parse(time.RFC3339Nano, match[1])
+		if err != nil {
+			return "", 0, false
+		}
+		return match[2], float64(parsedTime.UnixNano()) / 1e9, true
+	}
+	if match := reGeneralTime56.FindStringSubmatch(line); match != nil {
+		fields := strings.Fields(match[1])
+		parsedTime, err := time.Parse("060102 15:04:05", fields[0]+" "+fields[1])
+		if err != nil {
+			return "", 0, false
+		}
+		return match[2], float64(parsedTime.UnixNano()) / 1e9, true
+	}
+	if strings.HasPrefix(line, "\t\t") {
+		return line[2:], p.lastTime, true
+	}
+	return "", 0, false
+}
+
+func (p *generalLogParser) continuation(line string) {
+	if p.pending == nil || reGeneralHeader.MatchString(line) {
+		return
+	}
+	p.sqlBuffer.WriteString("\n")
+	p.sqlBuffer.WriteString(line)
+}
+
+func (p *generalLogParser) emitEvent(connID, event string, session *generalSession) {
+	p.emit(&LogEntry{
+		ConnectionID: connID,
+		Username:     session.username,
+		DBName:       session.dbName,
+		Timestamp:    p.lastTime,
+		Event:        event,
+	})
+	p.events++
+}
+
+// Flush emits the statement being assembled.
+func (p *generalLogParser) Flush() {
+	entry := p.pending
+	if entry == nil {
+		return
+	}
+	p.pending = nil
+	entry.SQL = strings.TrimSpace(p.sqlBuffer.String())
+	p.sqlBuffer.Reset()
+	if entry.SQL == "" {
+		return
+	}
+	// A text "use db" switches the schema of the following statements.
+	if match := reUseStatement.FindStringSubmatch(entry.SQL); match != nil {
+		p.session(entry.ConnectionID).dbName = match[1]
+	}
+	setDigestAndType(entry)
+	p.emit(entry)
+	p.statements++
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestParseMySQLGeneralLogs(t *testing.T) {
+	logPath := "test_general_log.txt"
+	outputPath := "test_general_output.json"
+	defer os.Remove(logPath)
+	defer os.Remove(outputPath)
+
+	// 5.6 格式：时间只在变化时输出；5.7/8.0 格式：每行都有时间
+	input := "/usr/sbin/mysqld, Version: 5.6.51-log (MySQL Community Server (GPL)). started with:\n" +
+		"Tcp port: 3306  Unix socket: /var/lib/mysql/mysql.sock\n" +
+		"Time                 Id Command    Argument\n" +
+		"240119 16:29:48\t    5 Connect\tt1@10.2.103.21 on db1\n" +
+		"\t\t    5 Query\tSELECT c FROM sbtest1 WHERE id=250438\n" +
+		"\t\t    6 Connect\tAccess denied for user 'x'@'10.2.103.21' (using password: YES)\n" +
+		"240119 16:29:49\t    5 Init DB\tdb2\n" +
+		"\t\t    5 Prepare\tSELECT * FROM t WHERE id=?\n" +
+		"\t\t    5 Execute\tSELECT * FROM t WHERE id=5\n" +
+		"\t\t    5 Query\tUPDATE t\n" +
+		"SET c = 'a\n" +
+		"b'\n" +
+		"WHERE id = 1\n" +
+		"\t\t    5 Quit\t\n" +
+		"2024-01-19T16:29:50.000100Z\t    7 Connect\tt2@localhost on  using Socket\n" +
+		"2024-01-19T16:29:50.000200Z\t    7 Query\tuse db3\n" +
+		"2024-01-19T16:29:50.000300Z\t    7 Query\tselect 1\n"
+
+	if err := os.WriteFile(logPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	ParseMySQLGeneralLogs(logPath, outputPath)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+
+	expected := []LogEntry{
+		{ConnectionID: "5", Username: "t1", DBName: "db1", Event: EventConnect, Timestamp: 1705681788},
+		{ConnectionID: "5", Username: "t1", DBName: "db1", SQL: "SELECT c FROM sbtest1 WHERE id=250438", SQLType: "select", Timestamp: 1705681788},
+		{ConnectionID: "5", Username: "t1", DBName: "db2", SQL: "SELECT * FROM t WHERE id=5", SQLType: "select", Timestamp: 1705681789},
+		{ConnectionID: "5", Username: "t1", DBName: "db2", SQL: "UPDATE t\nSET c = 'a\nb'\nWHERE id = 1", SQLType: "update", Timestamp: 1705681789},
+		{ConnectionID: "5", Username: "t1", DBName: "db2", Event: EventQuit, Timestamp: 1705681789},
+		{ConnectionID: "7", Username: "t2", DBName: "", Event: EventConnect, Timestamp: 1705681790.0001},
+		{ConnectionID: "7", Username: "t2", DBName: "", SQL: "use db3", SQLType: "use", Timestamp: 1705681790.0002},
+		{ConnectionID: "7", Username: "t2", DBName: "db3", SQL: "select 1", SQLType: "select", Timestamp: 1705681790.0003},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.Username != e.Username || a.DBName != e.DBName ||
+			a.SQL != e.SQL || a.SQLType != e.SQLType || a.Event != e.Event || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseHWAuditLogs(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "output.json")
+	auditDir := filepath.Join(dir, "audit")
+	if err := os.Mkdir(auditDir, 0755); err != nil {
+		t.Fatalf("Failed to create audit dir: %v", err)
+	}
+
+	// 文件按序号读取（2 在 10 之前），多行记录会跨越文件 2 与 10
+	files := map[string]string{
+		"1": `"21596830290", "93726418", "0", "Connect", "2024-09-04T00:57:26 UTC", "connect", "", "root[root] @  [10.3.2.22]", "", "", "10.3.2.22", "portal"` + "\n" +
+			`"21596830291", "93726419", "1045", "Connect", "2024-09-04T00:57:26 UTC", "connect", "", "bad[bad] @  [10.3.2.22]", "", "", "10.3.2.22", ""` + "\n" +
+			`"21596830293", "93726418", "0", "Query", "2024-09-04T00:57:27 UTC", "select", "SELECT pushcode FROM els WHERE els.pn = 'staff500' limit 0,1", "root[root] @  [10.3.2.22]", "", "", "10.3.2.22", "portal"` + "\n",
+		"2": `"21596830294", "93726418", "0", "Query", "2024-09-04T00:57:28 UTC", "update", "UPDATE t` + "\n" +
+			`SET c = 'x", "y'` + "\n",
+		"10": `WHERE id = 1", "root[root] @  [10.3.2.22]", "", "", "10.3.2.22", "portal"` + "\n" +
+			`"21596830295", "93726418", "1146", "Query", "2024-09-04T00:57:29 UTC", "select", "select * from missing", "root[root] @  [10.3.2.22]", "", "", "10.3.2.22", "portal"` + "\n" +
+			`"21596830296", "93726418", "0", "Quit", "2024-09-04T00:57:30 UTC", "", "", "root[root] @  [10.3.2.22]", "", "", "10.3.2.22", "portal"` + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(auditDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test input file: %v", err)
+		}
+	}
+
+	ParseHWAuditLogs(auditDir, outputPath)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+
+	expected := []LogEntry{
+		{ConnectionID: "93726418", Username: "root", DBName: "portal", Event: EventConnect, Timestamp: 1725411446},
+		{ConnectionID: "93726418", Username: "root", DBName: "portal", SQL: "SELECT pushcode FROM els WHERE els.pn = 'staff500' limit 0,1", SQLType: "select", Timestamp: 1725411447},
+		{ConnectionID: "93726418", Username: "root", DBName: "portal", SQL: "UPDATE t\nSET c = 'x\", \"y'\nWHERE id = 1", SQLType: "update", Timestamp: 1725411448},
+		{ConnectionID: "93726418", Username: "root", DBName: "portal", SQL: "select * from missing", SQLType: "select", Timestamp: 1725411449, ErrorCode: 1146},
+		{ConnectionID: "93726418", Username: "root", DBName: "portal", Event: EventQuit, Timestamp: 1725411450},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.Username != e.Username || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || a.Event != e.Event || a.ErrorCode != e.ErrorCode || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+		if e.Event == "" && a.Digest == "" {
+			t.Errorf("Missing digest at index %d", i)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ParsePcap decodes the MySQL traffic of a libpcap/pcapng capture and writes
+// every executed statement as a replay entry.
+func ParsePcap(pcapPath, outputPath string, serverPort int) {
+	if pcapPath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parsepcap -slow-in <path_to_pcap_file> -slow-out <path_to_slow_output_file> -mysql-port 3306")
+		return
+	}
+
+	file, err := os.Open(pcapPath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	reader, err := newPcapReader(file)
+	if err != nil {
+		fmt.Println("Error reading capture:", err)
+		return
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	decoder := newPcapDecoder(uint16(serverPort), writer)
+	for {
+		packet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("Error reading capture:", err)
+			break
+		}
+		decoder.Handle(packet)
+	}
+	decoder.Close()
+
+	fmt.Printf("Capture processed: %d connections, %d statements and %d connection events written to output json\n", decoder.connections, decoder.statements, decoder.events)
+}
+
+// pcapDecoder follows the MySQL connections found in a capture.
+type pcapDecoder struct {
+	serverPort  uint16
+	out         io.Writer
+	streams     map[string]*mysqlStream
+	connections int
+	statements  int
+	events      int
+}
+
+func newPcapDecoder(serverPort uint16, out io.Writer) *pcapDecoder {
+	return &pcapDecoder{serverPort: serverPort, out: out, streams: make(map[string]*mysqlStream)}
+}
+
+func (d *pcapDecoder) Handle(packet capturedPacket) {
+	seg, ok := decodeTCP(packet.LinkType, packet.Data)
+	if !ok {
+		return
+	}
+	var fromClient bool
+	var clientIP string
+	var clientPort uint16
+	switch {
+	case seg.DstPort == d.serverPort:
+		fromClient, clientIP, clientPort = true, seg.SrcIP.String(), seg.SrcPort
+	case seg.SrcPort == d.serverPort:
+		fromClient, clientIP, clientPort = false, seg.DstIP.String(), seg.DstPort
+	default:
+		return
+	}
+	key := clientIP + ":" + strconv.Itoa(int(clientPort))
+
+	stream := d.streams[key]
+	if stream != nil && seg.SYN && fromClient && stream.phase != phaseHandshake {
+		// port reuse: a new connection from the same client port
+		stream.Disconnect(packet.Timestamp)
+		stream = nil
+	}
+	if stream == nil {
+		// Segments of unknown connections, such as the last ACKs after a
+		// FIN, start a stream only when they open one or carry a command.
+		if seg.FIN || seg.RST || !fromClient || (!seg.SYN && !startsCommand(seg.Payload)) {
+			return
+		}
+		stream = newMySQLStream(fmt.Sprintf("%s_%d", clientIP, clientPort), d.emit)
+		d.streams[key] = stream
+		d.connections++
+	}
+	// Closed streams (quit, TLS, failed login) stay until the TCP
+	// connection ends so their remaining bytes are not decoded again.
+	if !stream.closed {
+		stream.Handle(seg, fromClient, packet.Timestamp)
+	}
+	if seg.FIN || seg.RST {
+		stream.Disconnect(packet.Timestamp)
+		delete(d.streams, key)
+	}
+}
+
+// Close flushes the statements still waiting for a response at the end of
+// the capture.
+func (d *pcapDecoder) Close() {
+	for key, stream := range d.streams {
+		stream.Close()
+		delete(d.streams, key)
+	}
+}
+
+func (d *pcapDecoder) emit(entry *LogEntry) {
+	if entry.Event == "" {
+		setDigestAndType(entry)
+	}
+	if err := writeLogEntry(d.out, entry); err != nil {
+		fmt.Println("Error writing output:", err)
+		return
+	}
+	if entry.Event == "" {
+		d.statements++
+	} else {
+		d.events++
+	}
+}
+
+const (
+	phaseHandshake = iota // waiting for the server greeting
+	phaseAuth             // handshake response sent, waiting for the auth result
+	phaseCommand          // command phase
+)
+
+// pendingCommand is a client command waiting for the end of its response.
+type pendingCommand struct {
+	command   byte
+	payload   []byte
+	dbName    string
+	start     time.Time
+	end       time.Time
+	responses [][]byte
+}
+
+// mysqlStream decodes one captured client connection.
+type mysqlStream struct {
+	emit         func(entry *LogEntry)
+	connID       string
+	username     string
+	dbName       string
+	caps         uint32
+	phase        int
+	synced       bool
+	serverSynced bool
+	closed       bool
+	loggedIn     bool // the session is open, its quit is still to be emitted
+
+	client, server               tcpReassembler
+	clientPackets, serverPackets mysqlPacketReader
+
+	stmts   map[uint32]*preparedStmt
+	pending *pendingCommand
+}
+
+func newMySQLStream(fallbackID string, emit func(entry *LogEntry)) *mysqlStream {
+	return &mysqlStream{
+		emit:   emit,
+		connID: fallbackID,
+		phase:  phaseCommand,
+		stmts:  make(map[uint32]*preparedStmt),
+	}
+}
+
+// expectHandshake prepares the stream for a connection seen from its start:
+// the greeting tells the real connection id.
+func (s *mysqlStream) expectHandshake() {
+	s.phase = phaseHandshake
+	s.synced = true
+	s.serverSynced = true
+}
+
+func (s *mysqlStream) Handle(seg tcpSegment, fromClient bool, ts time.Time) {
+	if seg.SYN && fromClient {
+		s.expectHandshake()
+	}
+	if fromClient {
+		s.feed(&s.client, &s.clientPackets, seg, ts, true)
+	} else {
+		s.feed(&s.server, &s.serverPackets, seg, ts, false)
+	}
+}
+
+func (s *mysqlStream) feed(r *tcpReassembler, packets *mysqlPacketReader, seg tcpSegment, ts time.Time, fromClient bool) {
+	data := r.Add(seg)
+	if r.Gap() {
+		// The capture dropped data, restart at the next packet boundary.
+		data = r.Skip()
+		packets.Reset()
+		s.synced, s.serverSynced = false, false
+	}
+	s.Write(data, fromClient, ts)
+}
+
+// Write decodes bytes that arrived in order on one direction of the
+// connection.
+func (s *mysqlStream) Write(data []byte, fromClient bool, ts time.Time) {
+	if len(data) == 0 || s.closed {
+		return
+	}
+	if !s.resync(data, fromClient) {
+		return
+	}
+	packets := &s.serverPackets
+	if fromClient {
+		packets = &s.clientPackets
+	}
+	packets.Write(data)
+	for {
+		packet, ok := packets.Next()
+		if !ok {
+			return
+		}
+		if fromClient {
+			s.clientPacket(packet, ts)
+		} else {
+			s.serverPacket(packet, ts)
+		}
+		if s.closed {
+			return
+		}
+	}
+}
+
+// resync decides whether data can be decoded. Connections that were already
+// open when the capture started are joined at the first segment that looks
+// like the start of a command (client) or of its response (server).
+func (s *mysqlStream) resync(data []byte, fromClient bool) bool {
+	if fromClient {
+		if s.synced {
+			return true
+		}
+		if !startsCommand(data) {
+			return false
+		}
+		s.synced = true
+		s.serverSynced = false
+		return true
+	}
+	if s.serverSynced {
+		return true
+	}
+	if s.pending == nil || len(data) < 4 || data[3] != 1 {
+		return false
+	}
+	s.serverSynced = true
+	return true
+}
+
+// startsCommand tells whether data looks like the first packet of a client
+// command.
+func startsCommand(data []byte) bool {
+	if len(data) < 5 || data[3] != 0 {
+		return false
+	}
+	length := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	return length > 0 && length <= len(data)-4 && isKnownCommand(data[4])
+}
+
+func isKnownCommand(command byte) bool {
+	switch command {
+	case comQuit, comInitDB, comQuery, comChangeUser, comStmtPrepare, comStmtExecute,
+		comStmtSendLongData, comStmtClose, comStmtReset, comResetConnection,
+		0x0e: // COM_PING
+		return true
+	}
+	return false
+}
+
+func (s *mysqlStream) serverPacket(packet mysqlPacket, ts time.Time) {
+	switch s.phase {
+	case phaseHandshake:
+		if hs, ok := parseServerHandshake(packet.Payload); ok {
+			s.connID = strconv.FormatUint(uint64(hs.ConnectionID), 10)
+		}
+		s.phase = phaseAuth
+	case phaseAuth:
+		if len(packet.Payload) == 0 {
+			return
+		}
+		switch packet.Payload[0] {
+		case 0x00:
+			s.phase = phaseCommand
+			s.event(EventConnect, ts)
+		case 0xff:
+			s.closed = true
+		}
+	default:
+		if s.pending != nil {
+			s.pending.responses = append(s.pending.responses, packet.Payload)
+			s.pending.end = ts
+		}
+	}
+}
+
+func (s *mysqlStream) clientPacket(packet mysqlPacket, ts time.Time) {
+	if s.phase == phaseAuth {
+		if packet.Seq == 1 {
+			resp, ok := parseHandshakeResponse(packet.Payload)
+			if !ok {
+				return
+			}
+			if resp.SSLRequest {
+				// TLS traffic cannot be decoded.
+				s.closed = true
+				return
+			}
+			s.caps = resp.Capabilities
+			s.username = resp.Username
+			s.dbName = resp.DBName
+		}
+		return
+	}
+	if s.phase != phaseCommand || packet.Seq != 0 || len(packet.Payload) == 0 {
+		return
+	}
+
+	s.finishPending()
+	// joined connections are open too
+	s.loggedIn = true
+	command := packet.Payload[0]
+	data := packet.Payload[1:]
+	switch command {
+	case comQuit:
+		s.event(EventQuit, ts)
+		s.closed = true
+		return
+	case comStmtSendLongData:
+		if len(data) >= 6 {
+			if stmt := s.stmts[binary.LittleEndian.Uint32(data)]; stmt != nil {
+				if stmt.LongData == nil {
+					stmt.LongData = make(map[int][]byte)
+				}
+				id := int(binary.LittleEndian.Uint16(data[4:]))
+				stmt.LongData[id] = append(stmt.LongData[id], data[6:]...)
+			}
+		}
+		return
+	case comStmtClose:
+		if len(data) >= 4 {
+			delete(s.stmts, binary.LittleEndian.Uint32(data))
+		}
+		return
+	}
+	s.pending = &pendingCommand{
+		command: command,
+		payload: packet.Payload,
+		dbName:  s.dbName,
+		start:   ts,
+		end:     ts,
+	}
+}
+
+// finishPending decodes the response of the pending command and emits it.
+func (s *mysqlStream) finishPending() {
+	p := s.pending
+	if p == nil {
+		return
+	}
+	s.pending = nil
+	result := summarizeResponse(p.command, p.responses)
+	data := p.payload[1:]
+
+	var sql, stmtSQL string
+	var args []StmtArg
+	switch p.command {
+	case comQuery:
+		sql = string(s.stripQueryAttributes(data))
+	case comInitDB:
+		if result.ErrorCode == 0 {
+			s.dbName = string(data)
+		}
+		return
+	case comChangeUser:
+		if result.ErrorCode == 0 {
+			s.username, s.dbName = parseChangeUser(data, s.caps)
+			s.stmts = make(map[uint32]*preparedStmt)
+		}
+		return
+	case comResetConnection:
+		s.stmts = make(map[uint32]*preparedStmt)
+		return
+	case comStmtPrepare:
+		if result.ErrorCode == 0 && len(p.responses) > 0 {
+			s.stmts[result.StmtID] = &preparedStmt{SQL: string(data), NumParams: result.NumParams}
+		}
+		return
+	case comStmtReset:
+		if len(data) >= 4 {
+			if stmt := s.stmts[binary.LittleEndian.Uint32(data)]; stmt != nil {
+				stmt.LongData = nil
+			}
+		}
+		return
+	case comStmtExecute:
+		if len(data) < 4 {
+			return
+		}
+		stmt := s.stmts[binary.LittleEndian.Uint32(data)]
+		if stmt == nil {
+			// prepared before the capture started
+			return
+		}
+		params, err := parseStmtExecute(data, stmt)
+		stmt.LongData = nil
+		if err != nil {
+			fmt.Printf("Error decoding COM_STMT_EXECUTE on connection %s: %v\n", s.connID, err)
+			return
+		}
+		sql = renderStmtSQL(stmt.SQL, params)
+		stmtSQL, args = stmt.SQL, stmtArgs(params)
+	default:
+		return
+	}
+	if sql == "" {
+		return
+	}
+
+	entry := LogEntry{
+		ConnectionID: s.connID,
+		QueryTime:    usecBetween(p.start, p.end),
+		SQL:          sql,
+		RowsSent:     result.RowsSent,
+		Username:     s.username,
+		DBName:       p.dbName,
+		Timestamp:    float64(p.start.UnixNano()) / 1e9,
+		ErrorCode:    result.ErrorCode,
+		StmtSQL:      stmtSQL,
+		StmtArgs:     args,
+	}
+	s.emit(&entry)
+}
+
+// stripQueryAttributes removes the query attributes that prefix COM_QUERY
+// when CLIENT_QUERY_ATTRIBUTES was negotiated.
+func (s *mysqlStream) stripQueryAttributes(data []byte) []byte {
+	if s.caps&clientQueryAttributes == 0 {
+		return data
+	}
+	count, n, ok := readLenencInt(data)
+	// every attribute takes at least one byte, a larger count is a
+	// malformed or mis-synced packet
+	if !ok || count > uint64(len(data)) {
+		return data
+	}
+	pos := n
+	_, n, ok = readLenencInt(data[pos:])
+	if !ok {
+		return data
+	}
+	pos += n
+	if count == 0 {
+		return data[pos:]
+	}
+	// null bitmap, new params bound flag, then type and name of every
+	// attribute followed by the values
+	nullBitmap := data[pos:]
+	pos += int(count+7) / 8
+	if pos >= len(data) || data[pos] != 1 {
+		return data
+	}
+	pos++
+	types := make([]byte, 0, 2*count)
+	for i := uint64(0); i < count; i++ {
+		if pos+2 > len(data) {
+			return data
+		}
+		types = append(types, data[pos], data[pos+1])
+		pos += 2
+		_, n, ok := readLenencString(data[pos:])
+		if !ok {
+			return data
+		}
+		pos += n
+	}
+	for i := uint64(0); i < count; i++ {
+		if nullBitmap[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+		param := stmtParam{Type: types[2*i], Unsigned: types[2*i+1]&0x80 != 0}
+		n, err := decodeBinaryValue(data[pos:], &param)
+		if err != nil {
+			return data
+		}
+		pos += n
+	}
+	return data[pos:]
+}
+
+// Close emits the statement still waiting for its response.
+func (s *mysqlStream) Close() {
+	s.finishPending()
+	s.closed = true
+}
+
+// Disconnect closes the stream when its TCP connection ended. The session
+// quits there if the client did not send COM_QUIT.
+func (s *mysqlStream) Disconnect(ts time.Time) {
+	s.Close()
+	if s.loggedIn {
+		s.event(EventQuit, ts)
+	}
+}
+
+// event emits a connect or quit event of the session.
+func (s *mysqlStream) event(event string, ts time.Time) {
+	s.loggedIn = event == EventConnect
+	s.emit(&LogEntry{
+		ConnectionID: s.connID,
+		Username:     s.username,
+		DBName:       s.dbName,
+		Timestamp:    float64(ts.UnixNano()) / 1e9,
+		Event:        event,
+	})
+}
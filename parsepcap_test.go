@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// pcapBuilder 用于在测试中构造 libpcap 抓包文件
+type pcapBuilder struct {
+	buf bytes.Buffer
+	ts  time.Time
+}
+
+func newPcapBuilder(start time.Time) *pcapBuilder {
+	b := &pcapBuilder{ts: start}
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr, pcapMagicMicro)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeEthernet)
+	b.buf.Write(hdr)
+	return b
+}
+
+// tcp 追加一个以太网/IPv4/TCP 帧，时间前进 elapsed
+func (b *pcapBuilder) tcp(elapsed time.Duration, src, dst string, srcPort, dstPort uint16, seq uint32, flags byte, payload []byte) {
+	b.ts = b.ts.Add(elapsed)
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp, srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)+len(payload)))
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], net.ParseIP(src).To4())
+	copy(ip[16:], net.ParseIP(dst).To4())
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	frame = append(frame, ip...)
+	frame = append(frame, tcp...)
+	frame = append(frame, payload...)
+
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec, uint32(b.ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(b.ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(frame)))
+	b.buf.Write(rec)
+	b.buf.Write(frame)
+}
+
+func mysqlPacketBytes(seq byte, payload []byte) []byte {
+	p := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}
+	return append(p, payload...)
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func TestParsePcap(t *testing.T) {
+	pcapPath := "test_capture.pcap"
+	outputPath := "test_capture.json"
+	defer os.Remove(pcapPath)
+	defer os.Remove(outputPath)
+
+	const (
+		client = "10.0.0.8"
+		server = "10.0.0.1"
+		syn    = 0x02
+		ack    = 0x10
+		psh    = 0x18
+		fin    = 0x11
+	)
+	start := time.Date(2024, 9, 4, 0, 57, 27, 0, time.UTC)
+	b := newPcapBuilder(start)
+
+	// 三次握手与 MySQL 认证
+	cseq, sseq := uint32(1000), uint32(5000)
+	b.tcp(0, client, server, 50000, 3306, cseq, syn, nil)
+	b.tcp(0, server, client, 3306, 50000, sseq, syn|ack, nil)
+	cseq++
+	sseq++
+	greeting := []byte{10}
+	greeting = append(greeting, []byte("8.0.36\x00")...)
+	greeting = append(greeting, 42, 0, 0, 0)
+	greeting = append(greeting, []byte("abcdefgh\x00")...)
+	greeting = append(greeting, 0xff, 0xff, 0x21, 0x02, 0x00, 0xff, 0x01)
+	out := mysqlPacketBytes(0, greeting)
+	b.tcp(time.Millisecond, server, client, 3306, 50000, sseq, psh, out)
+	sseq += uint32(len(out))
+
+	caps := uint32(clientProtocol41 | clientSecureConnection | clientConnectWithDB | clientPluginAuth)
+	resp := make([]byte, 32)
+	binary.LittleEndian.PutUint32(resp, caps)
+	resp = append(resp, []byte("app\x00")...)
+	resp = append(resp, 2, 0xaa, 0xbb)
+	resp = append(resp, []byte("shop\x00mysql_native_password\x00")...)
+	out = mysqlPacketBytes(1, resp)
+	b.tcp(time.Millisecond, client, server, 50000, 3306, cseq, psh, out)
+	cseq += uint32(len(out))
+	out = mysqlPacketBytes(2, []byte{0, 0, 0, 2, 0, 0, 0})
+	b.tcp(time.Millisecond, server, client, 3306, 50000, sseq, psh, out)
+	sseq += uint32(len(out))
+
+	// COM_QUERY，结果集的两个 TCP 段乱序到达
+	out = mysqlPacketBytes(0, append([]byte{comQuery}, "SELECT * FROM t"...))
+	b.tcp(time.Second, client, server, 50000, 3306, cseq, psh, out)
+	cseq += uint32(len(out))
+	part1 := concatBytes(
+		mysqlPacketBytes(1, []byte{1}),
+		mysqlPacketBytes(2, []byte("\x03def\x00\x01t\x01t\x01c\x01c\x0c\x21\x00\x0b\x00\x00\x00\x03\x00\x00\x00\x00\x00")),
+		mysqlPacketBytes(3, []byte{0xfe, 0, 0, 2, 0}),
+	)
+	part2 := concatBytes(
+		mysqlPacketBytes(4, []byte("\x011")),
+		mysqlPacketBytes(5, []byte("\x012")),
+		mysqlPacketBytes(6, []byte{0xfe, 0, 0, 2, 0}),
+	)
+	b.tcp(2*time.Millisecond, server, client, 3306, 50000, sseq+uint32(len(part1)), psh, part2)
+	b.tcp(time.Millisecond, server, client, 3306, 50000, sseq, psh, part1)
+	sseq += uint32(len(part1) + len(part2))
+
+	// COM_INIT_DB 切换数据库
+	out = mysqlPacketBytes(0, append([]byte{comInitDB}, "other"...))
+	b.tcp(time.Second, client, server, 50000, 3306, cseq, psh, out)
+	cseq += uint32(len(out))
+	out = mysqlPacketBytes(1, []byte{0, 0, 0, 2, 0, 0, 0})
+	b.tcp(time.Millisecond, server, client, 3306, 50000, sseq, psh, out)
+	sseq += uint32(len(out))
+
+	// COM_STMT_PREPARE + COM_STMT_EXECUTE，执行返回错误
+	out = mysqlPacketBytes(0, append([]byte{comStmtPrepare}, "SELECT * FROM t WHERE id = ? AND name = ?"...))
+	b.tcp(time.Second, client, server, 50000, 3306, cseq, psh, out)
+	cseq += uint32(len(out))
+	out = mysqlPacketBytes(1, []byte{0, 1, 0, 0, 0, 1, 0, 2, 0, 0, 0, 0})
+	b.tcp(time.Millisecond, server, client, 3306, 50000, sseq, psh, out)
+	sseq += uint32(len(out))
+
+	exec := []byte{comStmtExecute, 1, 0, 0, 0, 0, 1, 0, 0, 0}
+	exec = append(exec, 0x00, 1, 0x08, 0x00, 0xfd, 0x00)
+	exec = append(exec, 7, 0, 0, 0, 0, 0, 0, 0)
+	exec = append(exec, 3, 'o', '\'', 'k')
+	out = mysqlPacketBytes(0, exec)
+	b.tcp(time.Second, client, server, 50000, 3306, cseq, psh, out)
+	cseq += uint32(len(out))
+	out = mysqlPacketBytes(1, append([]byte{0xff, 0x7a, 0x04}, "#42S02Table 'other.t' doesn't exist"...))
+	b.tcp(3*time.Millisecond, server, client, 3306, 50000, sseq, psh, out)
+	sseq += uint32(len(out))
+
+	out = mysqlPacketBytes(0, []byte{comQuit})
+	b.tcp(time.Second, client, server, 50000, 3306, cseq, psh, out)
+	cseq += uint32(len(out))
+	b.tcp(0, client, server, 50000, 3306, cseq, fin, nil)
+
+	// 抓包开始前已建立的连接：没有握手，从第一条命令开始解析
+	out = mysqlPacketBytes(0, append([]byte{comQuery}, "UPDATE t SET a=1"...))
+	b.tcp(time.Second, "10.0.0.9", server, 40000, 3306, 777, psh, out)
+	b.tcp(4*time.Millisecond, server, "10.0.0.9", 3306, 40000, 888, psh, mysqlPacketBytes(1, []byte{0, 1, 0, 2, 0, 0, 0}))
+
+	if err := os.WriteFile(pcapPath, b.buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write capture: %v", err)
+	}
+
+	ParsePcap(pcapPath, outputPath, 3306)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+
+	expected := []LogEntry{
+		{ConnectionID: "42", Username: "app", DBName: "shop", Event: EventConnect},
+		{ConnectionID: "42", QueryTime: 3000, SQL: "SELECT * FROM t", RowsSent: 2, Username: "app", DBName: "shop", SQLType: "select"},
+		{ConnectionID: "42", QueryTime: 3000, SQL: `SELECT * FROM t WHERE id = 7 AND name = 'o\'k'`, Username: "app", DBName: "other", SQLType: "select", ErrorCode: 1146},
+		{ConnectionID: "42", Username: "app", DBName: "other", Event: EventQuit},
+		{ConnectionID: "10.0.0.9_40000", QueryTime: 4000, SQL: "UPDATE t SET a=1", SQLType: "update"},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %v\nExpected: %v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.QueryTime != e.QueryTime || a.SQL != e.SQL ||
+			a.RowsSent != e.RowsSent || a.Username != e.Username || a.DBName != e.DBName ||
+			a.SQLType != e.SQLType || a.ErrorCode != e.ErrorCode || a.Event != e.Event || (a.Digest == "") != (e.Event != "") {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+	if !floatEquals(actual[1].Timestamp, float64(start.Add(1003*time.Millisecond).UnixNano())/1e9) {
+		t.Errorf("Unexpected timestamp %f", actual[1].Timestamp)
+	}
+	// 登录成功与 COM_QUIT 的时间
+	if actual[0].Timestamp >= actual[1].Timestamp || actual[3].Timestamp <= actual[2].Timestamp {
+		t.Errorf("Unexpected event timestamps %f, %f", actual[0].Timestamp, actual[3].Timestamp)
+	}
+}
+
+func TestStripQueryAttributes(t *testing.T) {
+	s := &mysqlStream{caps: clientQueryAttributes}
+	// 属性个数超出包长度时保持原样，不能 panic
+	malformed := []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x01, 's', 'e', 'l'}
+	if got := s.stripQueryAttributes(malformed); !bytes.Equal(got, malformed) {
+		t.Errorf("Expected the malformed payload unchanged, got %q", got)
+	}
+	if got := s.stripQueryAttributes([]byte("\x00\x01select 1")); string(got) != "select 1" {
+		t.Errorf("Expected the attributes removed, got %q", got)
+	}
+}
+
+func TestSummarizeResponseColumnCount(t *testing.T) {
+	// 列数超出剩余包数时返回已解析的结果，不能 panic
+	malformed := [][]byte{{0xfe, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, {0x01, 'a'}}
+	if result := summarizeResponse(comQuery, malformed); result.RowsSent != 0 || result.ErrorCode != 0 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	// 一列一行，列定义后的 EOF 与结尾的 EOF
+	eof := []byte{0xfe, 0x00, 0x00, 0x02, 0x00}
+	packets := [][]byte{{0x01}, {0x03, 'd', 'e', 'f'}, eof, {0x01, 'a'}, eof}
+	if result := summarizeResponse(comQuery, packets); result.RowsSent != 1 {
+		t.Errorf("Expected 1 row, got %+v", result)
+	}
+}
+
+func TestPcapDecoderConnections(t *testing.T) {
+	const (
+		server = "10.0.0.1"
+		ack    = 0x10
+		psh    = 0x18
+	)
+	b := newPcapBuilder(time.Date(2024, 9, 4, 0, 57, 27, 0, time.UTC))
+	// 已结束连接的最后一个 ACK、连接中途的响应与非命令开头的数据段都不创建连接
+	b.tcp(0, "10.0.0.8", server, 50000, 3306, 1000, ack, nil)
+	b.tcp(time.Millisecond, server, "10.0.0.9", 3306, 40000, 888, psh, mysqlPacketBytes(1, []byte{0, 0, 0, 2, 0, 0, 0}))
+	b.tcp(time.Millisecond, "10.0.0.10", server, 40001, 3306, 555, psh, []byte("rest of a long query"))
+	// 连接中途的命令开始解析
+	out := mysqlPacketBytes(0, append([]byte{comQuery}, "UPDATE t SET a=1"...))
+	b.tcp(time.Second, "10.0.0.9", server, 40000, 3306, 777, psh, out)
+
+	reader, err := newPcapReader(bytes.NewReader(b.buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to read capture: %v", err)
+	}
+	var output bytes.Buffer
+	decoder := newPcapDecoder(3306, &output)
+	for {
+		packet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read capture: %v", err)
+		}
+		decoder.Handle(packet)
+	}
+	decoder.Close()
+	if decoder.connections != 1 || decoder.statements != 1 {
+		t.Errorf("Expected 1 connection and 1 statement, got %d and %d", decoder.connections, decoder.statements)
+	}
+}
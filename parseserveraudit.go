@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// server_audit records (MariaDB plugin and Aurora MySQL advanced auditing):
+// timestamp,serverhost,username,host,connectionid,queryid,operation,database,object,retcode
+const (
+	auditFieldTime = iota
+	auditFieldServerHost
+	auditFieldUser
+	auditFieldHost
+	auditFieldConnID
+	auditFieldQueryID
+	auditFieldOperation
+	auditFieldDatabase
+	auditFieldObject
+	auditFieldRetcode
+	auditFieldCount
+)
+
+// ParseServerAuditLogs converts MariaDB server_audit and Aurora MySQL audit
+// logs into replay entries, including connection open/close events.
+func ParseServerAuditLogs(logPath, outputPath string) {
+	if logPath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parseserveraudit -slow-in <path_to_audit_log> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 512*1024*1024)
+
+	var statements, events, skipped int
+	var record strings.Builder
+	handle := func(fields []string) {
+		entry, err := serverAuditEntry(fields)
+		if err != nil {
+			skipped++
+			return
+		}
+		if entry == nil {
+			return
+		}
+		if entry.Event == "" {
+			setDigestAndType(entry)
+			statements++
+		} else {
+			events++
+		}
+		if err := writeLogEntry(writer, entry); err != nil {
+			fmt.Println("Error writing output:", err)
+		}
+	}
+
+	for scanner.Scan() {
+		if record.Len() > 0 {
+			// A quoted object was still open, the line belongs to it.
+			record.WriteString("\n")
+		}
+		record.WriteString(scanner.Text())
+		if fields, complete := splitServerAuditRecord(record.String()); complete {
+			handle(fields)
+			record.Reset()
+		}
+	}
+	if record.Len() > 0 {
+		skipped++
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Println("Error reading file:", err)
+	}
+	fmt.Printf("Audit log processed: %d statements, %d connection events, %d malformed records skipped\n", statements, events, skipped)
+}
+
+// splitServerAuditRecord splits a record into its fields. The object field
+// is quoted with ' and may contain commas, escaped quotes and newlines; the
+// record is incomplete while that quote is still open.
+func splitServerAuditRecord(record string) ([]string, bool) {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(record); i++ {
+		c := record[i]
+		switch {
+		case c == ',':
+			fields = append(fields, field.String())
+			field.Reset()
+		case c == '\'' && field.Len() == 0 && len(fields) == auditFieldObject:
+			end, value, ok := readAuditQuoted(record, i+1)
+			if !ok {
+				return nil, false
+			}
+			field.WriteString(value)
+			i = end
+		default:
+			field.WriteByte(c)
+		}
+	}
+	fields = append(fields, field.String())
+	return fields, true
+}
+
+// readAuditQuoted reads a quoted object starting after its opening quote and
+// returns the position of the closing quote and the unescaped value.
+func readAuditQuoted(record string, start int) (int, string, bool) {
+	var value strings.Builder
+	for i := start; i < len(record); i++ {
+		c := record[i]
+		switch c {
+		case '\\':
+			if i+1 >= len(record) {
+				return 0, "", false
+			}
+			i++
+			switch record[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(record[i])
+			}
+		case '\'':
+			return i, value.String(), true
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return 0, "", false
+}
+
+// parseServerAuditTime reads the MariaDB "20240119 16:29:48" local time or
+// the Aurora epoch microseconds.
+func parseServerAuditTime(value string) (float64, error) {
+	if usec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return float64(usec) / 1e6, nil
+	}
+	t, err := time.ParseInLocation("20060102 15:04:05", value, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return float64(t.UnixNano()) / 1e9, nil
+}
+
+// serverAuditEntry turns a record into an entry, or nil for operations that
+// are not replayed (table access, failed logins).
+func serverAuditEntry(fields []string) (*LogEntry, error) {
+	if len(fields) < auditFieldCount {
+		return nil, fmt.Errorf("expected %d fields, got %d", auditFieldCount, len(fields))
+	}
+	ts, err := parseServerAuditTime(fields[auditFieldTime])
+	if err != nil {
+		return nil, err
+	}
+	retcode, err := strconv.Atoi(strings.TrimSpace(fields[auditFieldRetcode]))
+	if err != nil {
+		return nil, err
+	}
+	entry := &LogEntry{
+		ConnectionID: fields[auditFieldConnID],
+		Username:     fields[auditFieldUser],
+		DBName:       fields[auditFieldDatabase],
+		Timestamp:    ts,
+		ErrorCode:    retcode,
+	}
+	operation := strings.ToUpper(fields[auditFieldOperation])
+	switch {
+	case operation == "CONNECT":
+		if retcode != 0 {
+			return nil, nil
+		}
+		entry.Event = EventConnect
+	case operation == "DISCONNECT":
+		entry.Event = EventQuit
+	case strings.HasPrefix(operation, "QUERY"):
+		entry.SQL = strings.TrimSpace(fields[auditFieldObject])
+		if entry.SQL == "" {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	return entry, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseServerAuditLogs(t *testing.T) {
+	logPath := "test_server_audit.log"
+	outputPath := "test_server_audit_output.json"
+	defer os.Remove(logPath)
+	defer os.Remove(outputPath)
+
+	// MariaDB 时间为本地时间，Aurora 时间为微秒时间戳；object 中可能包含转义引号与换行
+	local := time.Date(2024, 1, 19, 16, 29, 48, 0, time.Local)
+	input := "20240119 16:29:48,db01,app,10.0.0.1,5,0,CONNECT,shop,,0\n" +
+		"20240119 16:29:48,db01,app,10.0.0.1,5,11,QUERY,shop,'select \\'a,b\\' from t',0\n" +
+		"20240119 16:29:48,db01,app,10.0.0.1,5,11,READ,shop,t,0\n" +
+		"20240119 16:29:48,db01,app,10.0.0.1,5,12,QUERY,shop,'UPDATE t\n" +
+		"SET c = 1\n" +
+		"WHERE id = 2',0\n" +
+		"20240119 16:29:48,db01,app,10.0.0.1,5,13,QUERY,shop,'select * from missing',1146\n" +
+		"20240119 16:29:48,db01,bad,10.0.0.1,6,0,FAILED_CONNECT,,,1045\n" +
+		"20240119 16:29:48,db01,bad,10.0.0.1,7,0,CONNECT,,,1045\n" +
+		"20240119 16:29:48,db01,app,10.0.0.1,5,0,DISCONNECT,shop,,0\n" +
+		"1705681790000100,ip-10-0-0-2,admin,10.0.0.2,8,14,QUERY,,'select 1',0\n"
+	if err := os.WriteFile(logPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	ParseServerAuditLogs(logPath, outputPath)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+
+	ts := float64(local.Unix())
+	expected := []LogEntry{
+		{ConnectionID: "5", Username: "app", DBName: "shop", Event: EventConnect, Timestamp: ts},
+		{ConnectionID: "5", Username: "app", DBName: "shop", SQL: "select 'a,b' from t", SQLType: "select", Timestamp: ts},
+		{ConnectionID: "5", Username: "app", DBName: "shop", SQL: "UPDATE t\nSET c = 1\nWHERE id = 2", SQLType: "update", Timestamp: ts},
+		{ConnectionID: "5", Username: "app", DBName: "shop", SQL: "select * from missing", SQLType: "select", Timestamp: ts, ErrorCode: 1146},
+		{ConnectionID: "5", Username: "app", DBName: "shop", Event: EventQuit, Timestamp: ts},
+		{ConnectionID: "8", Username: "admin", DBName: "", SQL: "select 1", SQLType: "select", Timestamp: 1705681790.0001},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.Username != e.Username || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || a.Event != e.Event || a.ErrorCode != e.ErrorCode || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slowLogTimeLayout is the format of start_time, both as read from the table
+// and as written by the CSV engine. Values of this layout sort as strings.
+const slowLogTimeLayout = "2006-01-02 15:04:05.999999"
+
+// slowLogEndLayout is the format of the end times saved in the resume file.
+const slowLogEndLayout = "2006-01-02 15:04:05.000000"
+
+// Rows are inserted when their statement ends, and concurrent statements do
+// not end in insertion order: a resumed run reads again the rows that ended
+// within this window before the newest row of the previous run.
+const slowLogResumeOverlap = time.Second
+
+// errSlowLogNoThreadID is returned for MySQL 5.6 tables. Without thread_id
+// the statements cannot be assigned to their connections.
+var errSlowLogNoThreadID = errors.New("mysql.slow_log has no thread_id column (MySQL 5.6), the statements cannot be assigned to their connections")
+
+// Column order of mysql.slow_log (and of its slow_log.CSV file) in 5.7/8.0.
+var slowLogTableColumns = []string{"start_time", "user_host", "query_time", "lock_time", "rows_sent",
+	"rows_examined", "db", "last_insert_id", "insert_id", "server_id", "sql_text", "thread_id"}
+
+// slowLogRow is one row of mysql.slow_log.
+type slowLogRow struct {
+	StartTime string // as stored, in the server time zone
+	Timestamp float64
+	UserHost  string
+	QueryTime string // TIME(6), e.g. 00:00:01.500000
+	EndTime   string // start_time + query_time, in slowLogEndLayout
+	RowsSent  int
+	DB        string
+	SQL       string
+	ThreadID  string
+}
+
+// slowLogRange limits the rows by start_time: From <= start_time < To, and
+// by end time when resuming: end time > After. Empty bounds are not applied.
+type slowLogRange struct {
+	From, To, After string
+}
+
+func (r slowLogRange) contains(row *slowLogRow) bool {
+	return (r.From == "" || row.StartTime >= r.From) && (r.To == "" || row.StartTime < r.To) &&
+		(r.After == "" || row.EndTime == "" || row.EndTime > r.After)
+}
+
+// parseSlowLogQueryTime converts a TIME value such as 00:00:01.500000 to
+// microseconds.
+func parseSlowLogQueryTime(value string) (int64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid query_time %q", value)
+	}
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, err
+	}
+	return (hours*3600+minutes*60)*1e6 + int64(seconds*1e6+0.5), nil
+}
+
+// slowLogEndTime returns start_time + query_time in slowLogEndLayout. Both are
+// wall clock values of the server, so no time zone applies.
+func slowLogEndTime(startTime, queryTime string) (string, error) {
+	start, err := time.Parse(slowLogTimeLayout, startTime)
+	if err != nil {
+		return "", err
+	}
+	micros, err := parseSlowLogQueryTime(queryTime)
+	if err != nil {
+		return "", err
+	}
+	return start.Add(time.Duration(micros) * time.Microsecond).Format(slowLogEndLayout), nil
+}
+
+// key identifies a row for the resume file.
+func (row *slowLogRow) key() string {
+	h := fnv.New64a()
+	for _, field := range []string{row.StartTime, row.UserHost, row.ThreadID, row.QueryTime, row.SQL} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+func (row *slowLogRow) entry() (*LogEntry, error) {
+	queryTime, err := parseSlowLogQueryTime(row.QueryTime)
+	if err != nil {
+		return nil, err
+	}
+	if row.ThreadID == "" {
+		return nil, errors.New("empty thread_id")
+	}
+	entry := &LogEntry{
+		ConnectionID: row.ThreadID,
+		QueryTime:    queryTime,
+		SQL:          strings.TrimSpace(row.SQL),
+		RowsSent:     row.RowsSent,
+		Username:     auditUsername(row.UserHost),
+		DBName:       row.DB,
+		Timestamp:    row.Timestamp,
+	}
+	setDigestAndType(entry)
+	return entry, nil
+}
+
+// readSlowLogTable reads mysql.slow_log over a connection, in start_time
+// order.
+func readSlowLogTable(dbConnStr string, r slowLogRange, handle func(row *slowLogRow) error) error {
+	db, err := sql.Open("mysql", dbConnStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var columns int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.columns
+WHERE table_schema = 'mysql' AND table_name = 'slow_log' AND column_name = 'thread_id'`).Scan(&columns); err != nil {
+		return err
+	}
+	if columns == 0 {
+		return errSlowLogNoThreadID
+	}
+	query := `SELECT DATE_FORMAT(start_time, '%Y-%m-%d %H:%i:%s.%f'), UNIX_TIMESTAMP(start_time), user_host,
+    CAST(query_time AS CHAR), rows_sent, IFNULL(db, ''), sql_text, thread_id
+FROM mysql.slow_log WHERE 1 = 1`
+	var args []interface{}
+	if r.From != "" {
+		query += " AND start_time >= ?"
+		args = append(args, r.From)
+	}
+	if r.To != "" {
+		query += " AND start_time < ?"
+		args = append(args, r.To)
+	}
+	if r.After != "" {
+		query += " AND ADDTIME(start_time, query_time) > ?"
+		args = append(args, r.After)
+	}
+	query += " ORDER BY start_time"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row slowLogRow
+		var sqlText []byte
+		if err := rows.Scan(&row.StartTime, &row.Timestamp, &row.UserHost, &row.QueryTime, &row.RowsSent,
+			&row.DB, &sqlText, &row.ThreadID); err != nil {
+			return err
+		}
+		row.SQL = string(sqlText)
+		row.EndTime, _ = slowLogEndTime(row.StartTime, row.QueryTime)
+		if err := handle(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// readSlowLogCSV reads the slow_log.CSV file of the CSV engine, or a CSV
+// dump of the table with or without a header line. Start times are read in
+// the local time zone.
+func readSlowLogCSV(path string, r slowLogRange, handle func(row *slowLogRow) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 1024*1024)
+	columns := make(map[string]int)
+	for i, name := range slowLogTableColumns {
+		columns[name] = i
+	}
+	first := true
+	for {
+		record, err := readSlowLogCSVRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			first = false
+			if strings.EqualFold(strings.TrimSpace(record[0]), "start_time") {
+				columns = make(map[string]int)
+				for i, name := range record {
+					columns[strings.ToLower(strings.TrimSpace(name))] = i
+				}
+				continue
+			}
+		}
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+
+		if _, ok := columns["thread_id"]; !ok || len(record) == len(slowLogTableColumns)-1 {
+			// a header without thread_id, or the 11 columns of 5.6
+			return errSlowLogNoThreadID
+		}
+
+		row := slowLogRow{
+			StartTime: field("start_time"),
+			UserHost:  field("user_host"),
+			QueryTime: field("query_time"),
+			DB:        field("db"),
+			SQL:       field("sql_text"),
+			ThreadID:  field("thread_id"),
+		}
+		// malformed rows are counted by the caller
+		row.EndTime, _ = slowLogEndTime(row.StartTime, row.QueryTime)
+		if !r.contains(&row) {
+			continue
+		}
+		parsedTime, err := time.ParseInLocation(slowLogTimeLayout, row.StartTime, time.Local)
+		if err != nil {
+			fmt.Printf("Error parsing start_time %q: %v\n", row.StartTime, err)
+			continue
+		}
+		row.Timestamp = float64(parsedTime.UnixNano()) / 1e9
+		row.RowsSent, _ = strconv.Atoi(field("rows_sent"))
+		if err := handle(&row); err != nil {
+			return err
+		}
+	}
+}
+
+// readSlowLogCSVRecord reads one record. Quoted fields may contain newlines
+// and use either backslash escapes (CSV engine) or doubled quotes.
+func readSlowLogCSVRecord(r *bufio.Reader) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inQuotes, started := false, false
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			if !started {
+				return nil, io.EOF
+			}
+			if inQuotes {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return append(fields, field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		started = true
+		if inQuotes {
+			switch c {
+			case '\\':
+				next, err := r.ReadByte()
+				if err != nil {
+					return nil, io.ErrUnexpectedEOF
+				}
+				switch next {
+				case 'n':
+					field.WriteByte('\n')
+				case 'r':
+					field.WriteByte('\r')
+				case 't':
+					field.WriteByte('\t')
+				case '0':
+					field.WriteByte(0)
+				default:
+					field.WriteByte(next)
+				}
+			case '"':
+				if next, err := r.Peek(1); err == nil && next[0] == '"' {
+					r.ReadByte()
+					field.WriteByte('"')
+				} else {
+					inQuotes = false
+				}
+			default:
+				field.WriteByte(c)
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inQuotes = true
+		case ',':
+			fields = append(fields, field.String())
+			field.Reset()
+		case '\r':
+		case '\n':
+			return append(fields, field.String()), nil
+		default:
+			field.WriteByte(c)
+		}
+	}
+}
+
+// slowLogResume is the position kept in the resume file: the end time of the
+// newest row read, and the keys of the rows that ended within
+// slowLogResumeOverlap before it, which the next run reads again and skips.
+//
+// File format: the end time on the first line, then one "end time<TAB>key"
+// line per recent row.
+type slowLogResume struct {
+	last    string
+	recent  map[string]string // key -> end time
+	pruneAt int
+}
+
+func readSlowLogResume(path string) (*slowLogResume, error) {
+	resume := &slowLogResume{recent: make(map[string]string), pruneAt: 1024}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return resume, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	resume.last = strings.TrimSpace(lines[0])
+	for _, line := range lines[1:] {
+		if end, key, ok := strings.Cut(strings.TrimSpace(line), "\t"); ok {
+			resume.recent[key] = end
+		}
+	}
+	return resume, nil
+}
+
+// since returns the end time lower bound of the rows to read.
+func (s *slowLogResume) since() string {
+	last, err := time.Parse(slowLogEndLayout, s.last)
+	if err != nil {
+		// resume files of earlier versions hold a start_time
+		return s.last
+	}
+	return last.Add(-slowLogResumeOverlap).Format(slowLogEndLayout)
+}
+
+// add records a row and reports whether it is new.
+func (s *slowLogResume) add(row *slowLogRow) bool {
+	key := row.key()
+	if _, ok := s.recent[key]; ok {
+		return false
+	}
+	s.recent[key] = row.EndTime
+	if row.EndTime > s.last {
+		s.last = row.EndTime
+	}
+	if len(s.recent) >= s.pruneAt {
+		s.prune()
+		s.pruneAt = 2*len(s.recent) + 1024
+	}
+	return true
+}
+
+// prune forgets the rows the next run does not read again.
+func (s *slowLogResume) prune() {
+	since := s.since()
+	for key, end := range s.recent {
+		if end <= since {
+			delete(s.recent, key)
+		}
+	}
+}
+
+func (s *slowLogResume) write(path string) error {
+	s.prune()
+	lines := make([]string, 0, len(s.recent))
+	for key, end := range s.recent {
+		lines = append(lines, end+"\t"+key)
+	}
+	sort.Strings(lines)
+	return os.WriteFile(path, []byte(s.last+"\n"+strings.Join(append(lines, ""), "\n")), 0644)
+}
+
+// ParseSlowLogTable converts the rows of mysql.slow_log (log_output=TABLE)
+// into replay entries. Rows are read from slowLogPath when it is set (the
+// slow_log.CSV file or a CSV dump), otherwise over dbConnStr.
+//
+// With a resume file the end time of the newest row is saved, the next run
+// only reads the rows inserted since and appends them to the output.
+func ParseSlowLogTable(dbConnStr, slowLogPath, outputPath, startTime, endTime, resumeFile string) {
+	if outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parsemysqlslowtable [-db <mysql_connection_string> | -slow-in <path_to_slow_log_csv>] -slow-out <path_to_slow_output_file> [-start-time '2024-01-19 00:00:00'] [-end-time '2024-01-20 00:00:00'] [-resume-file <path>]")
+		return
+	}
+
+	r := slowLogRange{From: startTime, To: endTime}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var resume *slowLogResume
+	if resumeFile != "" {
+		var err error
+		if resume, err = readSlowLogResume(resumeFile); err != nil {
+			fmt.Println("Error reading resume file:", err)
+			return
+		}
+		if resume.last != "" {
+			r.After = resume.since()
+		}
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	outputFile, err := os.OpenFile(outputPath, flags, 0644)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+
+	var statements, skipped int
+	var last string
+	handle := func(row *slowLogRow) error {
+		entry, err := row.entry()
+		if err != nil {
+			skipped++
+			return nil
+		}
+		if resume != nil && !resume.add(row) {
+			// read by the previous run
+			return nil
+		}
+		if row.EndTime > last {
+			last = row.EndTime
+		}
+		if entry.SQL == "" {
+			return nil
+		}
+		statements++
+		return writeLogEntry(writer, entry)
+	}
+	if slowLogPath != "" {
+		err = readSlowLogCSV(slowLogPath, r, handle)
+	} else {
+		err = readSlowLogTable(dbConnStr, r, handle)
+	}
+	if err != nil {
+		fmt.Println("Error reading slow_log:", err)
+	}
+	if err := writer.Flush(); err != nil {
+		fmt.Println("Error writing output:", err)
+		return
+	}
+
+	if resume != nil && resume.last != "" {
+		if err := resume.write(resumeFile); err != nil {
+			fmt.Println("Error writing resume file:", err)
+		}
+	}
+	fmt.Printf("slow_log processed: %d statements written to output json, %d malformed rows skipped, last end time %s\n", statements, skipped, last)
+}
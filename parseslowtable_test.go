@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readLogEntriesForTest(t *testing.T, path string) []LogEntry {
+	outputFile, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var entries []LogEntry
+	reader := newLineReader(outputFile, readerConfig.MaxLineSize)
+	for {
+		line, err := reader.Next()
+		if err != nil {
+			break
+		}
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestParseSlowLogTableCSV(t *testing.T) {
+	csvPath := "test_slow_log.CSV"
+	outputPath := "test_slow_log_output.json"
+	resumePath := "test_slow_log_resume"
+	defer os.Remove(csvPath)
+	defer os.Remove(outputPath)
+	defer os.Remove(resumePath)
+
+	// CSV 引擎格式：无表头，字符串使用反斜杠转义
+	input := `"2024-01-19 16:29:48.141142","t1[t1] @  [10.2.103.21]","00:00:00.000038","00:00:00.000000",1,1,"db1",0,0,1,"SELECT c FROM sbtest1 WHERE id=250438",797` + "\n" +
+		`"2024-01-19 16:29:49.000000","t1[t1] @  [10.2.103.21]","00:00:01.500000","00:00:00.000000",0,10,"db1",0,0,1,"UPDATE t SET c = \"a\\b\"\nWHERE id = 1",797` + "\n" +
+		`"2024-01-19 16:29:50.000000","t2[t2] @ localhost []","00:00:00.000100","00:00:00.000000",2,2,"",0,0,1,"select 2",798` + "\n"
+	if err := os.WriteFile(csvPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	// 时间范围 [16:29:48, 16:29:50)
+	ParseSlowLogTable("", csvPath, outputPath, "2024-01-19 16:29:48", "2024-01-19 16:29:50", "")
+	actual := readLogEntriesForTest(t, outputPath)
+	ts := float64(time.Date(2024, 1, 19, 16, 29, 48, 141142000, time.Local).UnixNano()) / 1e9
+	expected := []LogEntry{
+		{ConnectionID: "797", Username: "t1", DBName: "db1", SQL: "SELECT c FROM sbtest1 WHERE id=250438", SQLType: "select", QueryTime: 38, RowsSent: 1, Timestamp: ts},
+		{ConnectionID: "797", Username: "t1", DBName: "db1", SQL: "UPDATE t SET c = \"a\\b\"\nWHERE id = 1", SQLType: "update", QueryTime: 1500000, Timestamp: ts + 0.858858},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.Username != e.Username || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || a.QueryTime != e.QueryTime || a.RowsSent != e.RowsSent || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+
+	// 增量模式：第一次读取全部，第二次只读取新增的行并追加输出
+	os.Remove(outputPath)
+	ParseSlowLogTable("", csvPath, outputPath, "", "", resumePath)
+	if got := readLogEntriesForTest(t, outputPath); len(got) != 3 {
+		t.Fatalf("Expected 3 entries, got %+v", got)
+	}
+	// 保存最后结束的语句的结束时间（start_time + query_time）
+	if data, _ := os.ReadFile(resumePath); !strings.HasPrefix(string(data), "2024-01-19 16:29:50.500000\n") {
+		t.Errorf("Unexpected resume position %q", data)
+	}
+	// 表头格式的导出文件，使用双引号转义；16:29:49.9 开始的语句在上次读取之后才结束并写入，
+	// 仍需读取，重叠窗口内已读取的记录不重复输出
+	dump := "start_time,user_host,query_time,lock_time,rows_sent,rows_examined,db,last_insert_id,insert_id,server_id,sql_text,thread_id\n" +
+		input + `"2024-01-19 16:29:51.000000","t2[t2] @ localhost []","00:00:00.000100","00:00:00.000000",0,0,"db2",0,0,1,"select ""x""",798` + "\n" +
+		`"2024-01-19 16:29:49.900000","t2[t2] @ localhost []","00:00:00.700000","00:00:00.000000",0,0,"db2",0,0,1,"select 3",799` + "\n"
+	if err := os.WriteFile(csvPath, []byte(dump), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	ParseSlowLogTable("", csvPath, outputPath, "", "", resumePath)
+	got := readLogEntriesForTest(t, outputPath)
+	if len(got) != 5 || got[3].SQL != `select "x"` || got[3].DBName != "db2" || got[3].ConnectionID != "798" ||
+		got[4].SQL != "select 3" {
+		t.Fatalf("Unexpected entries after resume: %+v", got)
+	}
+}
+
+func TestParseSlowLogTableWithoutThreadID(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "slow_log.CSV")
+	// MySQL 5.6 的 slow_log 只有 11 列，没有 thread_id，不能把所有语句放到同一个连接
+	input := `"2024-01-19 16:29:48.141142","t1[t1] @  [10.2.103.21]","00:00:00.000038","00:00:00.000000",1,1,"db1",0,0,1,"select 1"` + "\n"
+	if err := os.WriteFile(csvPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	err := readSlowLogCSV(csvPath, slowLogRange{}, func(row *slowLogRow) error {
+		t.Errorf("Unexpected row %+v", row)
+		return nil
+	})
+	if err != errSlowLogNoThreadID {
+		t.Errorf("Expected errSlowLogNoThreadID, got %v", err)
+	}
+}
@@ -35,6 +35,7 @@ func ParseTiDBLogs(slowLogPath, slowOutputPath string) {
     var isInternal bool
     var sqlStatement string
     var isPrepared string // 声明 isPrepared 变量
+    var truncated int     // 参数被截断、只能以文本回放的预编译语句数
     timeRegex := regexp.MustCompile(`# Time:\s+(\d+-\d+-\d+T\d+:\d+:\d+\.\d+[+-]\d+:\d+)`)
     userHostRegex := regexp.MustCompile(`# User@Host:\s+(\w+)`)
     connIDRegex := regexp.MustCompile(`# Conn_ID:\s+(\d+)`)
@@ -51,6 +52,9 @@ func ParseTiDBLogs(slowLogPath, slowOutputPath string) {
             if !isInternal { // 如果 Is_internal 为 true，跳过这个日志段落
                 if isPrepared == "true" {
                     entry.SQL = formatSQL(sqlStatement)
+                    if !setPreparedStatement(&entry, sqlStatement) {
+                        truncated++
+                    }
                 } else {
                     entry.SQL = sqlStatement
                 }
@@ -132,6 +136,9 @@ func ParseTiDBLogs(slowLogPath, slowOutputPath string) {
     if !isInternal {
         if isPrepared == "true" {
             entry.SQL = formatSQL(sqlStatement)
+            if !setPreparedStatement(&entry, sqlStatement) {
+                truncated++
+            }
         } else {
             entry.SQL = sqlStatement
         }
@@ -149,6 +156,8 @@ func ParseTiDBLogs(slowLogPath, slowOutputPath string) {
 
     // 逐个输出 JSON 对象
     for _, entry := range entries {
+        // Time 是语句结束时间
+        setStartTime(&entry)
         jsonEntry, err := json.Marshal(entry)
         if err != nil {
             fmt.Println("Error marshaling JSON:", err)
@@ -158,6 +167,9 @@ func ParseTiDBLogs(slowLogPath, slowOutputPath string) {
         outputFile.WriteString("\n")
     }
 
+    if truncated > 0 {
+        fmt.Printf("%d prepared statements have arguments truncated by TiDB, they are replayed as text\n", truncated)
+    }
     fmt.Println("Logs processed and written to output json")
 }
 
@@ -180,10 +192,7 @@ func formatSQL(input string) string {
         }
 
         // 拆分参数并去掉多余的空格
-        arguments = strings.Split(argumentsStr, ",")
-        for i := range arguments {
-            arguments[i] = strings.TrimSpace(arguments[i]) // 去除空格
-        }
+        arguments = splitArguments(argumentsStr)
 
         // 去掉原始 input 中的 arguments 部分
         input = strings.Replace(input, match[0], "", 1)
@@ -194,7 +203,9 @@ func formatSQL(input string) string {
     argIndex := 0 // 当前参数索引
     inQuotes := 0 // 引号计数：0 表示不在引号内，1 表示在单引号内，2 表示在双引号内
 
-    for i, char := range input {
+    // 按字节遍历，非 UTF-8 的字节原样保留
+    for i := 0; i < len(input); i++ {
+        char := input[i]
         if char == '"' {
             inQuotes = (inQuotes + 2) % 4 // 切换双引号状态
         } else if char == '\'' {
@@ -224,11 +235,94 @@ func formatSQL(input string) string {
 
         // 处理转义字符和保留原字符
         if char == '\\' && i < len(input)-1 && (input[i+1] == '"' || input[i+1] == '\'') {
-            result.WriteRune(char)
+            result.WriteByte(char)
             continue
         }
-        result.WriteRune(char) // 其他字符直接写入
+        result.WriteByte(char) // 其他字符直接写入
     }
 
     return result.String()
 }
+
+// reTruncationMark 匹配 TiDB 截断超过 2048 字节的参数后追加的 "len(N)"（原长度）。
+var reTruncationMark = regexp.MustCompile(`^len\(\d+\)$`)
+
+// preparedStatement 函数将 SQL 拆分为语句模板与 arguments 中带类型的参数，用于以预编译方式回放。
+// 有参数被 TiDB 截断时返回 false，此时参数值不完整。
+func preparedStatement(input string) (string, []StmtArg, bool) {
+    argumentsRegex := regexp.MustCompile(`\[arguments:\s*(\((.*?)\)|([^()]+))\]`)
+    match := argumentsRegex.FindStringSubmatch(input)
+    if match == nil {
+        return strings.TrimSpace(input), nil, true
+    }
+    argumentsStr := match[3]
+    if match[2] != "" {
+        argumentsStr = match[2]
+    }
+    template := strings.TrimSpace(strings.Replace(input, match[0], "", 1))
+
+    var args []StmtArg
+    for _, arg := range splitArguments(argumentsStr) {
+        if i := strings.LastIndex(arg, " len("); i >= 0 && reTruncationMark.MatchString(arg[i+1:]) {
+            return template, nil, false
+        }
+        // 带引号的参数为字符串，其余按数值推断类型
+        if isQuotedArgument(arg) {
+            args = append(args, newStringArg([]byte(arg[1:len(arg)-1])))
+        } else if strings.EqualFold(arg, "NULL") || arg == "<nil>" {
+            args = append(args, StmtArg{Type: ArgNull})
+        } else if _, err := strconv.ParseInt(arg, 10, 64); err == nil {
+            args = append(args, StmtArg{Type: ArgInt, Value: arg})
+        } else if _, err := strconv.ParseUint(arg, 10, 64); err == nil {
+            args = append(args, StmtArg{Type: ArgUint, Value: arg})
+        } else if _, err := strconv.ParseFloat(arg, 64); err == nil {
+            args = append(args, StmtArg{Type: ArgDecimal, Value: arg})
+        } else {
+            args = append(args, newStringArg([]byte(arg)))
+        }
+    }
+    return template, args, true
+}
+
+// setPreparedStatement 设置 entry 的语句模板与参数。参数被截断时不设置，语句以文本回放。
+func setPreparedStatement(entry *LogEntry, input string) bool {
+    template, args, ok := preparedStatement(input)
+    if ok {
+        entry.StmtSQL, entry.StmtArgs = template, args
+    }
+    return ok
+}
+
+func isQuotedArgument(arg string) bool {
+    return len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"'
+}
+
+// splitArguments 按逗号拆分 arguments。TiDB 原样写出双引号内的字符串参数，不做转义，
+// 因此字符串在其后紧跟逗号、截断标记 " len(N)" 或结尾的双引号处结束，其中的逗号不作为分隔。
+func splitArguments(argumentsStr string) []string {
+    var arguments []string
+    inString := false
+    start := 0
+    for i := 0; i < len(argumentsStr); i++ {
+        char := argumentsStr[i]
+        switch {
+        case !inString && char == '"' && strings.TrimSpace(argumentsStr[start:i]) == "":
+            inString = true
+        case inString && char == '"' && argumentEnds(argumentsStr[i+1:]):
+            inString = false
+        case !inString && char == ',':
+            arguments = append(arguments, strings.TrimSpace(argumentsStr[start:i]))
+            start = i + 1
+        }
+    }
+    return append(arguments, strings.TrimSpace(argumentsStr[start:]))
+}
+
+// argumentEnds 判断字符串参数的结束双引号之后的内容是否为参数的结尾。
+func argumentEnds(rest string) bool {
+    if i := strings.IndexByte(rest, ','); i >= 0 {
+        rest = rest[:i]
+    }
+    rest = strings.TrimSpace(rest)
+    return rest == "" || reTruncationMark.MatchString(rest)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTiDBGeneralLogs extracts the [GENERAL_LOG] lines of a tidb.log
+// (tidb_general_log=ON) into replay entries.
+func ParseTiDBGeneralLogs(logPath, outputPath string) {
+	if logPath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parsetidbgeneral -slow-in <path_to_tidb_log> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 512*1024*1024)
+
+	var statements, skipped, truncated int
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, "[GENERAL_LOG]") {
+			continue
+		}
+		entry, err := parseTiDBGeneralLine(line)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if entry.SQL == "" {
+			continue
+		}
+		if entry.StmtSQL == "" && strings.Contains(line, "[arguments:") {
+			truncated++
+		}
+		if err := writeLogEntry(writer, entry); err != nil {
+			fmt.Println("Error writing output:", err)
+			return
+		}
+		statements++
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Println("Error reading file:", err)
+	}
+	if truncated > 0 {
+		fmt.Printf("%d prepared statements have arguments truncated by TiDB, they are replayed as text\n", truncated)
+	}
+	fmt.Printf("TiDB general log processed: %d statements written to output json, %d malformed lines skipped\n", statements, skipped)
+}
+
+// parseTiDBGeneralLine reads a line such as
+//
+//	[2024/01/19 16:29:48.141 +08:00] [INFO] [session.go:3742] [GENERAL_LOG] [conn=5] [user=root@127.0.0.1] [schemaVersion=52] [txnStartTS=447057069445234689] ... [current_db=test] [txn_mode=PESSIMISTIC] [sql="select 1"]
+//
+// Newer versions name the fields currentDB and sessionTxnMode.
+func parseTiDBGeneralLine(line string) (*LogEntry, error) {
+	fields, err := parseTiDBLogFields(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 1 || fields[0].key != "" {
+		return nil, fmt.Errorf("missing log time")
+	}
+	parsedTime, err := time.Parse("2006/01/02 15:04:05.000 -07:00", fields[0].value)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &LogEntry{Timestamp: float64(parsedTime.UnixNano()) / 1e9}
+	for _, f := range fields[1:] {
+		switch f.key {
+		case "conn":
+			entry.ConnectionID = f.value
+		case "user":
+			entry.Username = f.value
+			if i := strings.LastIndex(f.value, "@"); i >= 0 {
+				entry.Username = f.value[:i]
+			}
+		case "current_db", "currentDB":
+			entry.DBName = f.value
+		case "txnStartTS":
+			entry.TxnStartTS, _ = strconv.ParseUint(f.value, 10, 64)
+		case "sql":
+			entry.SQL = f.value
+		}
+	}
+	if entry.ConnectionID == "" {
+		return nil, fmt.Errorf("missing conn field")
+	}
+	// Statements run through the binary protocol are logged with their
+	// "[arguments: ...]", which are inlined like in the slow log.
+	if strings.Contains(entry.SQL, "[arguments:") {
+		setPreparedStatement(entry, entry.SQL)
+		entry.SQL = formatSQL(entry.SQL)
+	}
+	entry.SQL = strings.TrimSpace(entry.SQL)
+	if entry.SQL != "" {
+		setDigestAndType(entry)
+	}
+	return entry, nil
+}
+
+type tidbLogField struct {
+	key, value string
+}
+
+// parseTiDBLogFields splits a TiDB log line into its bracketed fields.
+// "[key=value]" fields are returned with their key, values quoted by the
+// logger are unquoted, and fields without "=" (time, level, source) have an
+// empty key.
+func parseTiDBLogFields(line string) ([]tidbLogField, error) {
+	var fields []tidbLogField
+	for i := 0; i < len(line); {
+		if line[i] != '[' {
+			i++
+			continue
+		}
+		i++
+		end := strings.IndexAny(line[i:], "=]")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated field")
+		}
+		if line[i+end] == ']' {
+			fields = append(fields, tidbLogField{value: line[i : i+end]})
+			i += end + 1
+			continue
+		}
+		key := line[i : i+end]
+		i += end + 1
+		var value string
+		if i < len(line) && line[i] == '"' {
+			quoted, err := strconv.QuotedPrefix(line[i:])
+			if err != nil {
+				return nil, err
+			}
+			value, _ = strconv.Unquote(quoted)
+			i += len(quoted)
+			if i >= len(line) || line[i] != ']' {
+				return nil, fmt.Errorf("unterminated field %s", key)
+			}
+		} else {
+			close := strings.IndexByte(line[i:], ']')
+			if close < 0 {
+				return nil, fmt.Errorf("unterminated field %s", key)
+			}
+			value = line[i : i+close]
+			i += close
+		}
+		fields = append(fields, tidbLogField{key: key, value: value})
+		i++
+	}
+	return fields, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestParseTiDBGeneralLogs(t *testing.T) {
+	logPath := "test_tidb_general.log"
+	outputPath := "test_tidb_general_output.json"
+	defer os.Remove(logPath)
+	defer os.Remove(outputPath)
+
+	// 旧版本字段为 current_db/txn_mode，新版本为 currentDB/sessionTxnMode；非 GENERAL_LOG 行需要忽略
+	input := `[2024/01/19 16:29:48.141 +08:00] [INFO] [server.go:680] ["new connection"] [conn=5] [remoteAddr=127.0.0.1:50000]
+[2024/01/19 16:29:48.141 +08:00] [INFO] [session.go:3170] [GENERAL_LOG] [conn=5] [user=root@127.0.0.1] [schemaVersion=52] [txnStartTS=0] [forUpdateTS=0] [isReadConsistency=false] [current_db=test] [txn_mode=PESSIMISTIC] [sql=begin]
+[2024/01/19 16:29:48.200 +08:00] [INFO] [session.go:3170] [GENERAL_LOG] [conn=5] [user=root@127.0.0.1] [schemaVersion=52] [txnStartTS=447057069445234689] [forUpdateTS=447057069445234689] [isReadConsistency=false] [current_db=test] [txn_mode=PESSIMISTIC] [sql="update t set c = \"a]b\"\nwhere id = 1"]
+[2024/01/19 16:29:49.000 +08:00] [INFO] [session.go:3742] [GENERAL_LOG] [conn=2199023255959] [session_alias=] [user=app@%] [schemaVersion=52] [txnStartTS=447057069445234690] [forUpdateTS=447057069445234690] [isReadConsistency=false] [currentDB=shop] [isPessimistic=true] [sessionTxnMode=PESSIMISTIC] [sql="select * from t where id = ? and c = ? [arguments: (1, 2)]"]
+`
+	if err := os.WriteFile(logPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	ParseTiDBGeneralLogs(logPath, outputPath)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+
+	expected := []LogEntry{
+		{ConnectionID: "5", Username: "root", DBName: "test", SQL: "begin", SQLType: "begin", Timestamp: 1705652988.141},
+		{ConnectionID: "5", Username: "root", DBName: "test", SQL: "update t set c = \"a]b\"\nwhere id = 1", SQLType: "update", Timestamp: 1705652988.2, TxnStartTS: 447057069445234689},
+		{ConnectionID: "2199023255959", Username: "app", DBName: "shop", SQL: "select * from t where id = 1 and c = 2", SQLType: "select", Timestamp: 1705652989, TxnStartTS: 447057069445234690},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.Username != e.Username || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || a.TxnStartTS != e.TxnStartTS || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TiProxy writes every captured command as a few "# Key: value" lines
+// followed by the payload (the packet without its command byte):
+//
+//	# Time: 2024-08-29T17:37:12.612+08:00
+//	# Conn_ID: 1
+//	# Cmd_type: Execute       (absent for Query)
+//	# Success: false          (absent when the command succeeded)
+//	# Payload_len: 8
+//	select 1
+const (
+	tiproxyKeyTime       = "# Time: "
+	tiproxyKeyConnID     = "# Conn_ID: "
+	tiproxyKeyType       = "# Cmd_type: "
+	tiproxyKeySuccess    = "# Success: "
+	tiproxyKeyPayloadLen = "# Payload_len: "
+)
+
+// tiproxyCommands maps the Cmd_type names (lowercased, without spaces and
+// underscores) to the protocol command.
+var tiproxyCommands = map[string]byte{
+	"query":            comQuery,
+	"initdb":           comInitDB,
+	"quit":             comQuit,
+	"changeuser":       comChangeUser,
+	"resetconnection":  comResetConnection,
+	"prepare":          comStmtPrepare,
+	"stmtprepare":      comStmtPrepare,
+	"execute":          comStmtExecute,
+	"stmtexecute":      comStmtExecute,
+	"longdata":         comStmtSendLongData,
+	"stmtsendlongdata": comStmtSendLongData,
+	"closestmt":        comStmtClose,
+	"stmtclose":        comStmtClose,
+	"resetstmt":        comStmtReset,
+	"stmtreset":        comStmtReset,
+}
+
+// tiproxyErrUnknown is the error code of failed commands, the capture does
+// not keep the real one (ER_UNKNOWN_ERROR).
+const tiproxyErrUnknown = 1105
+
+type tiproxyCommand struct {
+	Time    time.Time
+	ConnID  string
+	Type    string
+	Success bool
+	Payload []byte
+}
+
+// readTiProxyCommands reads the commands of one capture file.
+func readTiProxyCommands(r *bufio.Reader, handle func(cmd *tiproxyCommand)) error {
+	var cmd *tiproxyCommand
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, tiproxyKeyTime):
+			if cmd != nil {
+				// command without payload
+				handle(cmd)
+			}
+			t, perr := time.Parse(time.RFC3339Nano, line[len(tiproxyKeyTime):])
+			if perr != nil {
+				return fmt.Errorf("invalid time %q: %v", line, perr)
+			}
+			cmd = &tiproxyCommand{Time: t, Type: "Query", Success: true}
+		case cmd == nil:
+			// not a command header, e.g. a blank line
+		case strings.HasPrefix(line, tiproxyKeyConnID):
+			cmd.ConnID = line[len(tiproxyKeyConnID):]
+		case strings.HasPrefix(line, tiproxyKeyType):
+			cmd.Type = line[len(tiproxyKeyType):]
+		case strings.HasPrefix(line, tiproxyKeySuccess):
+			cmd.Success = line[len(tiproxyKeySuccess):] != "false"
+		case strings.HasPrefix(line, tiproxyKeyPayloadLen):
+			n, perr := strconv.Atoi(line[len(tiproxyKeyPayloadLen):])
+			if perr != nil || n < 0 {
+				return fmt.Errorf("invalid payload length %q", line)
+			}
+			cmd.Payload = make([]byte, n)
+			if _, err := io.ReadFull(r, cmd.Payload); err != nil {
+				return fmt.Errorf("truncated payload of connection %s: %v", cmd.ConnID, err)
+			}
+			if next, err := r.Peek(1); err == nil && next[0] == '\n' {
+				r.Discard(1)
+			}
+			handle(cmd)
+			cmd = nil
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if cmd != nil {
+		handle(cmd)
+	}
+	return nil
+}
+
+// tiproxySession is the state of one captured connection.
+type tiproxySession struct {
+	username   string
+	dbName     string
+	stmts      map[uint32]*preparedStmt
+	nextStmtID uint32
+}
+
+// tiproxyConverter turns captured commands into replay entries.
+type tiproxyConverter struct {
+	emit       func(entry *LogEntry)
+	sessions   map[string]*tiproxySession
+	statements int
+	unknown    int // executions of statements prepared before the capture
+}
+
+func newTiProxyConverter(emit func(entry *LogEntry)) *tiproxyConverter {
+	return &tiproxyConverter{emit: emit, sessions: make(map[string]*tiproxySession)}
+}
+
+func (c *tiproxyConverter) session(connID string) *tiproxySession {
+	s := c.sessions[connID]
+	if s == nil {
+		s = &tiproxySession{stmts: make(map[uint32]*preparedStmt)}
+		c.sessions[connID] = s
+	}
+	return s
+}
+
+func (c *tiproxyConverter) Command(cmd *tiproxyCommand) {
+	name := strings.ToLower(strings.NewReplacer(" ", "", "_", "").Replace(cmd.Type))
+	command, ok := tiproxyCommands[name]
+	if !ok || cmd.ConnID == "" {
+		return
+	}
+	s := c.session(cmd.ConnID)
+	data := cmd.Payload
+	entry := &LogEntry{
+		ConnectionID: cmd.ConnID,
+		Username:     s.username,
+		DBName:       s.dbName,
+		Timestamp:    float64(cmd.Time.UnixNano()) / 1e9,
+	}
+	if !cmd.Success {
+		entry.ErrorCode = tiproxyErrUnknown
+	}
+
+	switch command {
+	case comQuery:
+		entry.SQL = string(data)
+		if match := reUseStatement.FindStringSubmatch(entry.SQL); match != nil && cmd.Success {
+			s.dbName = match[1]
+		}
+	case comInitDB:
+		if cmd.Success {
+			s.dbName = string(data)
+		}
+		return
+	case comQuit:
+		delete(c.sessions, cmd.ConnID)
+		entry.Event = EventQuit
+		c.emit(entry)
+		return
+	case comChangeUser:
+		if cmd.Success {
+			s.username, s.dbName = parseChangeUser(data, clientProtocol41|clientSecureConnection|clientConnectWithDB|clientPluginAuth)
+			s.stmts = make(map[uint32]*preparedStmt)
+		}
+		return
+	case comResetConnection:
+		s.stmts = make(map[uint32]*preparedStmt)
+		return
+	case comStmtPrepare:
+		// The statement ids are not captured. Servers number the statements
+		// of a connection from 1, and failed prepares get no id.
+		if cmd.Success {
+			s.nextStmtID++
+			s.stmts[s.nextStmtID] = &preparedStmt{SQL: string(data), NumParams: countPlaceholders(string(data))}
+		}
+		return
+	case comStmtSendLongData:
+		if len(data) >= 6 {
+			if stmt := s.stmts[binary.LittleEndian.Uint32(data)]; stmt != nil {
+				if stmt.LongData == nil {
+					stmt.LongData = make(map[int][]byte)
+				}
+				id := int(binary.LittleEndian.Uint16(data[4:]))
+				stmt.LongData[id] = append(stmt.LongData[id], data[6:]...)
+			}
+		}
+		return
+	case comStmtClose:
+		if len(data) >= 4 {
+			delete(s.stmts, binary.LittleEndian.Uint32(data))
+		}
+		return
+	case comStmtReset:
+		if len(data) >= 4 {
+			if stmt := s.stmts[binary.LittleEndian.Uint32(data)]; stmt != nil {
+				stmt.LongData = nil
+			}
+		}
+		return
+	case comStmtExecute:
+		if len(data) < 4 {
+			return
+		}
+		stmt := s.stmts[binary.LittleEndian.Uint32(data)]
+		if stmt == nil {
+			c.unknown++
+			return
+		}
+		params, err := parseStmtExecute(data, stmt)
+		stmt.LongData = nil
+		if err != nil {
+			fmt.Printf("Error decoding COM_STMT_EXECUTE on connection %s: %v\n", cmd.ConnID, err)
+			return
+		}
+		entry.SQL = renderStmtSQL(stmt.SQL, params)
+		entry.StmtSQL, entry.StmtArgs = strings.TrimSpace(stmt.SQL), stmtArgs(params)
+	}
+
+	entry.SQL = strings.TrimSpace(entry.SQL)
+	if entry.SQL == "" {
+		return
+	}
+	setDigestAndType(entry)
+	c.emit(entry)
+	c.statements++
+}
+
+// countPlaceholders counts the ? parameters of a prepared statement, skipping
+// quoted strings and identifiers.
+func countPlaceholders(query string) int {
+	count := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			count++
+		}
+	}
+	return count
+}
+
+// listTiProxyFiles returns path itself, or the traffic files of a capture
+// directory in the order they were written: the rotated
+// traffic-<time>.log[.gz] files sort before the current traffic.log.
+func listTiProxyFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range dirEntries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, "traffic") &&
+			(strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")) {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ParseTiProxyCapture converts TiProxy traffic capture files into replay
+// entries. capturePath is a capture directory or a single (gzipped) file.
+func ParseTiProxyCapture(capturePath, outputPath string) {
+	if capturePath == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode parsetiproxy -slow-in <capture_directory_or_file> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	files, err := listTiProxyFiles(capturePath)
+	if err != nil {
+		fmt.Println("Error opening file:", err)
+		return
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	converter := newTiProxyConverter(func(entry *LogEntry) {
+		if err := writeLogEntry(writer, entry); err != nil {
+			fmt.Println("Error writing output:", err)
+		}
+	})
+	for _, path := range files {
+		if err := readTiProxyFile(path, converter.Command); err != nil {
+			fmt.Printf("Error reading file %s: %v\n", path, err)
+		}
+	}
+
+	fmt.Printf("TiProxy capture processed: %d files, %d statements written to output json, %d executions of statements prepared before the capture skipped\n", len(files), converter.statements, converter.unknown)
+}
+
+func readTiProxyFile(path string, handle func(cmd *tiproxyCommand)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return readTiProxyCommands(bufio.NewReaderSize(r, 1024*1024), handle)
+}
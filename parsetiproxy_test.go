@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// tiproxyRecord 按 TiProxy 的格式编码一条命令
+func tiproxyRecord(ts, connID, cmdType string, success bool, payload []byte) string {
+	s := fmt.Sprintf("# Time: %s\n# Conn_ID: %s\n", ts, connID)
+	if cmdType != "" {
+		s += "# Cmd_type: " + cmdType + "\n"
+	}
+	if !success {
+		s += "# Success: false\n"
+	}
+	return s + fmt.Sprintf("# Payload_len: %d\n", len(payload)) + string(payload) + "\n"
+}
+
+func TestParseTiProxyCapture(t *testing.T) {
+	dir := t.TempDir()
+
+	// COM_STMT_EXECUTE: stmt id 1，两个参数 (BIGINT 5, VARCHAR "a'b")
+	execute := make([]byte, 4)
+	binary.LittleEndian.PutUint32(execute, 1)
+	execute = append(execute, 0, 1, 0, 0, 0, 0x00, 1, 0x08, 0x00, 0xfd, 0x00)
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, 5)
+	execute = append(execute, value...)
+	execute = append(execute, 3, 'a', '\'', 'b')
+
+	// 轮转后的文件为 gzip 压缩，当前文件为 traffic.log
+	rotated := tiproxyRecord("2024-08-29T17:37:12.000+08:00", "1", "Init DB", true, []byte("shop")) +
+		tiproxyRecord("2024-08-29T17:37:12.100+08:00", "1", "", true, []byte("select 1")) +
+		tiproxyRecord("2024-08-29T17:37:12.200+08:00", "1", "Prepare", false, []byte("selec ?")) +
+		tiproxyRecord("2024-08-29T17:37:12.300+08:00", "1", "Prepare", true, []byte("select * from t where id = ? and c = '?' and d = ?"))
+	current := tiproxyRecord("2024-08-29T17:37:13.000+08:00", "1", "Execute", true, execute) +
+		tiproxyRecord("2024-08-29T17:37:13.100+08:00", "2", "", true, []byte("update t\nset c = 1")) +
+		tiproxyRecord("2024-08-29T17:37:13.150+08:00", "2", "", false, []byte("insert into t values (1)")) +
+		tiproxyRecord("2024-08-29T17:37:13.200+08:00", "1", "Close stmt", true, execute[:4]) +
+		tiproxyRecord("2024-08-29T17:37:13.300+08:00", "1", "Execute", true, execute) +
+		tiproxyRecord("2024-08-29T17:37:13.400+08:00", "1", "Quit", true, nil)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(rotated))
+	w.Close()
+	if err := os.WriteFile(filepath.Join(dir, "traffic-2024-08-29T17-37-12.500.log.gz"), gz.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "traffic.log"), []byte(current), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+
+	outputPath := filepath.Join(dir, "output.json")
+	ParseTiProxyCapture(dir, outputPath)
+
+	outputFile, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open output file: %v", err)
+	}
+	defer outputFile.Close()
+	var actual []LogEntry
+	scanner := bufio.NewScanner(outputFile)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON: %v", err)
+		}
+		actual = append(actual, entry)
+	}
+
+	expected := []LogEntry{
+		{ConnectionID: "1", DBName: "shop", SQL: "select 1", SQLType: "select", Timestamp: 1724924232.1},
+		{ConnectionID: "1", DBName: "shop", SQL: "select * from t where id = 5 and c = '?' and d = 'a\\'b'", SQLType: "select", Timestamp: 1724924233},
+		{ConnectionID: "2", SQL: "update t\nset c = 1", SQLType: "update", Timestamp: 1724924233.1},
+		{ConnectionID: "2", SQL: "insert into t values (1)", SQLType: "insert", Timestamp: 1724924233.15, ErrorCode: tiproxyErrUnknown},
+		{ConnectionID: "1", DBName: "shop", Event: EventQuit, Timestamp: 1724924233.4},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Output length does not match expected length.\nActual: %+v\nExpected: %+v", actual, expected)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.ConnectionID != e.ConnectionID || a.DBName != e.DBName || a.SQL != e.SQL ||
+			a.SQLType != e.SQLType || a.Event != e.Event || a.ErrorCode != e.ErrorCode || !floatEquals(a.Timestamp, e.Timestamp) {
+			t.Errorf("Output does not match expected output at index %d.\nActual: %+v\nExpected: %+v", i, a, e)
+		}
+	}
+	// 预编译语句的模板和带类型的参数单独保存
+	args := actual[1].StmtArgs
+	if actual[1].StmtSQL != "select * from t where id = ? and c = '?' and d = ?" || len(args) != 2 ||
+		args[0] != (StmtArg{Type: ArgInt, Value: "5"}) || args[1] != (StmtArg{Type: ArgString, Value: "a'b"}) {
+		t.Errorf("Unexpected prepared statement: %q %+v", actual[1].StmtSQL, args)
+	}
+	if actual[0].StmtSQL != "" || actual[0].StmtArgs != nil {
+		t.Errorf("Unexpected prepared statement for a query: %+v", actual[0])
+	}
+}
+
+func TestTiProxyFailedStatementInTxn(t *testing.T) {
+	var entries []*LogEntry
+	c := newTiProxyConverter(func(entry *LogEntry) { entries = append(entries, entry) })
+	for _, q := range []struct {
+		sql     string
+		success bool
+	}{
+		{"begin", true},
+		{"insert into t values (1)", false},
+		{"update t set c = 2 where id = 1", true},
+		{"commit", true},
+	} {
+		c.Command(&tiproxyCommand{ConnID: "1", Type: "Query", Success: q.success, Payload: []byte(q.sql)})
+	}
+	if len(entries) != 4 || entries[1].ErrorCode == 0 {
+		t.Fatalf("Expected the failed insert to keep an error code, got %+v", entries)
+	}
+
+	// 源端同样失败的语句不会中止回放的事务
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}
+	txn := newTxnTracker("1")
+	for _, entry := range entries {
+		action := classifyTxnStatement(entry.SQL)
+		if _, skip := txn.begin(action); skip {
+			t.Fatalf("%q skipped, the source committed the transaction", entry.SQL)
+		}
+		var err error
+		if entry.ErrorCode != 0 {
+			err = duplicate
+		}
+		if txn.end(action, abortsTxn(err, entry.ErrorCode)) {
+			t.Fatalf("%q aborted the transaction", entry.SQL)
+		}
+	}
+}
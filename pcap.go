@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// capturedPacket is one frame read from a libpcap or pcapng file.
+type capturedPacket struct {
+	Timestamp time.Time
+	LinkType  uint32
+	Data      []byte
+}
+
+// pcapReader reads frames from libpcap and pcapng files.
+type pcapReader struct {
+	r      *bufio.Reader
+	ng     bool
+	order  binary.ByteOrder
+	nano   bool
+	link   uint32
+	ifaces []pcapngInterface
+}
+
+type pcapngInterface struct {
+	linkType uint32
+	tsUnit   time.Duration // duration of one timestamp tick
+}
+
+const (
+	pcapMagicMicro  = 0xa1b2c3d4
+	pcapMagicNano   = 0xa1b23c4d
+	pcapngBlockSHB  = 0x0a0d0d0a
+	pcapngBlockIDB  = 0x00000001
+	pcapngBlockOPB  = 0x00000002
+	pcapngBlockSPB  = 0x00000003
+	pcapngBlockEPB  = 0x00000006
+	pcapngByteMagic = 0x1a2b3c4d
+)
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	br := bufio.NewReaderSize(r, 1024*1024)
+	head, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("read capture header: %w", err)
+	}
+	pr := &pcapReader{r: br}
+	if binary.LittleEndian.Uint32(head) == pcapngBlockSHB {
+		pr.ng = true
+		return pr, nil
+	}
+
+	var hdr [24]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, fmt.Errorf("read pcap header: %w", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(hdr[:]) == pcapMagicMicro:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr[:]) == pcapMagicMicro:
+		pr.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(hdr[:]) == pcapMagicNano:
+		pr.order, pr.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr[:]) == pcapMagicNano:
+		pr.order, pr.nano = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a libpcap or pcapng file")
+	}
+	pr.link = pr.order.Uint32(hdr[20:])
+	return pr, nil
+}
+
+// Next returns the next captured frame, io.EOF at the end of the file.
+func (pr *pcapReader) Next() (capturedPacket, error) {
+	if pr.ng {
+		return pr.nextNG()
+	}
+	var hdr [16]byte
+	if _, err := io.ReadFull(pr.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return capturedPacket{}, io.EOF
+		}
+		return capturedPacket{}, err
+	}
+	sec := int64(pr.order.Uint32(hdr[0:]))
+	frac := int64(pr.order.Uint32(hdr[4:]))
+	capLen := pr.order.Uint32(hdr[8:])
+	if capLen > 256*1024*1024 {
+		return capturedPacket{}, fmt.Errorf("invalid pcap record length %d", capLen)
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		return capturedPacket{}, io.EOF
+	}
+	if !pr.nano {
+		frac *= 1000
+	}
+	return capturedPacket{Timestamp: time.Unix(sec, frac), LinkType: pr.link, Data: data}, nil
+}
+
+func (pr *pcapReader) nextNG() (capturedPacket, error) {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(pr.r, hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return capturedPacket{}, io.EOF
+			}
+			return capturedPacket{}, err
+		}
+		blockType := binary.LittleEndian.Uint32(hdr[:])
+		if blockType == pcapngBlockSHB {
+			// The byte order magic decides how the rest of the section is read.
+			var magic [4]byte
+			if _, err := io.ReadFull(pr.r, magic[:]); err != nil {
+				return capturedPacket{}, io.EOF
+			}
+			if binary.LittleEndian.Uint32(magic[:]) == pcapngByteMagic {
+				pr.order = binary.LittleEndian
+			} else {
+				pr.order = binary.BigEndian
+			}
+			total := pr.order.Uint32(hdr[4:])
+			if total < 16 {
+				return capturedPacket{}, fmt.Errorf("invalid pcapng section length %d", total)
+			}
+			if _, err := pr.r.Discard(int(total) - 12); err != nil {
+				return capturedPacket{}, io.EOF
+			}
+			pr.ifaces = nil
+			continue
+		}
+		if pr.order == nil {
+			return capturedPacket{}, errors.New("pcapng block before section header")
+		}
+		blockType = pr.order.Uint32(hdr[:])
+		total := pr.order.Uint32(hdr[4:])
+		if total < 12 || total > 256*1024*1024 {
+			return capturedPacket{}, fmt.Errorf("invalid pcapng block length %d", total)
+		}
+		body := make([]byte, total-12)
+		if _, err := io.ReadFull(pr.r, body); err != nil {
+			return capturedPacket{}, io.EOF
+		}
+		if _, err := pr.r.Discard(4); err != nil {
+			return capturedPacket{}, io.EOF
+		}
+
+		switch blockType {
+		case pcapngBlockIDB:
+			if len(body) < 8 {
+				continue
+			}
+			iface := pcapngInterface{linkType: uint32(pr.order.Uint16(body)), tsUnit: time.Microsecond}
+			pr.parseIfaceOptions(body[8:], &iface)
+			pr.ifaces = append(pr.ifaces, iface)
+		case pcapngBlockEPB:
+			if len(body) < 20 {
+				continue
+			}
+			id := pr.order.Uint32(body)
+			ts := uint64(pr.order.Uint32(body[4:]))<<32 | uint64(pr.order.Uint32(body[8:]))
+			capLen := pr.order.Uint32(body[12:])
+			if int(id) >= len(pr.ifaces) || uint32(len(body)-20) < capLen {
+				continue
+			}
+			return pr.ngPacket(pr.ifaces[id], ts, body[20:20+capLen]), nil
+		case pcapngBlockOPB:
+			if len(body) < 20 {
+				continue
+			}
+			id := pr.order.Uint16(body)
+			ts := uint64(pr.order.Uint32(body[4:]))<<32 | uint64(pr.order.Uint32(body[8:]))
+			capLen := pr.order.Uint32(body[12:])
+			if int(id) >= len(pr.ifaces) || uint32(len(body)-20) < capLen {
+				continue
+			}
+			return pr.ngPacket(pr.ifaces[id], ts, body[20:20+capLen]), nil
+		case pcapngBlockSPB:
+			// Simple packet blocks carry no timestamp and are not usable
+			// for replay timing.
+			continue
+		}
+	}
+}
+
+func (pr *pcapReader) parseIfaceOptions(opts []byte, iface *pcapngInterface) {
+	for len(opts) >= 4 {
+		code := pr.order.Uint16(opts)
+		length := int(pr.order.Uint16(opts[2:]))
+		if code == 0 || len(opts) < 4+length {
+			return
+		}
+		if code == 9 && length >= 1 { // if_tsresol
+			v := opts[4]
+			var unit float64
+			if v&0x80 != 0 {
+				unit = 1 / float64(uint64(1)<<(v&0x7f))
+			} else {
+				unit = 1
+				for i := byte(0); i < v; i++ {
+					unit /= 10
+				}
+			}
+			iface.tsUnit = time.Duration(unit * float64(time.Second))
+			if iface.tsUnit <= 0 {
+				iface.tsUnit = time.Nanosecond
+			}
+		}
+		opts = opts[4+(length+3)/4*4:]
+	}
+}
+
+func (pr *pcapReader) ngPacket(iface pcapngInterface, ts uint64, data []byte) capturedPacket {
+	nanos := int64(ts) * int64(iface.tsUnit)
+	return capturedPacket{Timestamp: time.Unix(0, nanos), LinkType: iface.linkType, Data: data}
+}
+
+// tcpSegment is the TCP part of a captured frame.
+type tcpSegment struct {
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+	Seq              uint32
+	SYN, FIN, RST    bool
+	Payload          []byte
+}
+
+// Link types handled by decodeTCP.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeRawAlt1  = 12
+	linkTypeRawAlt2  = 14
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// decodeTCP extracts the TCP segment of a frame. ok is false for frames
+// that are not TCP over IPv4/IPv6 or are truncated.
+func decodeTCP(linkType uint32, data []byte) (tcpSegment, bool) {
+	var ip []byte
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return tcpSegment{}, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		pos := 14
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= pos+4 {
+			etherType = binary.BigEndian.Uint16(data[pos+2:])
+			pos += 4
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return tcpSegment{}, false
+		}
+		ip = data[pos:]
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return tcpSegment{}, false
+		}
+		ip = data[16:]
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return tcpSegment{}, false
+		}
+		ip = data[20:]
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return tcpSegment{}, false
+		}
+		ip = data[4:]
+	case linkTypeRaw, linkTypeRawAlt1, linkTypeRawAlt2, linkTypeIPv4, linkTypeIPv6:
+		ip = data
+	default:
+		return tcpSegment{}, false
+	}
+	return decodeIP(ip)
+}
+
+func decodeIP(ip []byte) (tcpSegment, bool) {
+	var seg tcpSegment
+	if len(ip) < 1 {
+		return seg, false
+	}
+	var tcp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < 20 {
+			return seg, false
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		if ip[9] != 6 || ihl < 20 || total < ihl {
+			return seg, false
+		}
+		// Fragments are not reassembled; MySQL traffic is not fragmented
+		// in practice.
+		if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 {
+			return seg, false
+		}
+		if total > len(ip) {
+			total = len(ip)
+		}
+		seg.SrcIP = net.IP(ip[12:16])
+		seg.DstIP = net.IP(ip[16:20])
+		tcp = ip[ihl:total]
+	case 6:
+		if len(ip) < 40 {
+			return seg, false
+		}
+		next := ip[6]
+		end := 40 + int(binary.BigEndian.Uint16(ip[4:]))
+		if end > len(ip) {
+			end = len(ip)
+		}
+		seg.SrcIP = net.IP(ip[8:24])
+		seg.DstIP = net.IP(ip[24:40])
+		pos := 40
+		// hop-by-hop, routing and destination options headers
+		for (next == 0 || next == 43 || next == 60) && end >= pos+8 {
+			next = ip[pos]
+			pos += (int(ip[pos+1]) + 1) * 8
+		}
+		if next != 6 || pos > end {
+			return seg, false
+		}
+		tcp = ip[pos:end]
+	default:
+		return seg, false
+	}
+
+	if len(tcp) < 20 {
+		return seg, false
+	}
+	offset := int(tcp[12]>>4) * 4
+	if offset < 20 || offset > len(tcp) {
+		return seg, false
+	}
+	seg.SrcPort = binary.BigEndian.Uint16(tcp)
+	seg.DstPort = binary.BigEndian.Uint16(tcp[2:])
+	seg.Seq = binary.BigEndian.Uint32(tcp[4:])
+	flags := tcp[13]
+	seg.FIN = flags&0x01 != 0
+	seg.SYN = flags&0x02 != 0
+	seg.RST = flags&0x04 != 0
+	seg.Payload = tcp[offset:]
+	return seg, true
+}
+
+// tcpReassembler orders the payload of one direction of a TCP connection.
+// Retransmitted bytes are dropped and out-of-order segments are held until
+// the gap is filled.
+type tcpReassembler struct {
+	started bool
+	next    uint32
+	pending map[uint32][]byte
+}
+
+// Add returns the bytes that became contiguous with this segment.
+func (r *tcpReassembler) Add(seg tcpSegment) []byte {
+	if seg.SYN {
+		r.started = true
+		r.next = seg.Seq + 1
+		return nil
+	}
+	if len(seg.Payload) == 0 {
+		return nil
+	}
+	if !r.started {
+		r.started = true
+		r.next = seg.Seq
+	}
+
+	var out []byte
+	seq, payload := seg.Seq, seg.Payload
+	for {
+		diff := int32(seq - r.next)
+		switch {
+		case diff > 0:
+			// future segment, keep it until the gap is filled
+			if r.pending == nil {
+				r.pending = make(map[uint32][]byte)
+			}
+			if len(payload) > len(r.pending[seq]) {
+				r.pending[seq] = append([]byte(nil), payload...)
+			}
+			return out
+		case -diff >= int32(len(payload)):
+			// retransmission of data already delivered
+		default:
+			payload = payload[-diff:]
+			out = append(out, payload...)
+			r.next += uint32(len(payload))
+		}
+
+		found := false
+		for s, p := range r.pending {
+			if int32(s-r.next) <= 0 {
+				delete(r.pending, s)
+				seq, payload = s, p
+				found = true
+				break
+			}
+		}
+		if !found {
+			return out
+		}
+	}
+}
+
+// Gap reports whether so many segments wait behind a hole that the missing
+// data is assumed to be lost by the capture.
+func (r *tcpReassembler) Gap() bool {
+	return len(r.pending) > 256
+}
+
+// Skip gives up on missing data and continues with the oldest pending
+// segment. It is used at the end of a capture when packets were dropped.
+func (r *tcpReassembler) Skip() []byte {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	var oldest uint32
+	first := true
+	for s := range r.pending {
+		if first || int32(s-oldest) < 0 {
+			oldest = s
+			first = false
+		}
+	}
+	r.next = oldest
+	p := r.pending[oldest]
+	delete(r.pending, oldest)
+	return r.Add(tcpSegment{Seq: oldest, Payload: p})
+}
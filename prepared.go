@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Types of StmtArg.
+const (
+	ArgNull     = "null"
+	ArgInt      = "int"
+	ArgUint     = "uint"
+	ArgFloat    = "float"
+	ArgDecimal  = "decimal"
+	ArgString   = "string"
+	ArgBytes    = "bytes" // base64, for values that are not valid UTF-8
+	ArgDatetime = "datetime"
+	ArgTime     = "time"
+)
+
+// StmtArg is one argument of a prepared statement execution. The value is
+// kept as text, Type says how it is sent when the statement is replayed
+// with server-side prepare.
+type StmtArg struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+// stmtArgs converts decoded COM_STMT_EXECUTE parameters.
+func stmtArgs(params []stmtParam) []StmtArg {
+	args := make([]StmtArg, len(params))
+	for i, param := range params {
+		switch {
+		case param.Null:
+			args[i] = StmtArg{Type: ArgNull}
+		case param.Type == 0x01 || param.Type == 0x02 || param.Type == 0x03 || param.Type == 0x08 ||
+			param.Type == 0x09 || param.Type == 0x0d:
+			args[i] = StmtArg{Type: ArgInt, Value: param.Literal}
+			if param.Unsigned {
+				args[i].Type = ArgUint
+			}
+		case param.Type == 0x04 || param.Type == 0x05:
+			args[i] = StmtArg{Type: ArgFloat, Value: param.Literal}
+		case param.Type == 0x00 || param.Type == 0xf6:
+			args[i] = StmtArg{Type: ArgDecimal, Value: string(param.Value)}
+		case param.Type == 0x07 || param.Type == 0x0a || param.Type == 0x0c:
+			args[i] = StmtArg{Type: ArgDatetime, Value: strings.Trim(param.Literal, "'")}
+		case param.Type == 0x0b:
+			args[i] = StmtArg{Type: ArgTime, Value: strings.Trim(param.Literal, "'")}
+		default:
+			args[i] = newStringArg(param.Value)
+		}
+	}
+	return args
+}
+
+func newStringArg(value []byte) StmtArg {
+	if utf8.Valid(value) {
+		return StmtArg{Type: ArgString, Value: string(value)}
+	}
+	return StmtArg{Type: ArgBytes, Value: base64.StdEncoding.EncodeToString(value)}
+}
+
+// driverValue returns the value passed to the driver, which picks the
+// binary protocol type from the Go type.
+func (a StmtArg) driverValue() (interface{}, error) {
+	switch a.Type {
+	case ArgNull:
+		return nil, nil
+	case ArgInt:
+		return strconv.ParseInt(a.Value, 10, 64)
+	case ArgUint:
+		return strconv.ParseUint(a.Value, 10, 64)
+	case ArgFloat:
+		return strconv.ParseFloat(a.Value, 64)
+	case ArgBytes:
+		return base64.StdEncoding.DecodeString(a.Value)
+	case ArgDecimal, ArgString, ArgDatetime, ArgTime:
+		return a.Value, nil
+	}
+	return nil, fmt.Errorf("unknown argument type %q", a.Type)
+}
+
+// stmtCache keeps the statements prepared on one replay session, so every
+// template is prepared once and executed many times, like the application
+// did on the source.
+type stmtCache struct {
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache() *stmtCache {
+	return &stmtCache{stmts: make(map[string]*sql.Stmt)}
+}
+
+// Query executes the template with the arguments through COM_STMT_PREPARE
+// and COM_STMT_EXECUTE.
+func (c *stmtCache) Query(conn *sql.Conn, template string, args []StmtArg) (*sql.Rows, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		value, err := arg.driverValue()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	stmt := c.stmts[template]
+	if stmt == nil {
+		var err error
+		stmt, err = conn.PrepareContext(context.Background(), template)
+		if err != nil {
+			return nil, err
+		}
+		c.stmts[template] = stmt
+	}
+	return stmt.QueryContext(context.Background(), values...)
+}
+
+// Close deallocates the statements, before the session is closed.
+func (c *stmtCache) Close() {
+	for template, stmt := range c.stmts {
+		stmt.Close()
+		delete(c.stmts, template)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreparedStatement(t *testing.T) {
+	template, args, _ := preparedStatement(`select * from t where id = ? and c = ? and d = ? and e = ? [arguments: (1, "a", 1.50, 18446744073709551615)]`)
+	if template != "select * from t where id = ? and c = ? and d = ? and e = ?" {
+		t.Errorf("Unexpected template %q", template)
+	}
+	expected := []StmtArg{{Type: ArgInt, Value: "1"}, {Type: ArgString, Value: "a"}, {Type: ArgDecimal, Value: "1.50"},
+		{Type: ArgUint, Value: "18446744073709551615"}}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Unexpected arguments %+v", args)
+	}
+	// 单个参数时没有括号
+	if template, args, _ := preparedStatement("select ? [arguments: abc]"); template != "select ?" ||
+		!reflect.DeepEqual(args, []StmtArg{{Type: ArgString, Value: "abc"}}) {
+		t.Errorf("Unexpected result %q %+v", template, args)
+	}
+	// TiDB 原样写出字符串参数：引号内的逗号不拆分参数，反斜杠与引号不是转义
+	template, args, ok := preparedStatement(`insert into t values (?, ?, ?, ?) [arguments: ("x,y", 2, "C:\dir", "say "hi" now")]`)
+	expected = []StmtArg{{Type: ArgString, Value: "x,y"}, {Type: ArgInt, Value: "2"}, {Type: ArgString, Value: `C:\dir`},
+		{Type: ArgString, Value: `say "hi" now`}}
+	if template != "insert into t values (?, ?, ?, ?)" || !ok || !reflect.DeepEqual(args, expected) {
+		t.Errorf("Unexpected result %q %+v", template, args)
+	}
+	// 超过 2048 字节的参数被截断并追加 len(N)，不能以预编译方式回放
+	truncated := `insert into t values (?, ?) [arguments: ("` + strings.Repeat("a", 2048) + `" len(5000), 1)]`
+	if _, args, ok := preparedStatement(truncated); ok || args != nil {
+		t.Errorf("Expected truncated arguments to be rejected, got %+v", args)
+	}
+	entry := LogEntry{}
+	if setPreparedStatement(&entry, truncated) || entry.StmtSQL != "" || entry.StmtArgs != nil {
+		t.Errorf("Expected no prepared statement, got %+v", entry)
+	}
+	if sql := formatSQL(`insert into t values (?, ?) [arguments: ("x,y", 2)]`); sql != "insert into t values ('x,y', 2) " {
+		t.Errorf("Unexpected inlined statement %q", sql)
+	}
+}
+
+func TestStmtArgs(t *testing.T) {
+	params := []stmtParam{
+		{Type: 0x08, Unsigned: true, Literal: "18446744073709551615"},
+		{Type: 0x03, Literal: "-1"},
+		{Type: 0x06, Null: true, Literal: "NULL"},
+		{Type: 0x05, Literal: "1.5"},
+		{Type: 0xf6, Value: []byte("1.50"), Literal: "1.50"},
+		{Type: 0x0c, Literal: "'2024-01-19 16:29:48'"},
+		{Type: 0xfd, Value: []byte("a'b"), Literal: `'a\'b'`},
+		{Type: 0xfc, Value: []byte{0xff, 0x00}, Literal: `'\xff\0'`},
+	}
+	args := stmtArgs(params)
+	expected := []StmtArg{{Type: ArgUint, Value: "18446744073709551615"}, {Type: ArgInt, Value: "-1"}, {Type: ArgNull},
+		{Type: ArgFloat, Value: "1.5"}, {Type: ArgDecimal, Value: "1.50"}, {Type: ArgDatetime, Value: "2024-01-19 16:29:48"},
+		{Type: ArgString, Value: "a'b"}, {Type: ArgBytes, Value: "/wA="}}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Unexpected arguments %+v", args)
+	}
+
+	// 回放时按类型转换为驱动参数
+	values := []interface{}{uint64(18446744073709551615), int64(-1), nil, 1.5, "1.50", "2024-01-19 16:29:48", "a'b", []byte{0xff, 0x00}}
+	for i, arg := range args {
+		value, err := arg.driverValue()
+		if err != nil || !reflect.DeepEqual(value, values[i]) {
+			t.Errorf("driverValue(%+v) = %#v, %v", arg, value, err)
+		}
+	}
+	if _, err := (StmtArg{Type: "point"}).driverValue(); err == nil {
+		t.Errorf("Expected an error for an unknown type")
+	}
+}
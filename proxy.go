@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Capabilities removed from the server greeting so that clients stay on a
+// protocol the proxy can decode.
+const proxyStrippedCapabilities = clientSSL | 0x00000020 // CLIENT_COMPRESS
+
+// StartCaptureProxy listens as a MySQL proxy in front of upstreamAddr and
+// records every statement that passes through it as a replay entry.
+func StartCaptureProxy(listenAddr, upstreamAddr, outputPath string) {
+	if listenAddr == "" || upstreamAddr == "" || outputPath == "" {
+		fmt.Println("Usage: ./sql-replay -mode capture-proxy -listen <listen_address> -upstream <mysql_host:port> -slow-out <path_to_slow_output_file>")
+		return
+	}
+
+	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Error creating output file:", err)
+		return
+	}
+	defer outputFile.Close()
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		fmt.Println("Error listening:", err)
+		return
+	}
+
+	proxy := newCaptureProxy(upstreamAddr, outputFile)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Printf("[%s] Capture proxy listening on %s, forwarding to %s\n", time.Now().Format("2006-01-02 15:04:05.000"), listenAddr, upstreamAddr)
+	proxy.Serve(listener)
+	proxy.Close()
+	fmt.Printf("[%s] Capture proxy stopped, %d statements and %d connection events written to %s\n", time.Now().Format("2006-01-02 15:04:05.000"), proxy.statements, proxy.events, outputPath)
+}
+
+// captureProxy forwards client connections to the upstream server and
+// decodes the traffic of each of them.
+type captureProxy struct {
+	upstreamAddr string
+
+	mu         sync.Mutex
+	out        *bufio.Writer
+	statements int
+	events     int
+
+	conns  sync.WaitGroup
+	active map[net.Conn]struct{}
+	done   chan struct{}
+}
+
+func newCaptureProxy(upstreamAddr string, out io.Writer) *captureProxy {
+	p := &captureProxy{
+		upstreamAddr: upstreamAddr,
+		out:          bufio.NewWriter(out),
+		active:       make(map[net.Conn]struct{}),
+		done:         make(chan struct{}),
+	}
+	go p.flushLoop()
+	return p
+}
+
+// Serve accepts client connections until the listener is closed.
+func (p *captureProxy) Serve(listener net.Listener) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.active[client] = struct{}{}
+		p.mu.Unlock()
+		p.conns.Add(1)
+		go func() {
+			defer p.conns.Done()
+			p.handle(client)
+			p.mu.Lock()
+			delete(p.active, client)
+			p.mu.Unlock()
+		}()
+	}
+}
+
+// Close ends the open sessions and flushes the output.
+func (p *captureProxy) Close() {
+	p.mu.Lock()
+	for conn := range p.active {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.conns.Wait()
+	close(p.done)
+	p.mu.Lock()
+	p.out.Flush()
+	p.mu.Unlock()
+}
+
+func (p *captureProxy) flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.out.Flush()
+			p.mu.Unlock()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *captureProxy) emit(entry *LogEntry) {
+	if entry.Event == "" {
+		setDigestAndType(entry)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := writeLogEntry(p.out, entry); err != nil {
+		fmt.Println("Error writing output:", err)
+		return
+	}
+	if entry.Event == "" {
+		p.statements++
+	} else {
+		p.events++
+	}
+}
+
+func (p *captureProxy) handle(client net.Conn) {
+	defer client.Close()
+	server, err := net.Dial("tcp", p.upstreamAddr)
+	if err != nil {
+		fmt.Printf("Error connecting to upstream %s: %v\n", p.upstreamAddr, err)
+		return
+	}
+	defer server.Close()
+
+	var mu sync.Mutex
+	var broken bool
+	stream := newMySQLStream(client.RemoteAddr().String(), p.emit)
+	stream.expectHandshake()
+	// record runs the decoder. A decoder bug only stops the recording of
+	// this connection, its traffic is still forwarded; the stack is printed
+	// so that it can be reported.
+	record := func(decode func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		if broken {
+			return
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				broken = true
+				fmt.Printf("Stopped recording %s, decoder panic: %v\n%s", client.RemoteAddr(), r, debug.Stack())
+			}
+		}()
+		decode()
+	}
+	tap := func(data []byte, fromClient bool) {
+		record(func() { stream.Write(data, fromClient, time.Now()) })
+	}
+
+	// The greeting is rewritten before anything else is forwarded.
+	greeting, err := readRawPacket(server)
+	if err != nil {
+		return
+	}
+	stripGreetingCapabilities(greeting)
+	tap(greeting, false)
+	if _, err := client.Write(greeting); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Client bytes are decoded before they are forwarded, so a
+		// command is always seen before its response.
+		copyAndTap(server, client, func(data []byte) { tap(data, true) })
+		server.Close()
+	}()
+	copyAndTap(client, server, func(data []byte) { tap(data, false) })
+	client.Close()
+	wg.Wait()
+
+	record(func() { stream.Disconnect(time.Now()) })
+}
+
+func copyAndTap(dst io.Writer, src io.Reader, tap func([]byte)) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			tap(buf[:n])
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readRawPacket reads one MySQL packet including its header.
+func readRawPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	packet := make([]byte, 4+length)
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[4:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// stripGreetingCapabilities clears the capabilities the proxy cannot decode
+// in a raw server greeting packet.
+func stripGreetingCapabilities(packet []byte) {
+	payload := packet[4:]
+	if len(payload) < 1 || payload[0] != 10 {
+		return
+	}
+	_, n := readNullString(payload[1:])
+	pos := 1 + n + 4 + 8 + 1
+	if len(payload) < pos+2 {
+		return
+	}
+	caps := binary.LittleEndian.Uint16(payload[pos:])
+	binary.LittleEndian.PutUint16(payload[pos:], caps&^uint16(proxyStrippedCapabilities))
+}
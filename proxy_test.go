@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeMySQLServer 模拟一个只处理单个连接的 MySQL 服务端
+func fakeMySQLServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		greeting := []byte{10}
+		greeting = append(greeting, []byte("8.0.36\x00")...)
+		greeting = append(greeting, 99, 0, 0, 0)
+		greeting = append(greeting, []byte("abcdefgh\x00")...)
+		greeting = append(greeting, 0xff, 0xff, 0x21, 0x02, 0x00, 0xff, 0x01)
+		conn.Write(mysqlPacketBytes(0, greeting))
+		if _, err := readRawPacket(conn); err != nil {
+			return
+		}
+		conn.Write(mysqlPacketBytes(2, []byte{0, 0, 0, 2, 0, 0, 0}))
+
+		for {
+			packet, err := readRawPacket(conn)
+			if err != nil {
+				return
+			}
+			switch packet[4] {
+			case comQuit:
+				return
+			case comQuery:
+				if strings.HasPrefix(string(packet[5:]), "SELECT") {
+					conn.Write(concatBytes(
+						mysqlPacketBytes(1, []byte{1}),
+						mysqlPacketBytes(2, []byte("\x03def\x00\x00\x00\x01a\x00\x0c\x21\x00\x01\x00\x00\x00\x08\x00\x00\x00\x00\x00")),
+						mysqlPacketBytes(3, []byte{0xfe, 0, 0, 2, 0}),
+						mysqlPacketBytes(4, []byte("\x011")),
+						mysqlPacketBytes(5, []byte{0xfe, 0, 0, 2, 0}),
+					))
+				} else {
+					conn.Write(mysqlPacketBytes(1, append([]byte{0xff, 0x28, 0x04}, "#42000You have an error in your SQL syntax"...)))
+				}
+			}
+		}
+	}()
+	return listener
+}
+
+func TestCaptureProxy(t *testing.T) {
+	upstream := fakeMySQLServer(t)
+	defer upstream.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	var out bytes.Buffer
+	proxy := newCaptureProxy(upstream.Addr().String(), &out)
+	served := make(chan struct{})
+	go func() {
+		proxy.Serve(listener)
+		close(served)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	greeting, err := readRawPacket(client)
+	if err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+	hs, _ := parseServerHandshake(greeting[4:])
+	if hs.Capabilities&clientSSL != 0 {
+		t.Errorf("Proxy should not offer TLS to clients")
+	}
+
+	resp := make([]byte, 32)
+	binary.LittleEndian.PutUint32(resp, clientProtocol41|clientSecureConnection|clientConnectWithDB)
+	resp = append(resp, []byte("app\x00\x00shop\x00")...)
+	client.Write(mysqlPacketBytes(1, resp))
+	if _, err := readRawPacket(client); err != nil {
+		t.Fatalf("Failed to read auth result: %v", err)
+	}
+
+	client.Write(mysqlPacketBytes(0, append([]byte{comQuery}, "SELECT 1"...)))
+	for i := 0; i < 5; i++ {
+		if _, err := readRawPacket(client); err != nil {
+			t.Fatalf("Failed to read result set: %v", err)
+		}
+	}
+	client.Write(mysqlPacketBytes(0, append([]byte{comQuery}, "SELEC 1"...)))
+	if _, err := readRawPacket(client); err != nil {
+		t.Fatalf("Failed to read error: %v", err)
+	}
+	client.Write(mysqlPacketBytes(0, []byte{comQuit}))
+	io.Copy(io.Discard, client)
+	client.Close()
+
+	listener.Close()
+	<-served
+	proxy.Close()
+
+	var actual []LogEntry
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal JSON %q: %v", line, err)
+		}
+		actual = append(actual, entry)
+	}
+	if len(actual) != 4 || actual[0].Event != EventConnect || actual[3].Event != EventQuit || actual[3].ConnectionID != "99" {
+		t.Fatalf("Expected 2 entries between connect and quit, got %v", actual)
+	}
+	actual = actual[1:3]
+	if actual[0].ConnectionID != "99" || actual[0].Username != "app" || actual[0].DBName != "shop" ||
+		actual[0].SQL != "SELECT 1" || actual[0].RowsSent != 1 || actual[0].ErrorCode != 0 || actual[0].Timestamp == 0 {
+		t.Errorf("Unexpected first entry: %+v", actual[0])
+	}
+	if actual[1].SQL != "SELEC 1" || actual[1].ErrorCode != 1064 {
+		t.Errorf("Unexpected second entry: %+v", actual[1])
+	}
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
 	"strings"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 type SQLExecutionRecord struct {
@@ -22,6 +25,11 @@ type SQLExecutionRecord struct {
     ErrorInfo     string `json:"error_info,omitempty"`
     FileName      string // File name
     DBName        string `json:"dbname"`
+    TxnID         string `json:"txn_id,omitempty"`
+    // RewrittenSQL is the statement sent when rewrite rules changed SQL,
+    // RewriteRules the names of those rules.
+    RewrittenSQL  string `json:"rewritten_sql,omitempty"`
+    RewriteRules  string `json:"rewrite_rules,omitempty"`
 }
 
 type LogEntry struct {
@@ -34,11 +42,57 @@ type LogEntry struct {
 	DBName       string  `json:"dbname"`
 	Timestamp    float64 `json:"ts"`
 	Digest       string  `json:"digest"`
+	ErrorCode    int     `json:"error_code,omitempty"`
+	Event        string  `json:"event,omitempty"`
+	TxnStartTS   uint64  `json:"txn_start_ts,omitempty"`
+	// StartTime is when the statement started, in Unix seconds, for sources
+	// that log it when it finished (slow logs). Others log the start in ts.
+	StartTime float64 `json:"start_time,omitempty"`
+	// Template and arguments of a prepared statement execution, SQL has
+	// the arguments inlined.
+	StmtSQL  string    `json:"stmt_sql,omitempty"`
+	StmtArgs []StmtArg `json:"stmt_args,omitempty"`
 }
 
+// SQLTask carries one captured statement together with the pinned session
+// of its ConnectionID, so every statement of a source session runs on the
+// same physical connection and keeps its session state.
 type SQLTask struct {
 	Entry LogEntry
-	DB    *sql.DB
+	Conn  *sql.Conn
+	Txn   *txnTracker
+	Stmts *stmtCache // nil unless prepared statements are replayed as such
+	// OriginalSQL is the captured statement when rewrite rules changed
+	// Entry.SQL, RewriteRules the rules that did.
+	OriginalSQL  string
+	RewriteRules []string
+}
+
+// ReplayOptions changes how the statements are executed on the target.
+type ReplayOptions struct {
+	// Prepared executes the prepared statement executions of the source
+	// with COM_STMT_PREPARE/COM_STMT_EXECUTE instead of the text protocol.
+	Prepared bool
+	// Charset is the character set of the captured SQL text. Replay
+	// sessions use it (SET NAMES) so non-UTF-8 literals are read as on the
+	// source.
+	Charset string
+	// CredentialsFile maps the source users to target accounts, see
+	// loadCredentials. Sessions of other users connect with the -db DSN.
+	CredentialsFile string
+	credentials     *credentialMap
+	// SchemaMapFile renames source databases on the target, see
+	// loadSchemaMap.
+	SchemaMapFile string
+	schemas       *schemaMap
+	// RewriteFile holds rules rewriting statements before they are
+	// replayed, see loadRewriteRules.
+	RewriteFile string
+	rewrites    *rewriter
+	// RoutesFile sends the statements to several targets, see loadRoutes.
+	// Statements no route matches go to the -db DSN.
+	RoutesFile string
+	routes     *router
 }
 
 var i18n *I18n
@@ -51,26 +105,64 @@ func init() {
 	}
 }
 
+// errSessionLost is returned, after the statement was recorded, when the
+// target dropped the session (wait_timeout, KILL, failover).
+var errSessionLost = errors.New("target session lost")
+
+// isSessionLost reports whether err means the target dropped the session.
+func isSessionLost(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
+
 func ExecuteSQLAndRecord(task SQLTask, baseReplayOutputFilePath string) error {
-	if task.DB == nil {
+	if task.Conn == nil {
 		return fmt.Errorf("database connection is nil")
 	}
-	startTime := time.Now()
+	action := classifyTxnStatement(task.Entry.SQL)
+	txnID, skip := task.Txn.begin(action)
 
-	rows, err := task.DB.Query(task.Entry.SQL)
 	var rowsReturned int64
 	var errorInfo string
+	var executionTime int64
+	var lost bool
+	var execErr error
 
-	if err != nil {
-		errorInfo = err.Error()
+	if skip {
+		errorInfo = fmt.Sprintf("skipped: transaction %s was rolled back after an earlier error", txnID)
 	} else {
-		for rows.Next() {
-			rowsReturned++
+		startTime := time.Now()
+
+		var rows *sql.Rows
+		var err error
+		if task.Stmts != nil && task.Entry.StmtSQL != "" {
+			rows, err = task.Stmts.Query(task.Conn, task.Entry.StmtSQL, task.Entry.StmtArgs)
+		} else {
+			rows, err = task.Conn.QueryContext(context.Background(), task.Entry.SQL)
 		}
-		rows.Close()
+		if err != nil {
+			execErr = err
+			errorInfo = err.Error()
+			lost = isSessionLost(err)
+		} else {
+			for rows.Next() {
+				rowsReturned++
+			}
+			rows.Close()
+		}
+
+		executionTime = time.Since(startTime).Microseconds()
 	}
 
-	executionTime := time.Since(startTime).Microseconds()
+	if task.Txn.end(action, abortsTxn(execErr, task.Entry.ErrorCode)) {
+		// Keep the target consistent with the source: the rest of the
+		// transaction is skipped, so undo what already ran. When that
+		// fails the target may keep part of the transaction, it is
+		// recorded with the statement.
+		if _, err := task.Conn.ExecContext(context.Background(), "ROLLBACK"); err != nil {
+			errorInfo += "; rollback failed: " + err.Error()
+			lost = lost || isSessionLost(err)
+		}
+	}
 
 	record := SQLExecutionRecord{
 		SQL:           task.Entry.SQL,
@@ -80,6 +172,12 @@ func ExecuteSQLAndRecord(task SQLTask, baseReplayOutputFilePath string) error {
 		ExecutionTime: executionTime,
 		RowsReturned:  rowsReturned,
 		ErrorInfo:     errorInfo,
+		TxnID:         txnID,
+	}
+	if task.OriginalSQL != "" {
+		record.SQL = task.OriginalSQL
+		record.RewrittenSQL = task.Entry.SQL
+		record.RewriteRules = strings.Join(task.RewriteRules, ",")
 	}
 
 	jsonData, err := json.Marshal(record)
@@ -98,8 +196,13 @@ func ExecuteSQLAndRecord(task SQLTask, baseReplayOutputFilePath string) error {
 	if err != nil {
 		return err
 	}
-	_, err = file.WriteString("\n")
-	return err
+	if _, err = file.WriteString("\n"); err != nil {
+		return err
+	}
+	if lost {
+		return fmt.Errorf("%w: %s", errSessionLost, errorInfo)
+	}
+	return nil
 }
 
 func ParseLogEntries(slowOutputPath, filterUsername, filterSQLType, filterDBName string, ignoreDigestList []string) (map[string][]LogEntry, float64, error) {
@@ -117,45 +220,80 @@ func ParseLogEntries(slowOutputPath, filterUsername, filterSQLType, filterDBName
 
         defer logFile.Close()
 
-	scanner := bufio.NewScanner(inputFile)
-	buf := make([]byte, 0, 512*1024*1024) // 512MB buffer
-	scanner.Buffer(buf, bufio.MaxScanTokenSize)
+	reader := newLineReader(inputFile, readerConfig.MaxLineSize)
+	stats := newParseStats(readerConfig.QuarantinePath)
+	defer stats.Close()
 
 	tasksMap := make(map[string][]LogEntry)
 	var minTimestamp float64 = 9999999999.999999
 
-	for scanner.Scan() {
+	for {
+		line, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != errLineTooLong {
+			return nil, 0, fmt.Errorf("file read error: %w", err)
+		}
+		if err == nil && strings.TrimSpace(line) == "" {
+			continue
+		}
+		stats.Read++
+		if err == errLineTooLong {
+			stats.Quarantine(slowOutputPath, reader.LineNo(), "line too long", line)
+			continue
+		}
+
 		var entry LogEntry
-		if err := json.Unmarshal([]byte(scanner.Text()), &entry); err != nil {
-			fmt.Println("Error parsing log entry:", err)
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			stats.Quarantine(slowOutputPath, reader.LineNo(), "invalid json", line)
 			continue
 		}
 
-		if filterUsername != "all" && entry.Username != filterUsername {
+		// Connection open/close events are kept whatever statements the
+		// filters keep, unless they belong to another user.
+		isEvent := entry.Event != ""
+
+		if filterUsername != "all" && entry.Username != filterUsername && !(isEvent && entry.Username == "") {
+			stats.Skip("username filter")
 			continue
 		}
 
-		if filterSQLType != "all" && entry.SQLType != filterSQLType {
+		if filterSQLType != "all" && entry.SQLType != filterSQLType && !isEvent {
+			stats.Skip("sqltype filter")
 			continue
 		}
 
-		if filterDBName != "all" && entry.DBName != filterDBName {
+		if filterDBName != "all" && entry.DBName != filterDBName && !isEvent {
+			stats.Skip("dbname filter")
 			continue
 		}
-		if contains(ignoreDigestList, entry.Digest) { // ignore input digests
+		if contains(ignoreDigestList, entry.Digest) && !isEvent { // ignore input digests
 			fmt.Fprintf(logFile, "%s, %s\n", entry.Digest,entry.SQL)
+			stats.Skip("ignored digest")
 			continue
 		}
 		tasksMap[entry.ConnectionID] = append(tasksMap[entry.ConnectionID], entry)
+		stats.Emitted++
 
-		if entry.Timestamp < minTimestamp {
-			minTimestamp = entry.Timestamp
+		if entry.startTime() < minTimestamp {
+			minTimestamp = entry.startTime()
 		}
 	}
 
+	fmt.Printf("Replay file read: %s\n", stats)
 	return tasksMap, minTimestamp, nil
 }
 
+// startTime returns when the statement started on the source, which is
+// when it is replayed.
+func (e LogEntry) startTime() float64 {
+	if e.StartTime != 0 {
+		return e.StartTime
+	}
+	return e.Timestamp
+}
+
 func contains(slice []string, item string) bool {
 	for _, v := range slice {
 		if v == item {
@@ -165,34 +303,304 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-func ReplaySQLForConnection(connID string, entries []LogEntry, dbConnStr string, replayOutputFilePath string, minTimestamp float64, speed float64, lang string) {
-	db, err := sql.Open("mysql", dbConnStr)
+// replayDriver is the database/sql driver of the replay sessions.
+var replayDriver = "mysql"
+
+// targetSession is the pinned session of a replayed connection on one
+// target. Without -routes every connection has one, on the -db DSN.
+type targetSession struct {
+	db        *sql.DB
+	dsn       string
+	conn      *sql.Conn
+	currentDB string
+	stmts     *stmtCache // nil unless prepared statements are replayed as such
+}
+
+// open logs in with dsn. The DSN of a session depends on the user of the
+// entry that opens it, db is reopened when a new session of the source
+// changes it.
+func (s *targetSession) open(dsn string, opts ReplayOptions) error {
+	if s.db == nil || dsn != s.dsn {
+		if s.db != nil {
+			s.db.Close()
+		}
+		var err error
+		s.db, err = sql.Open(replayDriver, replayDSN(dsn, opts))
+		if err != nil {
+			s.db = nil
+			return err
+		}
+		// A closed session really disconnects instead of going back to
+		// the pool.
+		s.db.SetMaxIdleConns(0)
+		s.dsn = dsn
+	}
+	var err error
+	s.conn, err = s.db.Conn(context.Background())
 	if err != nil {
-		fmt.Printf(i18n.T(lang, "db_open_error")+"\n", connID, err)
-		return
+		s.conn = nil
+		return err
+	}
+	s.currentDB = defaultDatabase(dsn)
+	return nil
+}
+
+func (s *targetSession) close() {
+	if s.stmts != nil {
+		s.stmts.Close()
+	}
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func ReplaySQLForConnection(connID string, entries []LogEntry, dbConnStr string, replayOutputFilePath string, minTimestamp float64, speed float64, lang string, opts ReplayOptions) {
+	// Pin one physical connection per target for the whole captured
+	// session. It is acquired lazily so a target that is briefly
+	// unreachable is retried on the next statement instead of dropping the
+	// session, and a session the target dropped is opened again at the next
+	// statement. Connect and quit events of the source open and close it at
+	// the same times.
+	sessions := make(map[string]*targetSession)
+	txn := newTxnTracker(connID)
+	// current is the target of the previous statement, participants the
+	// targets the open transaction ran on.
+	var current string
+	participants := make(map[string]bool)
+	// setup holds the session statements (SET, USE) of the source session
+	// that name no table. With -routes they run on every session of the
+	// connection, so all targets see the same session state.
+	var setup []string
+	closeSessions := func() {
+		for _, s := range sessions {
+			s.close()
+		}
+		txn.reset()
+		participants = make(map[string]bool)
+		setup = nil
+	}
+	defer func() {
+		closeSessions()
+		for _, s := range sessions {
+			if s.db != nil {
+				s.db.Close()
+			}
+		}
+	}()
+	// runOn sends a statement the source did not run there to the session
+	// on target t. A session lost meanwhile is opened again at its next
+	// statement.
+	runOn := func(t, stmt string) bool {
+		s := sessions[t]
+		if s == nil || s.conn == nil {
+			return false
+		}
+		if _, err := s.conn.ExecContext(context.Background(), stmt); err != nil {
+			name := t
+			if name == "" {
+				name = "-db"
+			}
+			fmt.Printf(i18n.T(lang, "target_sync_error")+"\n", connID, name, stmt, err)
+			if isSessionLost(err) {
+				s.close()
+			}
+			return false
+		}
+		return true
+	}
+	// share runs a session statement of the source on the session on
+	// target t. The current database of the session is unknown after a
+	// USE, the next statement switches it again.
+	share := func(t, stmt string) {
+		if runOn(t, stmt) && sessionStatement(stmt) == "use" {
+			sessions[t].currentDB = ""
+		}
+	}
+	session := func(target string, entry LogEntry) *targetSession {
+		s := sessions[target]
+		if s == nil {
+			s = &targetSession{}
+			if opts.Prepared {
+				s.stmts = newStmtCache()
+			}
+			sessions[target] = s
+		}
+		if s.conn == nil {
+			dsn := opts.credentials.DSN(opts.routes.DSN(target, dbConnStr), entry.Username, entry.DBName)
+			if err := s.open(dsn, opts); err != nil {
+				fmt.Printf(i18n.T(lang, "db_open_error")+"\n", connID, err)
+			}
+			for _, stmt := range setup {
+				share(target, stmt)
+			}
+		}
+		return s
+	}
+	// endTransaction ends the open transaction on the targets other than
+	// the one the source ended it on.
+	endTransaction := func(sql, target string) {
+		for t := range participants {
+			if t != target {
+				runOn(t, sql)
+			}
+		}
+		participants = make(map[string]bool)
 	}
-	defer db.Close()
 
-	var prevTimestamp float64 = entries[0].Timestamp - (entries[0].Timestamp - minTimestamp)
-	var lastQueryTime int64 = 0
+	// Every statement is sent at the time it started on the source, relative
+	// to the first statement of the replay, which all connections start
+	// from together. A statement that is late because the previous one ran
+	// longer on the target is sent right away.
+	replayStart := time.Now()
 
 	for _, entry := range entries {
-		interval := (entry.Timestamp - prevTimestamp - float64(lastQueryTime)/1e6) / speed
-		if interval > 0 {
-			sleepDuration := time.Duration(interval * float64(time.Second))
-			time.Sleep(sleepDuration)
+		offset := (entry.startTime() - minTimestamp) / speed
+		if wait := time.Until(replayStart.Add(time.Duration(offset * float64(time.Second)))); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		switch entry.Event {
+		case EventQuit:
+			closeSessions()
+			continue
+		case EventConnect:
+			// a new session of the source, log in again
+			closeSessions()
 		}
-		prevTimestamp = entry.Timestamp
 
-		task := SQLTask{Entry: entry, DB: db}
-		if err := ExecuteSQLAndRecord(task, replayOutputFilePath); err != nil {
+		// Routes match the statement as captured. Statements that name no
+		// table (BEGIN, COMMIT, SET ...) stay with the open transaction.
+		target, named := opts.routes.Target(entry.Username, entry.DBName, entry.SQL)
+		if !named && txn.inTxn && entry.Event == "" {
+			target = current
+		}
+		current = target
+		s := session(target, entry)
+
+		// Follow the source session when it switched its default database.
+		dbName := opts.schemas.Name(entry.DBName)
+		if s.conn != nil && dbName != "" && dbName != s.currentDB {
+			if err := switchDatabase(s.conn, dbName); err != nil {
+				fmt.Printf(i18n.T(lang, "db_switch_error")+"\n", connID, dbName, err)
+			} else {
+				s.currentDB = dbName
+			}
+		}
+		if entry.Event != "" {
+			continue
+		}
+		task := SQLTask{Entry: entry, Conn: s.conn, Txn: txn, Stmts: s.stmts}
+		if opts.rewrites != nil {
+			// rules match the statement as captured, before the schema map
+			sql, rules, replaced := opts.rewrites.Rewrite(entry.SQL, entry.Digest, entry.DBName)
+			if rules != nil {
+				task.OriginalSQL, task.RewriteRules = entry.SQL, rules
+				entry.SQL = sql
+				if replaced {
+					// the captured arguments do not fit the replacement,
+					// it is sent as text
+					entry.StmtSQL, entry.StmtArgs = "", nil
+				} else {
+					entry.StmtSQL, _, _ = opts.rewrites.Rewrite(entry.StmtSQL, entry.Digest, entry.DBName)
+				}
+			}
+		}
+		if opts.schemas != nil {
+			// statements the parser does not understand are sent as they are
+			entry.DBName = dbName
+			entry.SQL, _ = opts.schemas.Rewrite(entry.SQL)
+			entry.StmtSQL, _ = opts.schemas.Rewrite(entry.StmtSQL)
+		}
+		task.Entry = entry
+
+		// A transaction routed to several targets runs on each of them, and
+		// ends on all of them when the source ends it.
+		action := classifyTxnStatement(entry.SQL)
+		wasInTxn := txn.inTxn
+		if wasInTxn && action == txnBegin {
+			endTransaction("COMMIT", target)
+		}
+		if wasInTxn && !txn.aborted && action == txnNone && len(participants) > 0 && !participants[target] && s.conn != nil {
+			if runOn(target, "BEGIN") {
+				participants[target] = true
+			}
+			task.Conn = s.conn
+		}
+		if err := ExecuteSQLAndRecord(task, replayOutputFilePath); errors.Is(err, errSessionLost) {
+			// The open transaction is gone with the session, the next
+			// statement logs in again.
+			fmt.Printf(i18n.T(lang, "session_lost")+"\n", connID, err)
+			endTransaction("ROLLBACK", target)
+			s.close()
+			txn.reset()
+			continue
+		} else if err != nil {
 			fmt.Printf(i18n.T(lang, "sql_exec_error")+"\n", connID, err)
 		}
-		lastQueryTime = entry.QueryTime
+		if opts.routes != nil && !named && entry.ErrorCode == 0 && !txn.aborted && sessionStatement(entry.SQL) != "" {
+			for t := range sessions {
+				if t != target {
+					share(t, entry.SQL)
+				}
+			}
+			// a statement run again replaces its earlier copy
+			for i, stmt := range setup {
+				if stmt == entry.SQL {
+					setup = append(setup[:i], setup[i+1:]...)
+					break
+				}
+			}
+			setup = append(setup, entry.SQL)
+		}
+		if wasInTxn && len(participants) > 0 && (!txn.inTxn || txn.aborted) {
+			if txn.aborted || action == txnRollback {
+				endTransaction("ROLLBACK", target)
+			} else {
+				endTransaction("COMMIT", target)
+			}
+		}
+		if txn.inTxn && !txn.aborted {
+			participants[target] = true
+		}
+	}
+}
+
+// defaultDatabase returns the database selected by the DSN, if any.
+func defaultDatabase(dbConnStr string) string {
+	cfg, err := mysql.ParseDSN(dbConnStr)
+	if err != nil {
+		return ""
+	}
+	return cfg.DBName
+}
+
+// replayDSN sets the connection charset of the replay sessions.
+func replayDSN(dbConnStr string, opts ReplayOptions) string {
+	if opts.Charset == "" {
+		return dbConnStr
 	}
+	cfg, err := mysql.ParseDSN(dbConnStr)
+	if err != nil {
+		return dbConnStr
+	}
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	cfg.Params["charset"] = opts.Charset
+	return cfg.FormatDSN()
+}
+
+func switchDatabase(conn *sql.Conn, dbName string) error {
+	_, err := conn.ExecContext(context.Background(), "USE "+quoteIdentifier(dbName))
+	return err
 }
 
-func StartSQLReplay(dbConnStr string, speed float64, slowOutputPath, replayOutputFilePath, filterUsername, filterSQLType, filterDBName, ignoreDigests string, lang string) {
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func StartSQLReplay(dbConnStr string, speed float64, slowOutputPath, replayOutputFilePath, filterUsername, filterSQLType, filterDBName, ignoreDigests string, lang string, opts ReplayOptions) {
 	if dbConnStr == "" || slowOutputPath == "" || replayOutputFilePath == "" {
 		fmt.Println(i18n.T(lang, "usage"))
 		return
@@ -202,6 +610,44 @@ func StartSQLReplay(dbConnStr string, speed float64, slowOutputPath, replayOutpu
 		fmt.Println(i18n.T(lang, "invalid_speed"))
 		return
 	}
+	if opts.CredentialsFile != "" {
+		credentials, err := loadCredentials(opts.CredentialsFile, dbConnStr)
+		if err != nil {
+			fmt.Println(i18n.T(lang, "credentials_error"), err)
+			return
+		}
+		opts.credentials = credentials
+		fmt.Printf(i18n.T(lang, "credentials_info")+"\n", credentials.Len(), opts.CredentialsFile)
+	}
+	if opts.SchemaMapFile != "" {
+		schemas, err := loadSchemaMap(opts.SchemaMapFile)
+		if err != nil {
+			fmt.Println(i18n.T(lang, "schema_map_error"), err)
+			return
+		}
+		opts.schemas = schemas
+	}
+	if opts.RewriteFile != "" {
+		rewrites, err := loadRewriteRules(opts.RewriteFile)
+		if err != nil {
+			fmt.Println(i18n.T(lang, "rewrite_rules_error"), err)
+			return
+		}
+		opts.rewrites = rewrites
+	}
+	if opts.RoutesFile != "" {
+		routes, err := loadRoutes(opts.RoutesFile)
+		if err != nil {
+			fmt.Println(i18n.T(lang, "routes_error"), err)
+			return
+		}
+		opts.routes = routes
+		fmt.Printf(i18n.T(lang, "routes_info")+"\n", routes.Len(), opts.RoutesFile)
+		if err := opts.credentials.checkRoutes(); err != nil {
+			fmt.Println(i18n.T(lang, "credentials_error"), err)
+			return
+		}
+	}
     var ignoreDigestList []string
     if ignoreDigests != "" {
         ignoreDigestList = strings.Split(ignoreDigests, ",")
@@ -230,7 +676,7 @@ func StartSQLReplay(dbConnStr string, speed float64, slowOutputPath, replayOutpu
 		wg.Add(1)
 		go func(connID string, entries []LogEntry) {
 			defer wg.Done()
-			ReplaySQLForConnection(connID, entries, dbConnStr, replayOutputFilePath, minTimestamp, speed, lang)
+			ReplaySQLForConnection(connID, entries, dbConnStr, replayOutputFilePath, minTimestamp, speed, lang, opts)
 		}(connID, entries)
 	}
 
@@ -158,7 +158,43 @@ func Report(dbConnStr, replayOut, Port string) {
             AVG(query_time) > 10000000
         ORDER BY
             avg(execution_time)/avg(query_time) desc`,
+        "Transaction Summary": `select count(*) txn_cnts,
+            sum(case when t.err_cnts=0 then 1 else 0 end) ok_txn_cnts,
+            sum(case when t.err_cnts>0 then 1 else 0 end) err_txn_cnts,
+            round(avg(case when t.err_cnts=0 then t.stmt_cnts end),2) avg_stmt_cnts,
+            round(avg(case when t.err_cnts=0 then t.query_time end)/1000,2) before_avg_txn_ms,
+            round(avg(case when t.err_cnts=0 then t.execution_time end)/1000,2) now_avg_txn_ms,
+            round(max(case when t.err_cnts=0 then t.query_time end)/1000,2) before_max_txn_ms,
+            round(max(case when t.err_cnts=0 then t.execution_time end)/1000,2) now_max_txn_ms
+        FROM
+            (select file_name,txn_id,count(*) stmt_cnts,sum(query_time) query_time,sum(execution_time) execution_time,
+                sum(case when error_info<>'' then 1 else 0 end) err_cnts
+            from replay_info where file_name like concat(?,'%') and txn_id<>'' group by file_name,txn_id) t`,
+        "Transaction Top 100 Slower": `SELECT
+            txn_id,max(ifnull(db_name,'')) db_name,
+            COUNT(*) AS stmt_cnts,
+            round(SUM(execution_time / 1000),2) AS current_ms,
+            round(SUM(query_time / 1000),2) AS before_ms,
+            concat(ROUND((SUM(execution_time) - SUM(query_time)) / SUM(query_time) ,2)*100,'%') AS reduce_pct,
+            MIN(sql_text) AS sample_sql_text
+        FROM
+            replay_info
+        WHERE
+            file_name like concat(?,'%') and txn_id<>''
+        GROUP BY
+            file_name,txn_id
+        HAVING
+            SUM(case when error_info<>'' then 1 else 0 end)=0
+        ORDER BY
+            SUM(execution_time)-SUM(query_time) desc
+        LIMIT 100`,
         "Sql Error Info": `select sql_digest,count(*) exec_cnts,concat(ifnull(max(db_name),''),':',substr(min(error_info),1,256)) as error_info,min(sql_text) as sample_sql_text from replay_info where error_info <>'' and file_name like concat(?,'%') group by sql_digest,substr(error_info,1,10) order by count(*) desc`,
+        "Rewritten SQL": `select sql_digest,count(*) exec_cnts,max(rewrite_rules) rewrite_rules,
+            sum(case when error_info<>'' then 1 else 0 end) err_cnts,
+            round(avg(query_time)/1000,2) before_avg_ms,
+            round(avg(execution_time)/1000,2) now_avg_ms,
+            min(sql_text) as sample_sql_text,min(rewritten_sql) as rewritten_sql_text
+        from replay_info where rewritten_sql<>'' and file_name like concat(?,'%') group by sql_digest order by count(*) desc`,
     }
 
     ts_begin_query := time.Now()
@@ -330,6 +366,12 @@ func Report(dbConnStr, replayOut, Port string) {
         <div class="blue-bar" id="{{ $key }}">{{ $key }}</div>
         {{ else if eq $key "Sql Error Info" }}
         <div class="blue-bar" id="{{ $key }}">{{ $key }}</div>
+        {{ else if eq $key "Transaction Summary" }}
+        <div class="blue-bar" id="{{ $key }}">{{ $key }}</div>
+        {{ else if eq $key "Transaction Top 100 Slower" }}
+        <div class="blue-bar" id="{{ $key }}">{{ $key }}</div>
+        {{ else if eq $key "Rewritten SQL" }}
+        <div class="blue-bar" id="{{ $key }}">{{ $key }}</div>
         {{ else }}
         <h1 id="{{ $key }}">{{ $key }}</h1>
         {{ end }}
@@ -345,7 +387,7 @@ func Report(dbConnStr, replayOut, Port string) {
                 {{range $query.Rows}}
                 <tr>
                     {{range $index, $value := .}}
-                        {{if eq (index $query.Columns $index) "sample_sql_text"}}
+                        {{if or (eq (index $query.Columns $index) "sample_sql_text") (eq (index $query.Columns $index) "rewritten_sql_text")}}
                             <td class="previewable">{{$value}}</td>
                         {{else}}
                             <td>{{$value}}</td>
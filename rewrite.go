@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/model"
+)
+
+// RewriteRule changes the statements it matches before they are replayed,
+// for targets that need small SQL differences. A statement matches when it
+// meets all the conditions given: one of the source digests, the statement
+// type and one of the tables, the last two read from the AST.
+type RewriteRule struct {
+	Name    string   `json:"name"`
+	Digests []string `json:"digests,omitempty"`
+	SQLType string   `json:"sql_type,omitempty"` // select, insert, replace, update or delete
+	Tables  []string `json:"tables,omitempty"`   // "table", or "db.table" with the session database for unqualified tables
+
+	// Replace sends this statement instead, the other actions change the
+	// AST of the matched statement.
+	Replace             string            `json:"replace,omitempty"`
+	RemoveCalcFoundRows bool              `json:"remove_calc_found_rows,omitempty"`
+	RemoveIndexHints    []string          `json:"remove_index_hints,omitempty"` // use, ignore, force
+	RenameFunctions     map[string]string `json:"rename_functions,omitempty"`
+	AddHints            string            `json:"add_hints,omitempty"` // e.g. "MAX_EXECUTION_TIME(1000)"
+
+	hints      []*ast.TableOptimizerHint
+	indexHints map[ast.IndexHintType]bool
+	functions  map[string]string
+}
+
+// rewriter applies rewrite rules in file order.
+type rewriter struct {
+	rules []*RewriteRule
+	// needAST is false when every rule is limited to digests, so the other
+	// statements are not parsed.
+	needAST bool
+}
+
+// loadRewriteRules reads a JSON array of RewriteRule and checks it.
+func loadRewriteRules(file string) (*rewriter, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*RewriteRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	rw := &rewriter{rules: rules}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", file, rule.Name, err)
+		}
+		if len(rule.Digests) == 0 {
+			rw.needAST = true
+		}
+	}
+	return rw, nil
+}
+
+func (r *RewriteRule) compile() error {
+	switch strings.ToLower(r.SQLType) {
+	case "", "select", "insert", "replace", "update", "delete":
+	default:
+		return fmt.Errorf("unknown sql_type %q", r.SQLType)
+	}
+	actions := 0
+	if r.Replace != "" {
+		actions++
+	}
+	if r.RemoveCalcFoundRows {
+		actions++
+	}
+	if len(r.RemoveIndexHints) > 0 {
+		actions++
+		r.indexHints = make(map[ast.IndexHintType]bool)
+		for _, kind := range r.RemoveIndexHints {
+			switch strings.ToLower(kind) {
+			case "use":
+				r.indexHints[ast.HintUse] = true
+			case "ignore":
+				r.indexHints[ast.HintIgnore] = true
+			case "force":
+				r.indexHints[ast.HintForce] = true
+			default:
+				return fmt.Errorf("unknown index hint %q", kind)
+			}
+		}
+	}
+	if len(r.RenameFunctions) > 0 {
+		actions++
+		r.functions = make(map[string]string)
+		for from, to := range r.RenameFunctions {
+			r.functions[strings.ToLower(from)] = to
+		}
+	}
+	if r.AddHints != "" {
+		actions++
+		stmt, err := parser.New().ParseOneStmt("SELECT /*+ "+r.AddHints+" */ 1", "", "")
+		if err != nil {
+			return err
+		}
+		r.hints = stmt.(*ast.SelectStmt).TableHints
+		if len(r.hints) == 0 {
+			return fmt.Errorf("invalid add_hints %q", r.AddHints)
+		}
+	}
+	if actions == 0 {
+		return fmt.Errorf("has no action")
+	}
+	if r.Replace != "" && actions > 1 {
+		return fmt.Errorf("replace cannot be combined with other actions")
+	}
+	return nil
+}
+
+// Rewrite returns the statement to send for sql, whose source digest is
+// digest, run on the source database dbName, the names of the rules that changed it and whether a rule
+// replaced it. sql is returned unchanged when no rule changes it, or when
+// the parser cannot read it.
+func (rw *rewriter) Rewrite(sql, digest, dbName string) (string, []string, bool) {
+	if rw == nil || sql == "" {
+		return sql, nil, false
+	}
+	if !rw.needAST && !rw.matchesDigest(digest) {
+		return sql, nil, false
+	}
+	p := parserPool.Get().(*parser.Parser)
+	stmts, _, err := p.Parse(sql, "", "")
+	parserPool.Put(p)
+	if err != nil || len(stmts) == 0 {
+		return sql, nil, false
+	}
+
+	var applied []string
+	for _, rule := range rw.rules {
+		if !rule.matches(stmts[0], digest, dbName) {
+			continue
+		}
+		if rule.Replace != "" {
+			return rule.Replace, append(applied, rule.Name), true
+		}
+		if rule.apply(stmts[0]) {
+			applied = append(applied, rule.Name)
+		}
+	}
+	if applied == nil {
+		return sql, nil, false
+	}
+	rewritten, err := restoreStmts(stmts)
+	if err != nil {
+		return sql, nil, false
+	}
+	return rewritten, applied, false
+}
+
+func (rw *rewriter) matchesDigest(digest string) bool {
+	for _, rule := range rw.rules {
+		if contains(rule.Digests, digest) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RewriteRule) matches(stmt ast.StmtNode, digest, dbName string) bool {
+	if len(r.Digests) > 0 && !contains(r.Digests, digest) {
+		return false
+	}
+	if r.SQLType != "" && !strings.EqualFold(r.SQLType, astStmtType(stmt)) {
+		return false
+	}
+	if len(r.Tables) > 0 {
+		tables := &tableCollector{}
+		stmt.Accept(tables)
+		names := tables.names(dbName)
+		for _, table := range r.Tables {
+			if contains(names, strings.ToLower(table)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// apply runs the AST actions and reports whether the statement changed.
+func (r *RewriteRule) apply(stmt ast.StmtNode) bool {
+	changed := false
+	if len(r.hints) > 0 {
+		// hints apply to the outer query block
+		switch n := stmt.(type) {
+		case *ast.SelectStmt:
+			n.TableHints, changed = append(n.TableHints, r.hints...), true
+		case *ast.InsertStmt:
+			n.TableHints, changed = append(n.TableHints, r.hints...), true
+		case *ast.UpdateStmt:
+			n.TableHints, changed = append(n.TableHints, r.hints...), true
+		case *ast.DeleteStmt:
+			n.TableHints, changed = append(n.TableHints, r.hints...), true
+		}
+	}
+	if r.RemoveCalcFoundRows || r.indexHints != nil || r.functions != nil {
+		v := &ruleVisitor{rule: r}
+		stmt.Accept(v)
+		changed = changed || v.changed
+	}
+	return changed
+}
+
+// astStmtType returns the type of a DML statement, "other" for the others.
+func astStmtType(stmt ast.StmtNode) string {
+	switch n := stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+		return "select"
+	case *ast.InsertStmt:
+		if n.IsReplace {
+			return "replace"
+		}
+		return "insert"
+	case *ast.UpdateStmt:
+		return "update"
+	case *ast.DeleteStmt:
+		return "delete"
+	}
+	return "other"
+}
+
+// tableCollector lists the tables of a statement.
+type tableCollector struct {
+	tables []*ast.TableName
+}
+
+func (c *tableCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if table, ok := n.(*ast.TableName); ok {
+		c.tables = append(c.tables, table)
+	}
+	return n, false
+}
+
+// names returns the tables as "table" and "db.table" in lower case, tables
+// without database are qualified with dbName when it is given.
+func (c *tableCollector) names(dbName string) []string {
+	var names []string
+	for _, table := range c.tables {
+		names = append(names, table.Name.L)
+		schema := table.Schema.L
+		if schema == "" {
+			schema = strings.ToLower(dbName)
+		}
+		if schema != "" {
+			names = append(names, schema+"."+table.Name.L)
+		}
+	}
+	return names
+}
+
+func (c *tableCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// ruleVisitor runs the actions of a rule that apply anywhere in the
+// statement, subqueries included.
+type ruleVisitor struct {
+	rule    *RewriteRule
+	changed bool
+}
+
+func (v *ruleVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch n := n.(type) {
+	case *ast.SelectStmt:
+		if v.rule.RemoveCalcFoundRows && n.SelectStmtOpts != nil && n.SelectStmtOpts.CalcFoundRows {
+			n.SelectStmtOpts.CalcFoundRows = false
+			v.changed = true
+		}
+	case *ast.TableName:
+		if v.rule.indexHints == nil {
+			break
+		}
+		kept := n.IndexHints[:0]
+		for _, hint := range n.IndexHints {
+			if !v.rule.indexHints[hint.HintType] {
+				kept = append(kept, hint)
+			}
+		}
+		if len(kept) != len(n.IndexHints) {
+			n.IndexHints = kept
+			v.changed = true
+		}
+	case *ast.FuncCallExpr:
+		if to, ok := v.rule.functions[n.FnName.L]; ok {
+			n.FnName = model.NewCIStr(to)
+			v.changed = true
+		}
+	case *ast.AggregateFuncExpr:
+		if to, ok := v.rule.functions[strings.ToLower(n.F)]; ok {
+			n.F = to
+			v.changed = true
+		}
+	}
+	return n, false
+}
+
+func (v *ruleVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	input := `[
+  {"name": "no_calc", "remove_calc_found_rows": true},
+  {"name": "no_force", "sql_type": "select", "tables": ["shop.orders"], "remove_index_hints": ["force"]},
+  {"name": "ifnull", "rename_functions": {"NVL": "IFNULL", "stddev": "stddev_pop"}},
+  {"name": "timeout", "sql_type": "select", "tables": ["users"], "add_hints": "MAX_EXECUTION_TIME(1000)"},
+  {"name": "fixed", "digests": ["d1"], "replace": "SELECT 1"}
+]`
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	rules, err := loadRewriteRules(path)
+	if err != nil {
+		t.Fatalf("loadRewriteRules failed: %v", err)
+	}
+
+	tests := []struct {
+		sql, digest, expected string
+		applied               []string
+	}{
+		// 没有规则命中时保持原文
+		{"select * from t /* keep */", "", "select * from t /* keep */", nil},
+		{"select sql_calc_found_rows * from t limit 10", "", "SELECT * FROM `t` LIMIT 10", []string{"no_calc"}},
+		{"select * from shop.orders force index (idx_a) use index (idx_b) where id = 1", "",
+			"SELECT * FROM `shop`.`orders` USE INDEX (`idx_b`) WHERE `id`=1", []string{"no_force"}},
+		// 未限定库名且会话不在 shop 库时不匹配 "shop.orders"
+		{"select * from orders force index (idx_a)", "", "select * from orders force index (idx_a)", nil},
+		{"select nvl(a, 0), stddev(b) from t where id in (select nvl(c, 1) from u)", "",
+			"SELECT IFNULL(`a`, 0),STDDEV_POP(`b`) FROM `t` WHERE `id` IN (SELECT IFNULL(`c`, 1) FROM `u`)", []string{"ifnull"}},
+		{"select sql_calc_found_rows * from users where id = 1", "",
+			"SELECT /*+ MAX_EXECUTION_TIME(1000)*/ * FROM `users` WHERE `id`=1", []string{"no_calc", "timeout"}},
+		// 改写后的语句保留 _binary 与 _charset 前缀
+		{"select sql_calc_found_rows * from t where k = _binary'\x01' and n = _latin1 'x'", "",
+			"SELECT * FROM `t` WHERE `k`=_BINARY'\x01' AND `n`=_LATIN1'x'", []string{"no_calc"}},
+		{"update users set a = 1", "", "update users set a = 1", nil},
+		{"select nvl(a, 0) from t", "d1", "SELECT 1", []string{"ifnull", "fixed"}},
+		// 无法解析的语句原样发送
+		{"select from", "", "select from", nil},
+	}
+	for _, tt := range tests {
+		sql, applied, replaced := rules.Rewrite(tt.sql, tt.digest, "")
+		if sql != tt.expected || !reflect.DeepEqual(applied, tt.applied) || replaced != (tt.digest == "d1") {
+			t.Errorf("Rewrite(%q) = %q, %v, %v\nexpected %q, %v", tt.sql, sql, applied, replaced, tt.expected, tt.applied)
+		}
+	}
+	// 未限定库名的表属于会话的当前库
+	if sql, applied, _ := rules.Rewrite("select * from orders force index (idx_a)", "", "shop"); sql != "SELECT * FROM `orders`" || len(applied) != 1 {
+		t.Errorf("Expected orders in the session database shop rewritten, got %q, %v", sql, applied)
+	}
+	var none *rewriter
+	if sql, applied, _ := none.Rewrite("select 1", "", ""); sql != "select 1" || applied != nil {
+		t.Errorf("Expected no rewriting without rules")
+	}
+
+	for _, bad := range []string{
+		`[{"name": "empty"}]`,
+		`[{"replace": "SELECT 1", "remove_calc_found_rows": true}]`,
+		`[{"sql_type": "merge", "remove_calc_found_rows": true}]`,
+		`[{"remove_index_hints": ["straight"]}]`,
+		`[{"add_hints": "NOT A HINT("}]`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatalf("Failed to write test input file: %v", err)
+		}
+		if _, err := loadRewriteRules(path); err == nil {
+			t.Errorf("Expected an error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/tidb/pkg/parser"
+)
+
+// reSessionStatement matches the statements that change the state of the
+// session: SET and USE.
+var reSessionStatement = regexp.MustCompile(`(?is)^\s*(set|use)\s`)
+
+// Route sends statements to a named target. A statement matches when it
+// meets all the conditions given: the source user, the source database and
+// one of the tables it names. dbname and tables are names or path.Match
+// patterns, tables are "table" or "db.table", unqualified tables being in
+// the database of the session.
+type Route struct {
+	Username string   `json:"username,omitempty"`
+	DBName   string   `json:"dbname,omitempty"`
+	Tables   []string `json:"tables,omitempty"`
+	Target   string   `json:"target"`
+}
+
+// RouteConfig is the file of -routes: the target DSNs by name and the
+// routes, tried in file order. Statements no route matches go to the -db
+// DSN.
+type RouteConfig struct {
+	Targets map[string]string `json:"targets"`
+	Routes  []Route           `json:"routes"`
+}
+
+// router picks the target of every replayed statement.
+type router struct {
+	targets map[string]string
+	routes  []Route
+	// byTable is true when some route names tables, which needs the
+	// statements to be parsed.
+	byTable bool
+}
+
+// loadRoutes reads and checks a RouteConfig.
+func loadRoutes(file string) (*router, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var config RouteConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for name, dsn := range config.Targets {
+		if name == "" {
+			return nil, fmt.Errorf("%s: a target has no name", file)
+		}
+		if _, err := mysql.ParseDSN(dsn); err != nil {
+			return nil, fmt.Errorf("%s: target %s: %w", file, name, err)
+		}
+	}
+	r := &router{targets: config.Targets, routes: config.Routes}
+	for i := range r.routes {
+		route := &r.routes[i]
+		if _, ok := r.targets[route.Target]; !ok {
+			return nil, fmt.Errorf("%s: route %d has an unknown target %q", file, i+1, route.Target)
+		}
+		if _, err := path.Match(route.DBName, ""); err != nil {
+			return nil, fmt.Errorf("%s: route %d: %w", file, i+1, err)
+		}
+		for j, table := range route.Tables {
+			route.Tables[j] = strings.ToLower(table)
+			if _, err := path.Match(route.Tables[j], ""); err != nil {
+				return nil, fmt.Errorf("%s: route %d: %w", file, i+1, err)
+			}
+		}
+		if len(route.Tables) > 0 {
+			r.byTable = true
+		}
+	}
+	return r, nil
+}
+
+// Target returns the target of a statement of username on dbName, "" for
+// the -db DSN, and whether the statement names a table. sql is empty for
+// the connect events.
+func (r *router) Target(username, dbName, sql string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	var names []string
+	if r.byTable && sql != "" {
+		p := parserPool.Get().(*parser.Parser)
+		stmts, _, err := p.Parse(sql, "", "")
+		parserPool.Put(p)
+		if err == nil {
+			tables := &tableCollector{}
+			for _, stmt := range stmts {
+				stmt.Accept(tables)
+			}
+			names = tables.names(dbName)
+		}
+	}
+	for _, route := range r.routes {
+		if route.matches(username, dbName, names) {
+			return route.Target, len(names) > 0
+		}
+	}
+	return "", len(names) > 0
+}
+
+func (route *Route) matches(username, dbName string, names []string) bool {
+	if route.Username != "" && route.Username != username {
+		return false
+	}
+	if route.DBName != "" {
+		if ok, _ := path.Match(route.DBName, dbName); !ok {
+			return false
+		}
+	}
+	if len(route.Tables) == 0 {
+		return true
+	}
+	for _, table := range route.Tables {
+		for _, name := range names {
+			if ok, _ := path.Match(table, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DSN returns the DSN of a target, base for "".
+func (r *router) DSN(target, base string) string {
+	if r == nil || target == "" {
+		return base
+	}
+	return r.targets[target]
+}
+
+// Len returns the number of targets.
+func (r *router) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.targets)
+}
+
+// sessionStatement returns "set" or "use" when sql is a session statement,
+// "" otherwise.
+func sessionStatement(sql string) string {
+	if match := reSessionStatement.FindStringSubmatch(sql); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return ""
+}
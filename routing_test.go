@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	input := `{
+  "targets": {
+    "tidb": "root:@tcp(10.0.0.1:4000)/",
+    "shard0": "app:p@tcp(10.0.1.1:3306)/",
+    "shard1": "app:p@tcp(10.0.1.2:3306)/"
+  },
+  "routes": [
+    {"tables": ["shop.orders_0?", "shop.users"], "target": "shard0"},
+    {"tables": ["shop.orders_1?"], "target": "shard1"},
+    {"username": "report", "target": "tidb"},
+    {"dbname": "crm_*", "target": "tidb"}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	routes, err := loadRoutes(path)
+	if err != nil {
+		t.Fatalf("loadRoutes failed: %v", err)
+	}
+	if routes.Len() != 3 {
+		t.Errorf("Expected 3 targets, got %d", routes.Len())
+	}
+
+	tests := []struct {
+		username, dbName, sql, target string
+		named                         bool
+	}{
+		// 未限定库名的表使用会话的当前库
+		{"app", "shop", "select * from orders_03 where id = 1", "shard0", true},
+		{"app", "shop", "update ORDERS_12 set a = 1", "shard1", true},
+		{"app", "other", "select * from shop.users u join orders_12 o on u.id = o.uid", "shard0", true},
+		{"app", "other", "select * from orders_03", "", true},
+		// 没有表的语句按用户与库路由
+		{"app", "shop", "begin", "", false},
+		{"report", "shop", "select * from shop.items", "tidb", true},
+		{"app", "crm_eu", "select 1", "tidb", false},
+		{"app", "crm_eu", "", "tidb", false},
+		{"app", "shop", "select from", "", false},
+	}
+	for _, tt := range tests {
+		target, named := routes.Target(tt.username, tt.dbName, tt.sql)
+		if target != tt.target || named != tt.named {
+			t.Errorf("Target(%s, %s, %q) = %q, %v, expected %q, %v", tt.username, tt.dbName, tt.sql, target, named, tt.target, tt.named)
+		}
+	}
+	base := "u:p@tcp(127.0.0.1:3306)/test"
+	if dsn := routes.DSN("shard1", base); dsn != "app:p@tcp(10.0.1.2:3306)/" {
+		t.Errorf("Unexpected DSN of shard1: %s", dsn)
+	}
+	if dsn := routes.DSN("", base); dsn != base {
+		t.Errorf("Expected the -db DSN without a target, got %s", dsn)
+	}
+	var none *router
+	if target, named := none.Target("app", "shop", "select * from orders_03"); target != "" || named || none.DSN("", base) != base {
+		t.Errorf("Expected the -db DSN without routes")
+	}
+
+	for input, message := range map[string]string{
+		`{"targets": {"a": "not a dsn"}}`:                                                    "target a",
+		`{"targets": {"a": "u:p@tcp(h:1)/"}, "routes": [{"target": "b"}]}`:                   "unknown target",
+		`{"targets": {"a": "u:p@tcp(h:1)/"}, "routes": [{"dbname": "db_[", "target": "a"}]}`: "syntax error",
+		`[]`: "cannot unmarshal",
+	} {
+		if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+			t.Fatalf("Failed to write test input file: %v", err)
+		}
+		if _, err := loadRoutes(path); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error with %q for %s, got %v", message, input, err)
+		}
+	}
+}
+
+// replayWithFakeDriver 用 fakeDriver 回放一个连接的语句，所有语句立即发送
+func replayWithFakeDriver(t *testing.T, entries []LogEntry, dbConnStr string, opts ReplayOptions) {
+	replayDriver = "fake"
+	defer func() { replayDriver = "mysql" }()
+	output := filepath.Join(t.TempDir(), "replay")
+	ReplaySQLForConnection("1", entries, dbConnStr, output, 0, 1, "en", opts)
+}
+
+func TestReplayRoutedTransaction(t *testing.T) {
+	base, shard := "root@tcp(10.0.0.1:4000)/shop", "app@tcp(10.0.1.1:3306)/shop"
+	routes := &router{
+		targets: map[string]string{"shard": shard},
+		routes:  []Route{{Tables: []string{"shop.orders"}, Target: "shard"}},
+		byTable: true,
+	}
+	// 源端的 commit 跟随事务的上一条语句发往分片，-db 上的提交失败时连接
+	// 已断开，下一条语句重新连接
+	fakeDB.reset(func(dsn, query string) error {
+		if dsn == base && query == "COMMIT" {
+			return mysql.ErrInvalidConn
+		}
+		return nil
+	})
+	var entries []LogEntry
+	for _, sql := range []string{
+		"begin",
+		"update users set a = 1",
+		"update orders set b = 1",
+		"commit",
+		"select * from users",
+	} {
+		entries = append(entries, LogEntry{ConnectionID: "1", DBName: "shop", SQL: sql})
+	}
+	replayWithFakeDriver(t, entries, base, ReplayOptions{routes: routes})
+
+	expected := map[string][]string{
+		base:  {"begin", "update users set a = 1", "COMMIT", "select * from users"},
+		shard: {"BEGIN", "update orders set b = 1", "commit"},
+	}
+	for dsn, stmts := range expected {
+		if actual := fakeDB.executed(dsn); !reflect.DeepEqual(actual, stmts) {
+			t.Errorf("Statements on %s = %q, expected %q", dsn, actual, stmts)
+		}
+	}
+	if n := fakeDB.connections(base); n != 2 {
+		t.Errorf("Expected the -db session opened again after the failed commit, got %d connections", n)
+	}
+}
+
+func TestReplayRoutedSessionStatements(t *testing.T) {
+	base, shard := "root@tcp(10.0.0.1:4000)/shop", "app@tcp(10.0.1.1:3306)/shop"
+	routes := &router{
+		targets: map[string]string{"shard": shard},
+		routes:  []Route{{Tables: []string{"shop.orders"}, Target: "shard"}},
+		byTable: true,
+	}
+	fakeDB.reset(nil)
+	var entries []LogEntry
+	for _, sql := range []string{
+		"set names latin1",
+		"set @v = 1",
+		"set @v = 2",
+		"set names latin1",
+		// 分片的会话打开时先执行此前的 SET
+		"select * from orders",
+		// 之后的 SET 发送到所有已打开的会话
+		"set time_zone = '+08:00'",
+		"update orders set a = @v",
+		// 子查询涉及表的 SET 只按路由发送
+		"set @n = (select count(*) from orders)",
+	} {
+		entries = append(entries, LogEntry{ConnectionID: "1", DBName: "shop", SQL: sql})
+	}
+	replayWithFakeDriver(t, entries, base, ReplayOptions{routes: routes})
+
+	expected := map[string][]string{
+		base: {"set names latin1", "set @v = 1", "set @v = 2", "set names latin1", "set time_zone = '+08:00'"},
+		shard: {"set @v = 1", "set @v = 2", "set names latin1", "select * from orders",
+			"set time_zone = '+08:00'", "update orders set a = @v", "set @n = (select count(*) from orders)"},
+	}
+	for dsn, stmts := range expected {
+		if actual := fakeDB.executed(dsn); !reflect.DeepEqual(actual, stmts) {
+			t.Errorf("Statements on %s = %q, expected %q", dsn, actual, stmts)
+		}
+	}
+}
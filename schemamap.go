@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	"github.com/pingcap/tidb/pkg/parser/model"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// SchemaRule renames a source database on the target. From is a database
+// name or a path.Match pattern such as "db_*" or "db_[0-9][0-9]", so shards
+// can be consolidated into one database.
+type SchemaRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// schemaMap renames the databases of the replayed sessions: their default
+// database and the qualified names in their statements.
+type schemaMap struct {
+	exact    map[string]string
+	patterns []SchemaRule
+}
+
+// loadSchemaMap reads a JSON array of SchemaRule. Names are matched before
+// patterns, patterns in file order.
+func loadSchemaMap(file string) (*schemaMap, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []SchemaRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	m := &schemaMap{exact: make(map[string]string)}
+	for i, rule := range rules {
+		if rule.From == "" || rule.To == "" {
+			return nil, fmt.Errorf("%s: rule %d needs from and to", file, i+1)
+		}
+		if _, err := path.Match(rule.From, ""); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", file, i+1, err)
+		}
+		if strings.ContainsAny(rule.From, `*?[\`) {
+			m.patterns = append(m.patterns, rule)
+		} else {
+			m.exact[rule.From] = rule.To
+		}
+	}
+	return m, nil
+}
+
+func (m *schemaMap) lookup(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	if to, ok := m.exact[name]; ok {
+		return to, to != name
+	}
+	for _, rule := range m.patterns {
+		if ok, _ := path.Match(rule.From, name); ok {
+			return rule.To, rule.To != name
+		}
+	}
+	return "", false
+}
+
+// Name returns the target name of a source database.
+func (m *schemaMap) Name(dbName string) string {
+	if m == nil {
+		return dbName
+	}
+	if to, ok := m.lookup(dbName); ok {
+		return to
+	}
+	return dbName
+}
+
+// Parsers are not safe for concurrent use, every replay session takes one.
+var parserPool = sync.Pool{New: func() interface{} { return parser.New() }}
+
+// Rewrite renames the databases of the qualified names in sql. It returns
+// sql unchanged when it names no mapped database, otherwise the statement
+// restored from the AST, which drops the comments.
+func (m *schemaMap) Rewrite(sql string) (string, error) {
+	if m == nil || sql == "" {
+		return sql, nil
+	}
+	p := parserPool.Get().(*parser.Parser)
+	stmts, _, err := p.Parse(sql, "", "")
+	parserPool.Put(p)
+	if err != nil {
+		return sql, err
+	}
+	renamer := &schemaRenamer{m: m}
+	for _, stmt := range stmts {
+		stmt.Accept(renamer)
+	}
+	if !renamer.changed {
+		return sql, nil
+	}
+	return restoreStmts(stmts)
+}
+
+// restoreStmts writes statements back as SQL text. Explicit introducers such
+// as _binary are kept, plain string literals get none and keep the connection
+// charset.
+func restoreStmts(stmts []ast.StmtNode) (string, error) {
+	var sb strings.Builder
+	for i, stmt := range stmts {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags|format.RestoreStringWithoutDefaultCharset, &sb)); err != nil {
+			return "", err
+		}
+	}
+	return sb.String(), nil
+}
+
+// schemaRenamer is the ast.Visitor renaming the databases.
+type schemaRenamer struct {
+	m       *schemaMap
+	changed bool
+}
+
+func (r *schemaRenamer) Enter(n ast.Node) (ast.Node, bool) {
+	switch n := n.(type) {
+	case *ast.TableName:
+		r.rename(&n.Schema)
+	case *ast.ColumnName:
+		r.rename(&n.Schema)
+	case *ast.SelectField:
+		// the wildcard of db.t.* is not visited
+		if n.WildCard != nil {
+			r.rename(&n.WildCard.Schema)
+		}
+	case *ast.UseStmt:
+		if to, ok := r.m.lookup(n.DBName); ok {
+			n.DBName, r.changed = to, true
+		}
+	case *ast.ShowStmt:
+		if to, ok := r.m.lookup(n.DBName); ok {
+			n.DBName, r.changed = to, true
+		}
+	}
+	return n, false
+}
+
+func (r *schemaRenamer) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+func (r *schemaRenamer) rename(name *model.CIStr) {
+	if to, ok := r.m.lookup(name.O); ok {
+		*name = model.NewCIStr(to)
+		r.changed = true
+	}
+}
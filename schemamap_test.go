@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSchemaMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	input := `[
+  {"from": "portal", "to": "portal_v2"},
+  {"from": "db_[0-9][0-9]", "to": "db_all"},
+  {"from": "db_01", "to": "db_first"}
+]`
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	schemas, err := loadSchemaMap(path)
+	if err != nil {
+		t.Fatalf("loadSchemaMap failed: %v", err)
+	}
+
+	// 精确匹配优先于模式匹配
+	for from, to := range map[string]string{"portal": "portal_v2", "db_07": "db_all", "db_01": "db_first", "db_100": "db_100", "": ""} {
+		if name := schemas.Name(from); name != to {
+			t.Errorf("Name(%q) = %q, expected %q", from, name, to)
+		}
+	}
+
+	tests := []struct {
+		sql, expected string
+	}{
+		// 没有需要改写的库名时保持原文
+		{"select * from t where note = 'portal.t' /* portal */", "select * from t where note = 'portal.t' /* portal */"},
+		{"SELECT a FROM other.t", "SELECT a FROM other.t"},
+		{"select portal.t.a, t.b, portal.t.* from portal.t join db_07.u on portal.t.id = db_07.u.id where c = 'portal.t'",
+			"SELECT `portal_v2`.`t`.`a`,`t`.`b`,`portal_v2`.`t`.* FROM `portal_v2`.`t` JOIN `db_all`.`u` ON `portal_v2`.`t`.`id`=`db_all`.`u`.`id` WHERE `c`='portal.t'"},
+		{"insert into `db_12`.orders (id) values (?)", "INSERT INTO `db_all`.`orders` (`id`) VALUES (?)"},
+		{"use portal", "USE `portal_v2`"},
+		{"show tables from db_01", "SHOW TABLES IN `db_first`"},
+		{"update portal.t set a = 1 where id in (select id from db_02.u)", "UPDATE `portal_v2`.`t` SET `a`=1 WHERE `id` IN (SELECT `id` FROM `db_all`.`u`)"},
+		// 显式的字符集前缀保留，字节串不按连接字符集重新解释
+		{"select * from portal.t where k = _binary'abc' and n = _latin1 'x'", "SELECT * FROM `portal_v2`.`t` WHERE `k`=_BINARY'abc' AND `n`=_LATIN1'x'"},
+	}
+	for _, tt := range tests {
+		sql, err := schemas.Rewrite(tt.sql)
+		if err != nil || sql != tt.expected {
+			t.Errorf("Rewrite(%q) = %q, %v\nexpected %q", tt.sql, sql, err, tt.expected)
+		}
+	}
+	if sql, err := schemas.Rewrite("select from portal.t"); err == nil || sql != "select from portal.t" {
+		t.Errorf("Expected the statement unchanged with a parse error, got %q, %v", sql, err)
+	}
+	var none *schemaMap
+	if sql, _ := none.Rewrite("select * from portal.t"); sql != "select * from portal.t" || none.Name("portal") != "portal" {
+		t.Errorf("Expected no renaming without a schema map")
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"from": "db_[", "to": "x"}]`), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	if _, err := loadSchemaMap(path); err == nil {
+		t.Errorf("Expected an error for a malformed pattern")
+	}
+}
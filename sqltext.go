@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// JSON strings are UTF-8, so encoding/json replaces the bytes of latin1 or
+// GBK literals and binary blobs with U+FFFD. Statements that are not valid
+// UTF-8 are therefore also written base64 encoded, in a "<field>_base64"
+// field next to the readable text, and read back from there.
+
+func encodeSQLText(text string) string {
+	if utf8.ValidString(text) {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(text))
+}
+
+func decodeSQLText(text *string, encoded string) error {
+	if encoded == "" {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	*text = string(data)
+	return nil
+}
+
+func (e LogEntry) MarshalJSON() ([]byte, error) {
+	type plain LogEntry
+	return json.Marshal(struct {
+		plain
+		SQLBase64     string `json:"sql_base64,omitempty"`
+		StmtSQLBase64 string `json:"stmt_sql_base64,omitempty"`
+	}{plain(e), encodeSQLText(e.SQL), encodeSQLText(e.StmtSQL)})
+}
+
+func (e *LogEntry) UnmarshalJSON(data []byte) error {
+	type plain LogEntry
+	aux := struct {
+		*plain
+		SQLBase64     string `json:"sql_base64"`
+		StmtSQLBase64 string `json:"stmt_sql_base64"`
+	}{plain: (*plain)(e)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if err := decodeSQLText(&e.SQL, aux.SQLBase64); err != nil {
+		return err
+	}
+	return decodeSQLText(&e.StmtSQL, aux.StmtSQLBase64)
+}
+
+func (r SQLExecutionRecord) MarshalJSON() ([]byte, error) {
+	type plain SQLExecutionRecord
+	return json.Marshal(struct {
+		plain
+		SQLBase64          string `json:"sql_base64,omitempty"`
+		RewrittenSQLBase64 string `json:"rewritten_sql_base64,omitempty"`
+	}{plain(r), encodeSQLText(r.SQL), encodeSQLText(r.RewrittenSQL)})
+}
+
+func (r *SQLExecutionRecord) UnmarshalJSON(data []byte) error {
+	type plain SQLExecutionRecord
+	aux := struct {
+		*plain
+		SQLBase64          string `json:"sql_base64"`
+		RewrittenSQLBase64 string `json:"rewritten_sql_base64"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if err := decodeSQLText(&r.SQL, aux.SQLBase64); err != nil {
+		return err
+	}
+	return decodeSQLText(&r.RewrittenSQL, aux.RewrittenSQLBase64)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLTextRoundTrip(t *testing.T) {
+	// latin1 的 é 与二进制数据都不是合法的 UTF-8
+	latin1 := "insert into t values ('caf\xe9', _binary'\x00\xff\xfe')"
+	entry := LogEntry{ConnectionID: "1", SQL: latin1, StmtSQL: "select ? from t where c = '\xe9'"}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("Failed to marshal entry: %v", err)
+	}
+	if !strings.Contains(string(data), `"sql_base64":`) || !strings.Contains(string(data), `"stmt_sql_base64":`) {
+		t.Errorf("Missing encoded SQL in %s", data)
+	}
+	var decoded LogEntry
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal entry: %v", err)
+	}
+	if decoded.SQL != latin1 || decoded.StmtSQL != entry.StmtSQL || decoded.ConnectionID != "1" {
+		t.Errorf("Unexpected entry after round trip: %+v", decoded)
+	}
+
+	// 合法的 UTF-8 不输出编码字段
+	data, _ = json.Marshal(&LogEntry{SQL: "select 'café'"})
+	if strings.Contains(string(data), "base64") {
+		t.Errorf("Unexpected encoded SQL in %s", data)
+	}
+
+	record := SQLExecutionRecord{SQL: latin1, ExecutionTime: 5}
+	data, _ = json.Marshal(record)
+	var decodedRecord SQLExecutionRecord
+	if err := json.Unmarshal(data, &decodedRecord); err != nil {
+		t.Fatalf("Failed to unmarshal record: %v", err)
+	}
+	if decodedRecord.SQL != latin1 || decodedRecord.ExecutionTime != 5 {
+		t.Errorf("Unexpected record after round trip: %+v", decodedRecord)
+	}
+}
+
+func TestParseLogsNonUTF8(t *testing.T) {
+	dir := t.TempDir()
+
+	// GBK 编码的 "中文"
+	sql := "select * from t where c = '\xd6\xd0\xce\xc4';"
+	input := "# Time: 2024-01-19T16:29:48.141142Z\n# User@Host: t1[t1] @  [10.2.103.21]  Id:   797\n" +
+		"# Query_time: 0.000038  Lock_time: 0.000000 Rows_sent: 1  Rows_examined: 1\nSET timestamp=1705681788;\n" + sql + "\n"
+	slowLogPath := filepath.Join(dir, "slow.log")
+	outputPath := filepath.Join(dir, "slow.json")
+	if err := os.WriteFile(slowLogPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write test input file: %v", err)
+	}
+	ParseLogs(slowLogPath, outputPath)
+
+	entries := readLogEntriesForTest(t, outputPath)
+	if len(entries) != 1 || entries[0].SQL != sql {
+		t.Fatalf("Unexpected entries %+v", entries)
+	}
+}
+
+func TestReplayDSN(t *testing.T) {
+	dsn := "user:password@tcp(127.0.0.1:3306)/test"
+	if got := replayDSN(dsn, ReplayOptions{}); got != dsn {
+		t.Errorf("replayDSN without charset = %q", got)
+	}
+	if got := replayDSN(dsn, ReplayOptions{Charset: "gbk"}); !strings.Contains(got, "charset=gbk") || !strings.Contains(got, "/test") {
+		t.Errorf("replayDSN = %q", got)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// txnAction classifies statements that change the transaction state of a
+// session.
+type txnAction int
+
+const (
+	txnNone txnAction = iota
+	txnBegin
+	txnCommit
+	txnRollback
+	txnAutocommitOff
+	txnAutocommitOn
+)
+
+var (
+	reTxnBegin      = regexp.MustCompile(`(?is)^\s*(begin(\s+work)?|start\s+transaction\b.*?)\s*;?\s*$`)
+	reTxnCommit     = regexp.MustCompile(`(?is)^\s*commit(\s+work)?(\s+and\s+no\s+chain)?(\s+no\s+release)?\s*;?\s*$`)
+	reTxnRollback   = regexp.MustCompile(`(?is)^\s*rollback(\s+work)?(\s+and\s+no\s+chain)?(\s+no\s+release)?\s*;?\s*$`)
+	reTxnAutocommit = regexp.MustCompile(`(?is)^\s*set\s+(session\s+|@@session\.|@@local\.|@@|local\s+)?autocommit\s*(=|:=)\s*'?(\w+)'?\s*;?\s*$`)
+)
+
+func classifyTxnStatement(sql string) txnAction {
+	if reTxnBegin.MatchString(sql) {
+		return txnBegin
+	}
+	if reTxnCommit.MatchString(sql) {
+		return txnCommit
+	}
+	if reTxnRollback.MatchString(sql) {
+		return txnRollback
+	}
+	if match := reTxnAutocommit.FindStringSubmatch(sql); match != nil {
+		switch strings.ToLower(match[3]) {
+		case "0", "off", "false":
+			return txnAutocommitOff
+		default:
+			return txnAutocommitOn
+		}
+	}
+	return txnNone
+}
+
+// errDeadlock (ER_LOCK_DEADLOCK) rolls back the whole transaction, most other
+// errors only fail the statement.
+const errDeadlock = 1213
+
+// abortsTxn reports whether a statement that failed on the target with err
+// ends the replayed transaction: the source ran it without error, so the
+// rest of the transaction would not see the same data, or the target rolled
+// the transaction back. An error the source also got (sourceErrorCode) was
+// handled by the application and the transaction goes on.
+func abortsTxn(err error, sourceErrorCode int) bool {
+	if err == nil {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errDeadlock {
+		return true
+	}
+	return sourceErrorCode == 0
+}
+
+// txnTracker follows the transaction state of one replayed session. Every
+// statement executed inside a transaction is tagged with the transaction id,
+// and after a failed statement the rest of the transaction is skipped until
+// the source session ends it.
+type txnTracker struct {
+	connID     string
+	autocommit bool
+	inTxn      bool
+	aborted    bool
+	seq        int
+	txnID      string
+}
+
+func newTxnTracker(connID string) *txnTracker {
+	return &txnTracker{connID: connID, autocommit: true}
+}
+
+func (t *txnTracker) start() {
+	t.seq++
+	t.txnID = fmt.Sprintf("%s-%d", t.connID, t.seq)
+	t.inTxn = true
+	t.aborted = false
+}
+
+func (t *txnTracker) finish() {
+	t.inTxn = false
+	t.aborted = false
+}
+
+// reset forgets the state of a session that ended, the source rolled back
+// its open transaction. Transaction ids go on counting.
+func (t *txnTracker) reset() {
+	t.finish()
+	t.autocommit = true
+}
+
+// begin is called before a statement runs. It returns the transaction id the
+// statement belongs to and whether it must be skipped because the
+// transaction was already rolled back.
+func (t *txnTracker) begin(action txnAction) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	switch action {
+	case txnBegin:
+		// BEGIN implicitly commits an open transaction.
+		t.start()
+		return t.txnID, false
+	case txnAutocommitOn, txnAutocommitOff:
+		if t.inTxn && action == txnAutocommitOn {
+			txnID, aborted := t.txnID, t.aborted
+			t.finish()
+			if aborted {
+				return "", false
+			}
+			return txnID, false
+		}
+		return "", false
+	case txnCommit, txnRollback:
+		if !t.inTxn {
+			return "", false
+		}
+		return t.txnID, t.aborted
+	default:
+		if !t.inTxn && !t.autocommit {
+			t.start()
+		}
+		if !t.inTxn {
+			return "", false
+		}
+		return t.txnID, t.aborted
+	}
+}
+
+// end is called after a statement ran. It reports whether the session must be
+// rolled back because a statement inside a transaction failed.
+func (t *txnTracker) end(action txnAction, failed bool) bool {
+	if t == nil {
+		return false
+	}
+	switch action {
+	case txnCommit, txnRollback:
+		t.finish()
+		return false
+	case txnAutocommitOff:
+		t.autocommit = false
+		return false
+	case txnAutocommitOn:
+		t.autocommit = true
+		return false
+	}
+	if failed && t.inTxn && !t.aborted {
+		t.aborted = true
+		return true
+	}
+	return false
+}
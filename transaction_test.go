@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestClassifyTxnStatement(t *testing.T) {
+	cases := map[string]txnAction{
+		"BEGIN":                            txnBegin,
+		"begin work;":                      txnBegin,
+		"START TRANSACTION READ ONLY":      txnBegin,
+		"COMMIT;":                          txnCommit,
+		"rollback":                         txnRollback,
+		"ROLLBACK TO SAVEPOINT s1":         txnNone,
+		"SET autocommit=0":                 txnAutocommitOff,
+		"set @@session.autocommit = OFF;":  txnAutocommitOff,
+		"SET autocommit=1":                 txnAutocommitOn,
+		"select * from t where begin = 1":  txnNone,
+		"UPDATE t SET autocommit_flag = 0": txnNone,
+	}
+	for sql, expected := range cases {
+		if actual := classifyTxnStatement(sql); actual != expected {
+			t.Errorf("classifyTxnStatement(%q) = %v, expected %v", sql, actual, expected)
+		}
+	}
+}
+
+func TestTxnTracker(t *testing.T) {
+	txn := newTxnTracker("7")
+
+	// 自动提交模式下的语句不属于任何事务
+	if id, skip := txn.begin(txnNone); id != "" || skip {
+		t.Fatalf("autocommit statement got txn id %q, skip %v", id, skip)
+	}
+	txn.end(txnNone, false)
+
+	// 显式事务内失败的语句触发回滚，后续语句被跳过
+	id, _ := txn.begin(txnBegin)
+	if id != "7-1" {
+		t.Fatalf("expected txn id 7-1, got %q", id)
+	}
+	txn.end(txnBegin, false)
+	txn.begin(txnNone)
+	if !txn.end(txnNone, true) {
+		t.Fatalf("failed statement inside a transaction should request a rollback")
+	}
+	if id, skip := txn.begin(txnNone); id != "7-1" || !skip {
+		t.Fatalf("statement after failure got txn id %q, skip %v", id, skip)
+	}
+	txn.end(txnNone, false)
+	if _, skip := txn.begin(txnCommit); !skip {
+		t.Fatalf("commit of a rolled back transaction should be skipped")
+	}
+	txn.end(txnCommit, false)
+
+	// autocommit=0 时第一条语句隐式开启事务
+	txn.begin(txnAutocommitOff)
+	txn.end(txnAutocommitOff, false)
+	if id, _ := txn.begin(txnNone); id != "7-2" {
+		t.Fatalf("expected implicit txn id 7-2, got %q", id)
+	}
+	txn.end(txnNone, false)
+	if id, _ := txn.begin(txnCommit); id != "7-2" {
+		t.Fatalf("expected commit in txn 7-2, got %q", id)
+	}
+	txn.end(txnCommit, false)
+	if id, _ := txn.begin(txnNone); id != "7-3" {
+		t.Fatalf("expected next implicit txn id 7-3, got %q", id)
+	}
+}
+
+func TestAbortsTxn(t *testing.T) {
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	cases := []struct {
+		name            string
+		err             error
+		sourceErrorCode int
+		expected        bool
+	}{
+		{"成功的语句", nil, 0, false},
+		// 源端成功而目标端失败，后续语句的数据与源端不一致
+		{"源端成功、目标端主键冲突", duplicate, 0, true},
+		{"源端成功、目标端其他错误", errors.New("invalid connection"), 0, true},
+		// 源端同样失败的语句已被应用处理，事务继续
+		{"源端与目标端都主键冲突", duplicate, 1062, false},
+		{"源端未找到、目标端主键冲突", duplicate, 1032, false},
+		// 死锁会回滚整个事务
+		{"源端失败、目标端死锁", deadlock, 1062, true},
+		{"源端成功、目标端死锁", deadlock, 0, true},
+	}
+	for _, c := range cases {
+		if actual := abortsTxn(c.err, c.sourceErrorCode); actual != c.expected {
+			t.Errorf("%s: abortsTxn = %v, expected %v", c.name, actual, c.expected)
+		}
+	}
+}
+
+func TestExecuteSQLAndRecordRollbackFailed(t *testing.T) {
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}
+	fakeDB.reset(func(dsn, query string) error {
+		switch query {
+		case "insert into t values (1)":
+			return duplicate
+		case "ROLLBACK":
+			return mysql.ErrInvalidConn
+		}
+		return nil
+	})
+	db, err := sql.Open("fake", "rollback")
+	if err != nil {
+		t.Fatalf("Failed to open fake db: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open fake conn: %v", err)
+	}
+	defer conn.Close()
+
+	output := filepath.Join(t.TempDir(), "replay")
+	txn := newTxnTracker("1")
+	for _, q := range []string{"BEGIN", "insert into t values (1)"} {
+		err = ExecuteSQLAndRecord(SQLTask{Entry: LogEntry{ConnectionID: "1", SQL: q}, Conn: conn, Txn: txn}, output)
+	}
+	// 回滚失败时连接已断开，按会话丢失处理
+	if !errors.Is(err, errSessionLost) {
+		t.Fatalf("Expected errSessionLost after a failed rollback, got %v", err)
+	}
+	if executed := fakeDB.executed("rollback"); len(executed) != 3 || executed[2] != "ROLLBACK" {
+		t.Fatalf("Expected a rollback after the failed insert, got %q", executed)
+	}
+
+	data, err := os.ReadFile(output + ".1")
+	if err != nil {
+		t.Fatalf("Failed to read replay output: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var record SQLExecutionRecord
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &record); err != nil {
+		t.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+	if !strings.Contains(record.ErrorInfo, "Duplicate entry") || !strings.Contains(record.ErrorInfo, "rollback failed: "+mysql.ErrInvalidConn.Error()) {
+		t.Errorf("Expected the failed rollback recorded, got %q", record.ErrorInfo)
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 var translations = map[string]map[string]string{
     "en": {
-        "usage": "Usage: ./sql-replay -mode replay -db <mysql_connection_string> -speed 1.0 -slow-out <slow_output_file> -replay-out <replay_output_file> -username <all|username> -sqltype <all|select> -dbname <all|dbname> -lang <language_code>",
+        "usage": "Usage: ./sql-replay -mode replay -db <mysql_connection_string> -speed 1.0 -slow-out <slow_output_file> -replay-out <replay_output_file> -username <all|username> -sqltype <all|select> -dbname <all|dbname> -credentials <credentials_file> -schema-map <schema_map_file> -rewrite-rules <rewrite_rules_file> -routes <routes_file> -prepared -charset <charset> -lang <language_code>",
         "invalid_speed": "Invalid replay speed. The speed must be a positive number.",
         "replay_info": "Filter Rule: Source user - %s, Source database - %s, Source SQL type - %s, Replay speed: %f",
         "parsing_start": "Parameters read successfully, starting data parsing",
@@ -12,11 +12,20 @@ var translations = map[string]map[string]string{
         "replay_start": "Starting SQL replay",
         "db_open_error": "Error opening database for %s:",
         "sql_exec_error": "Error executing SQL for %s:",
+        "session_lost": "Connection %s lost its target session, reconnecting at the next statement: %v",
+        "db_switch_error": "Error switching database for %s to %s: %v",
+        "target_sync_error": "Error for connection %s on target %s running %s: %v",
+        "credentials_error": "Error reading credentials file:",
+        "credentials_info": "Credentials: %d source users mapped to target accounts from %s",
+        "schema_map_error": "Error reading schema map file:",
+        "rewrite_rules_error": "Error reading rewrite rules file:",
+        "routes_error": "Error reading routes file:",
+        "routes_info": "Routes: statements sent to %d targets from %s",
         "replay_complete": "SQL replay completed",
         "replay_time": "SQL replay time:",
     },
     "zh": {
-        "usage": "用法: ./sql-replay -mode replay -db <mysql连接字符串> -speed 1.0 -slow-out <慢查询输出文件> -replay-out <回放输出文件> -username <all|用户名> -sqltype <all|select> -dbname <all|数据库名> -lang <语言代码>",
+        "usage": "用法: ./sql-replay -mode replay -db <mysql连接字符串> -speed 1.0 -slow-out <慢查询输出文件> -replay-out <回放输出文件> -username <all|用户名> -sqltype <all|select> -dbname <all|数据库名> -credentials <账号映射文件> -schema-map <库名映射文件> -rewrite-rules <改写规则文件> -routes <路由文件> -prepared -charset <字符集> -lang <语言代码>",
         "invalid_speed": "无效的回放速度。速度必须是正数。",
         "replay_info": "过滤规则：源端用户 - %s，源端数据库 - %s，源端 SQL 类型 - %s，回放速度: %f",
         "parsing_start": "参数读取成功，开始解析数据",
@@ -26,6 +35,15 @@ var translations = map[string]map[string]string{
         "replay_start": "开始 SQL 回放",
         "db_open_error": "为 %s 打开数据库时出错:",
         "sql_exec_error": "执行 %s 的 SQL 时出错:",
+        "session_lost": "连接 %s 的目标端会话已断开，下一条语句时重新连接: %v",
+        "db_switch_error": "为 %s 切换数据库到 %s 时出错: %v",
+        "target_sync_error": "连接 %s 在目标 %s 上执行 %s 时出错: %v",
+        "credentials_error": "读取账号映射文件出错:",
+        "credentials_info": "账号映射：从 %[2]s 读取 %[1]d 个源端用户到目标端账号的映射",
+        "schema_map_error": "读取库名映射文件出错:",
+        "rewrite_rules_error": "读取改写规则文件出错:",
+        "routes_error": "读取路由文件出错:",
+        "routes_info": "路由：从 %[2]s 读取 %[1]d 个目标库",
         "replay_complete": "SQL 回放完成",
         "replay_time": "SQL 回放时间:",
     },